@@ -0,0 +1,38 @@
+// Command auditverify checks the hash chain of the gophermart audit log.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"gophermart/internal/storage"
+)
+
+func main() {
+	databaseURI := flag.String("d", os.Getenv("DATABASE_URI"), "База данных SQL")
+	maxUnsealedAge := flag.Duration("u", 5*time.Minute, "Допустимый возраст незапечатанных записей, 0 отключает проверку")
+	flag.Parse()
+	if *databaseURI == "" {
+		fmt.Fprintln(os.Stderr, "storage address not provided")
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("pgx", *databaseURI)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "open DB:", err)
+		os.Exit(2)
+	}
+
+	verified, err := storage.VerifyAudit(db, *maxUnsealedAge)
+	db.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit chain verification failed after %d entries: %s\n", verified, err)
+		os.Exit(1)
+	}
+	fmt.Printf("audit chain ok: %d entries verified\n", verified)
+}
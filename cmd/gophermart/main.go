@@ -1,21 +1,43 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"gophermart/internal/accrualreader"
 	"gophermart/internal/config"
+	"gophermart/internal/events"
+	"gophermart/internal/expirer"
+	"gophermart/internal/grpcapi"
 	"gophermart/internal/handlers"
+	"gophermart/internal/health"
 	"gophermart/internal/logger"
+	"gophermart/internal/metrics"
+	"gophermart/internal/ratelimit"
+	"gophermart/internal/rbac"
+	"gophermart/internal/realip"
+	"gophermart/internal/reconciler"
 	"gophermart/internal/router"
+	"gophermart/internal/sealer"
+	"gophermart/internal/service"
 	"gophermart/internal/storage"
+	"gophermart/internal/tier"
+	"gophermart/internal/tracing"
+	"gophermart/internal/validation"
+	"gophermart/internal/webhook"
 )
 
+// shutdownTimeout bounds the wait for requests in flight, event streams are closed after it.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	logger.Newlogger()
 	log.Info().Msg("Start program")
@@ -23,21 +45,95 @@ func main() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("NewConfig read environment error")
 	}
-	strg := storage.NewStorage(cnfg)
+	shutdownTracing, err := tracing.Setup(cnfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("tracing.Setup init error")
+	}
+	// Tracing wraps the rest so that storage.WithContext reaches it.
+	strg := tracing.NewStorage(metrics.NewStorage(storage.NewStorage(cnfg)))
+	metrics.RegisterStats(strg)
 	log.Debug().Msg("storage init")
-	accrual := accrualreader.NewAccrualReader(cnfg.AccuralSystemAddress)
+	for _, login := range cnfg.AdminLogins {
+		if err = strg.SetUserRole(login, rbac.RoleAdmin); err != nil {
+			log.Warn().Err(err).Msgf("SetUserRole admin %s err", login)
+		}
+	}
+	accrual := accrualreader.NewAccrualReader(cnfg)
 	accrual.Run(strg)
-	hndlr := handlers.NewHandler(cnfg, strg)
-	router := router.NewRouter(hndlr)
+	reconcile := reconciler.NewReconciler(cnfg.ReconcileInterval, cnfg.ReconcileFix)
+	reconcile.Run(strg)
+	expire := expirer.NewExpirer(cnfg.ExpiryInterval)
+	expire.Run(strg)
+	deliverer := webhook.NewDeliverer(cnfg)
+	deliverer.Run(strg)
+	seal := sealer.NewSealer(cnfg.AuditSealInterval)
+	seal.Run(strg)
+	validator, err := validation.NewValidator(cnfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("NewValidator init error")
+	}
+	tiers, err := tier.Parse(cnfg.Tiers)
+	if err != nil {
+		log.Fatal().Err(err).Msg("tier.Parse read tiers error")
+	}
+	recalculator := tier.NewRecalculator(tiers, cnfg.TierBasis, cnfg.TierInterval)
+	if len(tiers) != 0 {
+		recalculator.Run(strg)
+	}
+	bus := events.NewBus()
+	listener := events.NewListener(bus)
+	listener.Run(strg)
+	services := service.New(cnfg, strg, validator)
+	hndlr := handlers.NewHandler(cnfg, strg, services, tiers, bus)
+	var counter ratelimit.Counter = ratelimit.NewMemoryCounter()
+	if cnfg.RateLimitStore == "postgres" {
+		counter = strg
+	}
+	limiter, err := ratelimit.NewLimiter(cnfg, counter, services.Users)
+	if err != nil {
+		log.Fatal().Err(err).Msg("NewLimiter read policies error")
+	}
+	resolver, err := realip.NewResolver(cnfg.TrustedProxies)
+	if err != nil {
+		log.Fatal().Err(err).Msg("NewResolver read trusted proxies error")
+	}
+	checker := health.NewChecker(strg, accrual, cnfg.ReadyHeartbeatAge)
+	router := router.NewRouter(cnfg, hndlr, resolver, limiter, checker)
 	log.Debug().Msg("handler init")
 
+	server := &http.Server{Addr: cnfg.RunAddress, Handler: router}
 	go func() {
-		err := http.ListenAndServe(cnfg.RunAddress, router)
-		if err != nil {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal().Msgf("server failed: %s", err)
 		}
 	}()
 
+	grpcServer := grpcapi.NewServer(cnfg, services, strg, limiter)
+	if cnfg.GRPCAddress != "" {
+		listen, err := net.Listen("tcp", cnfg.GRPCAddress)
+		if err != nil {
+			log.Fatal().Err(err).Msg("gRPC listen error")
+		}
+		go func() {
+			if err := grpcServer.Serve(listen); err != nil {
+				log.Fatal().Msgf("gRPC server failed: %s", err)
+			}
+		}()
+	}
+
+	// Metrics are served on a listener of their own so they are not exposed with the API.
+	var metricsServer *http.Server
+	if cnfg.MetricsAddress != "" {
+		metricsServer = &http.Server{Addr: cnfg.MetricsAddress, Handler: metrics.Handler()}
+		go func() {
+			err := metricsServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal().Msgf("metrics server failed: %s", err)
+			}
+		}()
+	}
+
 	sigChan := make(chan os.Signal, 10)
 	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
@@ -45,8 +141,33 @@ func main() {
 		switch sig {
 		case syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT:
 			log.Info().Msgf("OS cmd received signal %s", sig)
+			// Fail readiness first and keep serving for a while so the instance leaves the rotation
+			// before it stops accepting requests.
+			checker.ShuttingDown()
+			time.Sleep(cnfg.ShutdownDelay)
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			if err := server.Shutdown(ctx); err != nil {
+				log.Error().Err(err).Msg("server shutdown error")
+				server.Close()
+			}
+			cancel()
+			if metricsServer != nil {
+				metricsServer.Close()
+			}
+			grpcServer.GracefulStop()
 			accrual.Stop()
+			reconcile.Stop()
+			expire.Stop()
+			deliverer.Stop()
+			seal.Stop()
+			listener.Stop()
+			if len(tiers) != 0 {
+				recalculator.Stop()
+			}
 			strg.CloseDB()
+			if err := shutdownTracing(context.Background()); err != nil {
+				log.Error().Err(err).Msg("tracing shutdown error")
+			}
 			os.Exit(0)
 
 		}
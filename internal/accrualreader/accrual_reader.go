@@ -5,24 +5,37 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 
+	"gophermart/internal/config"
+	"gophermart/internal/metrics"
 	"gophermart/internal/storage"
+	"gophermart/internal/tracing"
 )
 
+// heartbeatInterval is how often the reader beats while it waits out a Retry-After.
+const heartbeatInterval = time.Second
+
 type AccrualReader struct {
 	AccuralSystemAddress string
+	client               *http.Client
+	circuit              *circuit
+	heartbeat            atomic.Int64
 	ctx                  context.Context
 	cancel               context.CancelFunc
 	finished             chan struct{}
 }
 
-func NewAccrualReader(address string) *AccrualReader {
+func NewAccrualReader(cfg *config.Config) *AccrualReader {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &AccrualReader{
-		AccuralSystemAddress: address,
+		AccuralSystemAddress: cfg.AccuralSystemAddress,
+		client:               &http.Client{Transport: tracing.NewTransport(http.DefaultTransport), Timeout: cfg.AccrualTimeout},
+		circuit:              newCircuit(cfg.CircuitFailures, cfg.CircuitCooldown),
 		ctx:                  ctx,
 		cancel:               cancel,
 		finished:             make(chan struct{}),
@@ -32,8 +45,10 @@ func NewAccrualReader(address string) *AccrualReader {
 func (ar *AccrualReader) Run(strg storage.Storager) {
 	go func() {
 		log.Debug().Msg("AccrualReader started")
+		metrics.WorkerStarted("accrual_reader")
 	loop:
 		for {
+			ar.beat()
 			select {
 			case <-ar.ctx.Done():
 				break loop
@@ -45,69 +60,124 @@ func (ar *AccrualReader) Run(strg storage.Storager) {
 					continue
 				}
 				for _, order := range ordersToUpd {
-					log.Debug().Msgf("AccrualReader order: %s", order)
-					request, err := http.NewRequest(http.MethodGet, ar.AccuralSystemAddress+"/api/orders/"+order.Order, nil)
-					if err != nil {
-						log.Error().Err(err).Msg("NewRequest process run error")
-						continue
-					}
-					result, err := http.DefaultClient.Do(request)
-					if err != nil {
-						log.Error().Err(err).Msg("http.DefaultClient process run error")
-						continue
-					}
-					accuralResultBZ, err := io.ReadAll(result.Body)
-					if err != nil {
-						log.Error().Err(err).Msg("Read result.Body process run error")
-						continue
-					}
-					defer result.Body.Close()
-
-					log.Debug().Msgf("AccrualReader received status: %d", result.StatusCode)
-					if result.StatusCode == 429 {
-						t, err := time.ParseDuration(result.Header.Get("Retry-After") + "s")
-						if err != nil {
-							log.Error().Err(err).Msg("ParseDuration process run error")
-							continue
-						}
-						time.Sleep(t * time.Second)
-						continue
+					if !ar.circuit.allow(time.Now()) {
+						break
 					}
-					var responce storage.AccuralResult
-					if err = json.Unmarshal(accuralResultBZ, &responce); err != nil {
-						log.Error().Err(err).Msg("Unmarshal process run error")
-						return
-					}
-					if result.StatusCode == 200 {
-						if responce.Status == order.Status {
-							continue
-						} else {
-							responce.UserID = order.UserID
-							err := strg.UpdateOrderStatus(responce)
-							if err != nil {
-								log.Error().Err(err).Msg("GetProcessedOrders UpdateOrderStatus error")
-								continue
-							}
-						}
-					}
-					if result.StatusCode == 204 {
-						err := strg.UpdateOrderStatus(responce)
-							if err != nil {
-								log.Error().Err(err).Msg("GetProcessedOrders UpdateOrderStatus error")
-								continue
-							}
-						continue
-					}
-					
+					ar.poll(strg, order)
 				}
 			}
+			metrics.WorkerRan("accrual_reader")
 			time.Sleep(time.Second)
 		}
+		metrics.WorkerStopped("accrual_reader")
 		close(ar.finished)
 		log.Debug().Msg("AccrualReader finished")
 	}()
 }
 
+// poll asks the accrual system about the order within one span, so the request and the storage
+// update of an order show up in the same trace.
+func (ar *AccrualReader) poll(strg storage.Storager, order storage.ProcessedOrders) {
+	ctx, span := tracing.StartSpan(ar.ctx, "accrual.poll", attribute.String("order", order.Order), attribute.String("status", order.Status))
+	defer span.End()
+	strg = storage.WithContext(ctx, strg)
+	ar.beat()
+
+	log.Debug().Msgf("AccrualReader order: %s", order)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, ar.AccuralSystemAddress+"/api/orders/"+order.Order, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("NewRequest process run error")
+		return
+	}
+	result, err := ar.client.Do(request)
+	if err != nil {
+		metrics.AccrualPoll(0)
+		ar.circuit.failure(time.Now())
+		log.Error().Err(err).Msg("client.Do process run error")
+		return
+	}
+	metrics.AccrualPoll(result.StatusCode)
+	if result.StatusCode >= http.StatusInternalServerError {
+		ar.circuit.failure(time.Now())
+	} else {
+		ar.circuit.success()
+	}
+	defer result.Body.Close()
+	accuralResultBZ, err := io.ReadAll(result.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("Read result.Body process run error")
+		return
+	}
+
+	log.Debug().Msgf("AccrualReader received status: %d", result.StatusCode)
+	if result.StatusCode == 429 {
+		t, err := time.ParseDuration(result.Header.Get("Retry-After") + "s")
+		if err != nil {
+			log.Error().Err(err).Msg("ParseDuration process run error")
+			return
+		}
+		ar.wait(t)
+		return
+	}
+	var responce storage.AccuralResult
+	if err = json.Unmarshal(accuralResultBZ, &responce); err != nil {
+		log.Error().Err(err).Msg("Unmarshal process run error")
+		return
+	}
+	if result.StatusCode == 200 {
+		if responce.Status == order.Status {
+			return
+		}
+		responce.UserID = order.UserID
+		err := strg.UpdateOrderStatus(responce)
+		if err != nil {
+			log.Error().Err(err).Msg("GetProcessedOrders UpdateOrderStatus error")
+			return
+		}
+	}
+	if result.StatusCode == 204 {
+		err := strg.UpdateOrderStatus(responce)
+		if err != nil {
+			log.Error().Err(err).Msg("GetProcessedOrders UpdateOrderStatus error")
+			return
+		}
+	}
+}
+
+// beat records that the reader is alive.
+func (ar *AccrualReader) beat() {
+	ar.heartbeat.Store(time.Now().UnixNano())
+}
+
+// wait sleeps for d or until the reader is stopped, beating meanwhile so a long Retry-After
+// is not taken for a stuck reader.
+func (ar *AccrualReader) wait(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ar.ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case <-ticker.C:
+			ar.beat()
+		}
+	}
+}
+
+// Heartbeat returns when the reader last showed progress: a pass, a poll or a beat while waiting.
+func (ar *AccrualReader) Heartbeat() time.Time {
+	return time.Unix(0, ar.heartbeat.Load())
+}
+
+// CircuitState returns whether requests to the accrual system are let through.
+func (ar *AccrualReader) CircuitState() string {
+	return ar.circuit.current()
+}
+
 func (ar *AccrualReader) Stop() {
 	ar.cancel()
 	<-ar.finished
@@ -0,0 +1,57 @@
+package accrualreader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gophermart/internal/config"
+	"gophermart/internal/storage"
+)
+
+func newTestReader(t *testing.T, handler http.HandlerFunc, timeout time.Duration) *AccrualReader {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	ar := NewAccrualReader(&config.Config{AccuralSystemAddress: server.URL, AccrualTimeout: timeout, CircuitFailures: 1, CircuitCooldown: time.Minute})
+	t.Cleanup(ar.cancel)
+	return ar
+}
+
+func TestPollBeatsWhileWaiting(t *testing.T) {
+	ar := newTestReader(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}, time.Second)
+
+	start := time.Now()
+	go ar.poll(nil, storage.ProcessedOrders{Order: "12345678903"})
+	assert.Eventually(t, func() bool {
+		return ar.Heartbeat().After(start.Add(heartbeatInterval))
+	}, 2*time.Second+heartbeatInterval, 100*time.Millisecond, "the reader beats during the Retry-After wait")
+}
+
+func TestPollTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	ar := newTestReader(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}, 100*time.Millisecond)
+	defer close(release)
+
+	done := make(chan struct{})
+	go func() {
+		ar.poll(nil, storage.ProcessedOrders{Order: "12345678903"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("poll did not give up on a hanging accrual system")
+	}
+	assert.Equal(t, CircuitOpen, ar.CircuitState())
+}
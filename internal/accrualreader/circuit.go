@@ -0,0 +1,62 @@
+package accrualreader
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// circuit stops the polling after consecutive failures of the accrual system and lets a single
+// request through once the cooldown is over. A threshold of zero never opens it.
+type circuit struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     string
+	openedAt  time.Time
+}
+
+func newCircuit(threshold int, cooldown time.Duration) *circuit {
+	return &circuit{threshold: threshold, cooldown: cooldown, state: CircuitClosed}
+}
+
+func (c *circuit) allow(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == CircuitOpen {
+		if now.Sub(c.openedAt) < c.cooldown {
+			return false
+		}
+		c.state = CircuitHalfOpen
+	}
+	return true
+}
+
+func (c *circuit) success() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = 0
+	c.state = CircuitClosed
+}
+
+func (c *circuit) failure(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures++
+	if c.threshold > 0 && (c.state == CircuitHalfOpen || c.failures >= c.threshold) {
+		c.state = CircuitOpen
+		c.openedAt = now
+	}
+}
+
+func (c *circuit) current() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
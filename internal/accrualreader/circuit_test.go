@@ -0,0 +1,45 @@
+package accrualreader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuit(t *testing.T) {
+	now := time.Now()
+	c := newCircuit(3, time.Minute)
+
+	c.failure(now)
+	c.failure(now)
+	assert.Equal(t, CircuitClosed, c.current())
+	c.success()
+	c.failure(now)
+	c.failure(now)
+	assert.True(t, c.allow(now))
+	c.failure(now)
+	assert.Equal(t, CircuitOpen, c.current())
+	assert.False(t, c.allow(now.Add(30*time.Second)))
+
+	// After the cooldown one request goes through and a failure opens the circuit again at once.
+	assert.True(t, c.allow(now.Add(time.Minute)))
+	assert.Equal(t, CircuitHalfOpen, c.current())
+	c.failure(now.Add(time.Minute))
+	assert.Equal(t, CircuitOpen, c.current())
+	assert.False(t, c.allow(now.Add(90*time.Second)))
+
+	assert.True(t, c.allow(now.Add(2*time.Minute)))
+	c.success()
+	assert.Equal(t, CircuitClosed, c.current())
+}
+
+func TestCircuitDisabled(t *testing.T) {
+	now := time.Now()
+	c := newCircuit(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		c.failure(now)
+	}
+	assert.Equal(t, CircuitClosed, c.current())
+	assert.True(t, c.allow(now))
+}
@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Record is an audit entry as stored. Amounts are balances in hundredths of a point before and after
+// the event, nil when the event does not touch a balance.
+type Record struct {
+	ID        int64
+	CreatedAt time.Time
+	Actor     string
+	Action    string
+	Target    string
+	IP        string
+	RequestID string
+	Before    *int64
+	After     *int64
+	Details   string
+}
+
+// Hash chains the record to the hash of the previous one, so changing, removing or reordering
+// any record breaks every hash after it.
+func Hash(prev string, record Record) string {
+	h := sha256.New()
+	for _, field := range []string{
+		prev,
+		strconv.FormatInt(record.ID, 10),
+		record.CreatedAt.UTC().Format(time.RFC3339Nano),
+		record.Actor,
+		record.Action,
+		record.Target,
+		record.IP,
+		record.RequestID,
+		amount(record.Before),
+		amount(record.After),
+		record.Details,
+	} {
+		// Length prefixes keep "ab"+"c" and "a"+"bc" apart.
+		fmt.Fprintf(h, "%d:%s|", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func amount(value *int64) string {
+	if value == nil {
+		return "-"
+	}
+	return strconv.FormatInt(*value, 10)
+}
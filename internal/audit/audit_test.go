@@ -0,0 +1,40 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHash(t *testing.T) {
+	before, after := int64(100), int64(250)
+	record := Record{
+		ID:        7,
+		CreatedAt: time.Date(2024, 5, 1, 10, 0, 0, 123000, time.UTC),
+		Actor:     "ledger",
+		Action:    "balance.accrual",
+		Target:    "user1",
+		Before:    &before,
+		After:     &after,
+		Details:   "12345678903",
+	}
+	hash := Hash("", record)
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, Hash("", record))
+
+	// The same moment in another zone hashes the same.
+	moved := record
+	moved.CreatedAt = record.CreatedAt.In(time.FixedZone("UTC+3", 3*60*60))
+	assert.Equal(t, hash, Hash("", moved))
+
+	assert.NotEqual(t, hash, Hash("prev", record))
+
+	changed := record
+	changed.After = nil
+	assert.NotEqual(t, hash, Hash("", changed))
+
+	shifted := record
+	shifted.Actor, shifted.Action = "ledgerb", "alance.accrual"
+	assert.NotEqual(t, hash, Hash("", shifted))
+}
@@ -0,0 +1,48 @@
+package bodylimit
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+type Rule struct {
+	MaxBytes     int64
+	ContentTypes []string
+}
+
+func (rule Rule) allowed(contentType string) bool {
+	if len(rule.ContentTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range rule.ContentTypes {
+		if strings.EqualFold(mediaType, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Enforce rejects requests with a foreign Content-Type with 415 and bodies above MaxBytes with 400.
+func Enforce(rule Rule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !rule.allowed(r.Header.Get("Content-Type")) {
+				http.Error(w, "Content-Type must be "+strings.Join(rule.ContentTypes, " or "), http.StatusUnsupportedMediaType)
+				return
+			}
+			if rule.MaxBytes > 0 {
+				if r.ContentLength > rule.MaxBytes {
+					http.Error(w, "request body too large", http.StatusBadRequest)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, rule.MaxBytes)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
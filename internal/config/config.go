@@ -3,14 +3,76 @@ package config
 import (
 	"errors"
 	"flag"
+	"os"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 )
 
 type Config struct {
-	RunAddress  string `env:"RUN_ADDRESS"`
-	DatabaseURI string `env:"DATABASE_URI"`
-	AccuralSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	RunAddress           string        `env:"RUN_ADDRESS"`
+	DatabaseURI          string        `env:"DATABASE_URI"`
+	AccuralSystemAddress string        `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	IdempotencyTTL       time.Duration `env:"IDEMPOTENCY_TTL" envDefault:"24h"`
+	RateLimitStore       string        `env:"RATE_LIMIT_STORE" envDefault:"memory"`
+	RateLimitAuth        string        `env:"RATE_LIMIT_AUTH" envDefault:"30/1m"`
+	RateLimitOrders      string        `env:"RATE_LIMIT_ORDERS" envDefault:"120/1m"`
+	RateLimitAPI         string        `env:"RATE_LIMIT_API" envDefault:"1200/1m"`
+	TrustedProxies       []string      `env:"TRUSTED_PROXIES" envSeparator:","`
+	LoginMaxFailures     int           `env:"LOGIN_MAX_FAILURES" envDefault:"5"`
+	LoginMaxIPFailures   int           `env:"LOGIN_MAX_IP_FAILURES" envDefault:"50"`
+	LoginLockout         time.Duration `env:"LOGIN_LOCKOUT" envDefault:"15m"`
+	LoginFreeFailures    int           `env:"LOGIN_FREE_FAILURES" envDefault:"3"`
+	LoginDelayBase       time.Duration `env:"LOGIN_DELAY_BASE" envDefault:"1s"`
+	LoginDelayMax        time.Duration `env:"LOGIN_DELAY_MAX" envDefault:"30s"`
+	LoginMinLength       int           `env:"LOGIN_MIN_LENGTH" envDefault:"3"`
+	LoginMaxLength       int           `env:"LOGIN_MAX_LENGTH" envDefault:"64"`
+	LoginCharset         string        `env:"LOGIN_CHARSET" envDefault:"^[!-~]+$"`
+	PasswordMinLength    int           `env:"PASSWORD_MIN_LENGTH" envDefault:"6"`
+	PasswordMaxLength    int           `env:"PASSWORD_MAX_LENGTH" envDefault:"128"`
+	PasswordDenylistFile string        `env:"PASSWORD_DENYLIST_FILE"`
+	OrderMinLength       int           `env:"ORDER_MIN_LENGTH" envDefault:"2"`
+	OrderMaxLength       int           `env:"ORDER_MAX_LENGTH" envDefault:"32"`
+	MaxBodyCredentials   int64         `env:"MAX_BODY_CREDENTIALS" envDefault:"4096"`
+	MaxBodyOrder         int64         `env:"MAX_BODY_ORDER" envDefault:"128"`
+	MaxBodyWithdraw      int64         `env:"MAX_BODY_WITHDRAW" envDefault:"1024"`
+	MaxBodyOrdersBatch   int64         `env:"MAX_BODY_ORDERS_BATCH" envDefault:"16384"`
+	MaxOrdersBatch       int           `env:"MAX_ORDERS_BATCH" envDefault:"100"`
+	ReconcileInterval    time.Duration `env:"RECONCILE_INTERVAL" envDefault:"1h"`
+	ReconcileFix         bool          `env:"RECONCILE_FIX" envDefault:"false"`
+	PointsExpiryMonths   int           `env:"POINTS_EXPIRY_MONTHS" envDefault:"0"`
+	ExpiringSoonWindow   time.Duration `env:"EXPIRING_SOON_WINDOW" envDefault:"720h"`
+	ExpiryInterval       time.Duration `env:"EXPIRY_INTERVAL" envDefault:"1h"`
+	Tiers                string        `env:"TIERS"`
+	TierBasis            string        `env:"TIER_BASIS" envDefault:"accrued"`
+	TierInterval         time.Duration `env:"TIER_INTERVAL" envDefault:"1h"`
+	WithdrawCancelWindow time.Duration `env:"WITHDRAW_CANCEL_WINDOW" envDefault:"24h"`
+	AdminLogins          []string      `env:"ADMIN_LOGINS" envSeparator:","`
+	HoldTTL              time.Duration `env:"HOLD_TTL" envDefault:"30m"`
+	HoldMaxTTL           time.Duration `env:"HOLD_MAX_TTL" envDefault:"24h"`
+	TransferDailyLimit   float64       `env:"TRANSFER_DAILY_LIMIT" envDefault:"10000"`
+	TransferDailyCount   int           `env:"TRANSFER_DAILY_COUNT" envDefault:"10"`
+	ReferralBonus        float64       `env:"REFERRAL_BONUS" envDefault:"100"`
+	WebhookInterval      time.Duration `env:"WEBHOOK_INTERVAL" envDefault:"5s"`
+	WebhookTimeout       time.Duration `env:"WEBHOOK_TIMEOUT" envDefault:"10s"`
+	WebhookMaxAttempts   int           `env:"WEBHOOK_MAX_ATTEMPTS" envDefault:"8"`
+	WebhookBackoffBase   time.Duration `env:"WEBHOOK_BACKOFF_BASE" envDefault:"30s"`
+	WebhookBackoffMax    time.Duration `env:"WEBHOOK_BACKOFF_MAX" envDefault:"6h"`
+	OutboxRetention      time.Duration `env:"OUTBOX_RETENTION" envDefault:"720h"`
+	EventsHeartbeat      time.Duration `env:"EVENTS_HEARTBEAT" envDefault:"15s"`
+	AuditSealInterval    time.Duration `env:"AUDIT_SEAL_INTERVAL" envDefault:"10s"`
+	GRPCAddress          string        `env:"GRPC_ADDRESS"`
+	MetricsAddress       string        `env:"METRICS_ADDRESS" envDefault:":9090"`
+	TracingExporter      string        `env:"TRACING_EXPORTER" envDefault:"none"`
+	TracingEndpoint      string        `env:"TRACING_ENDPOINT" envDefault:"localhost:4317"`
+	TracingInsecure      bool          `env:"TRACING_INSECURE"`
+	TracingFile          string        `env:"TRACING_FILE" envDefault:"traces.json"`
+	TracingSampleRatio   float64       `env:"TRACING_SAMPLE_RATIO" envDefault:"1"`
+	AccrualTimeout       time.Duration `env:"ACCRUAL_TIMEOUT" envDefault:"10s"`
+	CircuitFailures      int           `env:"ACCRUAL_CIRCUIT_FAILURES" envDefault:"5"`
+	CircuitCooldown      time.Duration `env:"ACCRUAL_CIRCUIT_COOLDOWN" envDefault:"30s"`
+	ReadyHeartbeatAge    time.Duration `env:"READY_HEARTBEAT_MAX_AGE" envDefault:"30s"`
+	ShutdownDelay        time.Duration `env:"SHUTDOWN_DELAY" envDefault:"5s"`
 }
 
 func NewConfig() (*Config, error) {
@@ -30,6 +92,13 @@ func NewConfig() (*Config, error) {
 	if config.AccuralSystemAddress == "" {
 		flag.StringVar(&config.AccuralSystemAddress, "r", "", "Сервер расчета начислений")
 	}
+	if config.GRPCAddress == "" {
+		flag.StringVar(&config.GRPCAddress, "g", "", "Адрес gRPC сервера")
+	}
+	// The metrics address has a default, an empty METRICS_ADDRESS turns the metrics server off.
+	if _, ok := os.LookupEnv("METRICS_ADDRESS"); !ok {
+		flag.StringVar(&config.MetricsAddress, "m", config.MetricsAddress, "Адрес сервера метрик")
+	}
 
 	flag.Parse()
 
@@ -42,6 +111,17 @@ func NewConfig() (*Config, error) {
 	if config.AccuralSystemAddress == "" {
 		return nil, errors.New("accural address not provided")
 	}
+	if config.TierBasis != "accrued" && config.TierBasis != "withdrawn" {
+		return nil, errors.New("tier basis must be accrued or withdrawn")
+	}
+	if config.RateLimitStore != "memory" && config.RateLimitStore != "postgres" {
+		return nil, errors.New("rate limit store must be memory or postgres")
+	}
+	switch config.TracingExporter {
+	case "none", "otlp", "stdout", "file":
+	default:
+		return nil, errors.New("tracing exporter must be none, otlp, stdout or file")
+	}
 
 	return &config, nil
 }
@@ -0,0 +1,45 @@
+package events
+
+import "sync"
+
+// Bus wakes up the streams of a user when new events for the user are stored. It carries
+// no payload: subscribers read the events from storage, so a missed wake-up loses nothing.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[string]map[chan struct{}]struct{})}
+}
+
+// Subscribe returns a channel signalled on new events of the user and a function to unsubscribe.
+func (b *Bus) Subscribe(userID string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan struct{}]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers[userID], ch)
+		if len(b.subscribers[userID]) == 0 {
+			delete(b.subscribers, userID)
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *Bus) Publish(userID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[userID] {
+		// A pending signal already makes the subscriber read everything new.
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	first, unsubscribe := bus.Subscribe("user1")
+	second, _ := bus.Subscribe("user2")
+
+	bus.Publish("user1")
+	bus.Publish("user1")
+	assert.Len(t, first, 1)
+	assert.Len(t, second, 0)
+	<-first
+
+	unsubscribe()
+	bus.Publish("user1")
+	assert.Len(t, first, 0)
+	assert.Empty(t, bus.subscribers["user1"])
+}
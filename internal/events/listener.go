@@ -0,0 +1,65 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/metrics"
+	"gophermart/internal/storage"
+)
+
+const reconnectDelay = 5 * time.Second
+
+// Listener dispatches the events stored by any instance, as announced by PostgreSQL NOTIFY, and
+// publishes them to the bus.
+type Listener struct {
+	bus      *Bus
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+func NewListener(bus *Bus) *Listener {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Listener{
+		bus:      bus,
+		ctx:      ctx,
+		cancel:   cancel,
+		finished: make(chan struct{}),
+	}
+}
+
+func (l *Listener) Run(strg storage.Storager) {
+	go func() {
+		log.Debug().Msg("Listener started")
+		metrics.WorkerStarted("events_listener")
+	loop:
+		for {
+			err := strg.ListenEvents(l.ctx, func(userID string, _ int64) {
+				// Streams read dispatched events only, so dispatch before waking them up.
+				if _, err := strg.DispatchOutbox(); err != nil {
+					log.Error().Err(err).Msg("Listener DispatchOutbox error")
+				}
+				l.bus.Publish(userID)
+			})
+			if err != nil && l.ctx.Err() == nil {
+				log.Error().Err(err).Msg("Listener ListenEvents error")
+			}
+			select {
+			case <-l.ctx.Done():
+				break loop
+			case <-time.After(reconnectDelay):
+			}
+		}
+		metrics.WorkerStopped("events_listener")
+		close(l.finished)
+		log.Debug().Msg("Listener finished")
+	}()
+}
+
+func (l *Listener) Stop() {
+	l.cancel()
+	<-l.finished
+}
@@ -0,0 +1,70 @@
+package expirer
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/metrics"
+	"gophermart/internal/storage"
+)
+
+// Expirer periodically writes off accrual lots whose expiry date has passed and closes stale holds.
+type Expirer struct {
+	interval time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+func NewExpirer(interval time.Duration) *Expirer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Expirer{
+		interval: interval,
+		ctx:      ctx,
+		cancel:   cancel,
+		finished: make(chan struct{}),
+	}
+}
+
+func (e *Expirer) Run(strg storage.Storager) {
+	go func() {
+		log.Debug().Msg("Expirer started")
+		metrics.WorkerStarted("expirer")
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+	loop:
+		for {
+			now := time.Now()
+			expired, err := strg.ExpirePoints(now)
+			if err != nil {
+				log.Error().Err(err).Msg("Expirer ExpirePoints error")
+			}
+			if expired != 0 {
+				log.Info().Msgf("Expirer expired %d lots", expired)
+			}
+			expired, err = strg.ExpireHolds(now)
+			if err != nil {
+				log.Error().Err(err).Msg("Expirer ExpireHolds error")
+			}
+			if expired != 0 {
+				log.Info().Msgf("Expirer expired %d holds", expired)
+			}
+			metrics.WorkerRan("expirer")
+			select {
+			case <-e.ctx.Done():
+				break loop
+			case <-ticker.C:
+			}
+		}
+		metrics.WorkerStopped("expirer")
+		close(e.finished)
+		log.Debug().Msg("Expirer finished")
+	}()
+}
+
+func (e *Expirer) Stop() {
+	e.cancel()
+	<-e.finished
+}
@@ -0,0 +1,50 @@
+package expirer
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gophermart/internal/storage"
+)
+
+type fakeStorage struct {
+	storage.Storager
+	mu     sync.Mutex
+	points []time.Time
+	holds  []time.Time
+}
+
+func (s *fakeStorage) ExpirePoints(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.points = append(s.points, now)
+	return 0, errors.New("lots locked")
+}
+
+func (s *fakeStorage) ExpireHolds(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holds = append(s.holds, now)
+	return 2, nil
+}
+
+func TestExpirer(t *testing.T) {
+	strg := &fakeStorage{}
+	e := NewExpirer(10 * time.Millisecond)
+	e.Run(strg)
+	assert.Eventually(t, func() bool {
+		strg.mu.Lock()
+		defer strg.mu.Unlock()
+		return len(strg.holds) >= 2
+	}, time.Second, 5*time.Millisecond)
+	e.Stop()
+
+	strg.mu.Lock()
+	defer strg.mu.Unlock()
+	// A failing ExpirePoints does not keep holds from expiring, both run with the same clock.
+	assert.Equal(t, strg.points[:2], strg.holds[:2])
+}
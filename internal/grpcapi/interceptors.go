@@ -0,0 +1,167 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"gophermart/internal/grpcapi/pb"
+	"gophermart/internal/ratelimit"
+	"gophermart/internal/storage"
+)
+
+const (
+	idempotencyKey = "idempotency-key"
+	retryAfterKey  = "retry-after"
+	// grpcContentType marks idempotent responses stored for gRPC calls, Status holds the gRPC code.
+	grpcContentType = "application/grpc+proto"
+)
+
+// methodPolicy returns the policy of the matching REST route, applied on top of the API policy.
+func (s *Server) methodPolicy(method string) (ratelimit.Policy, bool) {
+	switch method {
+	case pb.Gophermart_Register_FullMethodName, pb.Gophermart_Login_FullMethodName:
+		return s.limiter.Policies.Auth, true
+	case pb.Gophermart_UploadOrder_FullMethodName:
+		return s.limiter.Policies.Orders, true
+	}
+	return ratelimit.Policy{}, false
+}
+
+// idempotentMethods lists the calls honouring the idempotency-key metadata with their response type.
+var idempotentMethods = map[string]func() proto.Message{
+	pb.Gophermart_Withdraw_FullMethodName: func() proto.Message { return &pb.WithdrawResponse{} },
+}
+
+// rateLimitInterceptor rejects calls above the API policy or the policy of the method with
+// ResourceExhausted and the retry-after header in seconds.
+func (s *Server) rateLimitInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if s.limiter == nil {
+		return handler(ctx, req)
+	}
+	policies := []ratelimit.Policy{s.limiter.Policies.API}
+	if policy, ok := s.methodPolicy(info.FullMethod); ok {
+		policies = append(policies, policy)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	userID := firstValue(md, authorizationKey)
+	ip := clientIP(ctx)
+	for _, policy := range policies {
+		if policy.Limit == 0 {
+			continue
+		}
+		key := s.limiter.Key(ctx, policy, userID, ip)
+		allowed, retryAfter, err := s.limiter.Allow(policy, key)
+		if err != nil {
+			log.Error().Err(err).Msg("grpc ratelimit Allow err")
+			continue
+		}
+		if !allowed {
+			log.Debug().Msgf("ratelimit %s exceeded by %s", policy.Name, key)
+			grpc.SetHeader(ctx, metadata.Pairs(retryAfterKey, strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))))
+			return nil, status.Errorf(codes.ResourceExhausted, "No more than %d requests per %s allowed", policy.Limit, policy.Window)
+		}
+	}
+	return handler(ctx, req)
+}
+
+// idempotencyInterceptor replays the stored result when an idempotent call is retried with the
+// same idempotency-key, like the Idempotency middleware of the REST API. It runs after
+// authInterceptor, so keys are scoped by a verified user.
+func (s *Server) idempotencyInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	newResponse, ok := idempotentMethods[info.FullMethod]
+	md, _ := metadata.FromIncomingContext(ctx)
+	key := firstValue(md, idempotencyKey)
+	userID := userFrom(ctx)
+	if !ok || key == "" || userID == "" {
+		return handler(ctx, req)
+	}
+
+	requestBZ, err := proto.MarshalOptions{Deterministic: true}.Marshal(req.(proto.Message))
+	if err != nil {
+		log.Error().Err(err).Msg("grpc idempotency Marshal err")
+		return nil, errInternal
+	}
+	hash := sha256.New()
+	hash.Write([]byte(info.FullMethod + "\n"))
+	hash.Write(requestBZ)
+	fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+	strg := storage.WithContext(ctx, s.strg)
+	stored, err := strg.ReserveIdempotencyKey(userID, key, fingerprint, time.Now().Add(-s.idempotencyTTL))
+	if errors.Is(err, storage.ErrIdempotencyMismatch) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, storage.ErrIdempotencyInFlight) {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("grpc ReserveIdempotencyKey err")
+		return nil, errInternal
+	}
+	if stored != nil {
+		log.Debug().Msgf("grpc idempotency replay key: %s", key)
+		return replay(*stored, newResponse())
+	}
+
+	// Unless the result is saved the key is released, also when the handler panics.
+	saved := false
+	defer func() {
+		if saved {
+			return
+		}
+		if err := strg.ReleaseIdempotencyKey(userID, key); err != nil {
+			log.Error().Err(err).Msg("grpc ReleaseIdempotencyKey err")
+		}
+		if p := recover(); p != nil {
+			panic(p)
+		}
+	}()
+
+	response, err := handler(ctx, req)
+	stored = &storage.IdempotentResponse{Status: int(status.Code(err)), ContentType: grpcContentType}
+	switch stored.Status {
+	case int(codes.Internal), int(codes.Unavailable), int(codes.Unknown), int(codes.DeadlineExceeded), int(codes.Canceled):
+		// Like 5xx answers of the REST API, failures that may pass on retry are not kept.
+		return response, err
+	case int(codes.OK):
+		if stored.Body, err = proto.Marshal(response.(proto.Message)); err != nil {
+			log.Error().Err(err).Msg("grpc idempotency marshal err")
+			return response, nil
+		}
+	default:
+		stored.Body = []byte(status.Convert(err).Message())
+	}
+	if saveErr := strg.SaveIdempotentResponse(userID, key, *stored); saveErr != nil {
+		log.Error().Err(saveErr).Msg("grpc SaveIdempotentResponse err")
+		return response, err
+	}
+	saved = true
+	return response, err
+}
+
+// replay returns the stored result of a call into response.
+func replay(stored storage.IdempotentResponse, response proto.Message) (interface{}, error) {
+	if stored.ContentType != grpcContentType {
+		return nil, status.Error(codes.InvalidArgument, storage.ErrIdempotencyMismatch.Error())
+	}
+	if code := codes.Code(stored.Status); code != codes.OK {
+		return nil, status.Error(code, string(stored.Body))
+	}
+	if err := proto.Unmarshal(stored.Body, response); err != nil {
+		log.Error().Err(err).Msg("grpc idempotency Unmarshal err")
+		return nil, errInternal
+	}
+	return response, nil
+}
@@ -0,0 +1,214 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"gophermart/internal/config"
+	"gophermart/internal/grpcapi/pb"
+	"gophermart/internal/ratelimit"
+	"gophermart/internal/service"
+	"gophermart/internal/storage"
+	"gophermart/internal/validation"
+)
+
+// fakeStorage implements the Storager calls the tests make, the embedded nil interface panics on any other call.
+type fakeStorage struct {
+	storage.Storager
+	mu          sync.Mutex
+	users       map[string]bool
+	orders      map[string]string
+	withdrawals []string
+	withdrawErr error
+	fingerprint map[string]string
+	idempotency map[string]*storage.IdempotentResponse
+}
+
+func newFakeStorage(users ...string) *fakeStorage {
+	strg := &fakeStorage{
+		users:       make(map[string]bool),
+		orders:      make(map[string]string),
+		fingerprint: make(map[string]string),
+		idempotency: make(map[string]*storage.IdempotentResponse),
+	}
+	for _, user := range users {
+		strg.users[user] = true
+	}
+	return strg
+}
+
+func (s *fakeStorage) CheckUser(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.users[userID] {
+		return storage.ErrAuthError
+	}
+	return nil
+}
+
+func (s *fakeStorage) AddNewOrder(userID, order string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orders[order] = userID
+	return nil
+}
+
+func (s *fakeStorage) UserWithdraw(userID, order string, sum float32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.withdrawals = append(s.withdrawals, order)
+	return s.withdrawErr
+}
+
+func (s *fakeStorage) AddAuditEntry(entry storage.AuditEntry) error {
+	return nil
+}
+
+func (s *fakeStorage) ReserveIdempotencyKey(userID, key, fingerprint string, expiredBefore time.Time) (*storage.IdempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scoped := userID + "/" + key
+	stored, ok := s.fingerprint[scoped]
+	switch {
+	case !ok:
+		s.fingerprint[scoped] = fingerprint
+		return nil, nil
+	case stored != fingerprint:
+		return nil, storage.ErrIdempotencyMismatch
+	case s.idempotency[scoped] == nil:
+		return nil, storage.ErrIdempotencyInFlight
+	}
+	return s.idempotency[scoped], nil
+}
+
+func (s *fakeStorage) SaveIdempotentResponse(userID, key string, response storage.IdempotentResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idempotency[userID+"/"+key] = &response
+	return nil
+}
+
+func (s *fakeStorage) ReleaseIdempotencyKey(userID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.fingerprint, userID+"/"+key)
+	delete(s.idempotency, userID+"/"+key)
+	return nil
+}
+
+func newTestClient(t *testing.T, strg storage.Storager) pb.GophermartClient {
+	cfg := &config.Config{
+		LoginMinLength:    3,
+		LoginMaxLength:    64,
+		LoginCharset:      "^[!-~]+$",
+		PasswordMinLength: 6,
+		PasswordMaxLength: 128,
+		OrderMinLength:    2,
+		OrderMaxLength:    32,
+		IdempotencyTTL:    time.Hour,
+		RateLimitAuth:     "2/1m",
+		RateLimitOrders:   "1/1m",
+	}
+	validator, err := validation.NewValidator(cfg)
+	require.NoError(t, err)
+	services := service.New(cfg, strg, validator)
+	limiter, err := ratelimit.NewLimiter(cfg, ratelimit.NewMemoryCounter(), services.Users)
+	require.NoError(t, err)
+
+	listener := bufconn.Listen(1 << 20)
+	server := NewServer(cfg, services, strg, limiter)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewGophermartClient(conn)
+}
+
+func withMetadata(pairs ...string) context.Context {
+	return metadata.NewOutgoingContext(context.Background(), metadata.Pairs(pairs...))
+}
+
+func TestRateLimitAuth(t *testing.T) {
+	client := newTestClient(t, newFakeStorage())
+
+	// Malformed credentials are rejected by the service, but still count against the limit.
+	for i := 0; i < 2; i++ {
+		_, err := client.Register(context.Background(), &pb.Credentials{Login: "a", Password: "b"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+	var header metadata.MD
+	_, err := client.Login(context.Background(), &pb.Credentials{Login: "a", Password: "b"}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Len(t, header.Get(retryAfterKey), 1)
+	assert.NotEqual(t, "0", header.Get(retryAfterKey)[0])
+}
+
+func TestRateLimitOrdersByUser(t *testing.T) {
+	strg := newFakeStorage("alice", "bob")
+	client := newTestClient(t, strg)
+
+	_, err := client.UploadOrder(withMetadata(authorizationKey, "alice"), &pb.UploadOrderRequest{Number: "12345678903"})
+	require.NoError(t, err)
+	_, err = client.UploadOrder(withMetadata(authorizationKey, "alice"), &pb.UploadOrderRequest{Number: "2377225624"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = client.UploadOrder(withMetadata(authorizationKey, "bob"), &pb.UploadOrderRequest{Number: "2377225624"})
+	require.NoError(t, err, "users have buckets of their own")
+	assert.Equal(t, map[string]string{"12345678903": "alice", "2377225624": "bob"}, strg.orders)
+}
+
+func TestWithdrawIdempotency(t *testing.T) {
+	strg := newFakeStorage("alice")
+	client := newTestClient(t, strg)
+	ctx := withMetadata(authorizationKey, "alice", idempotencyKey, "key-1")
+
+	_, err := client.Withdraw(ctx, &pb.WithdrawRequest{Order: "2377225624", Sum: 10})
+	require.NoError(t, err)
+	_, err = client.Withdraw(ctx, &pb.WithdrawRequest{Order: "2377225624", Sum: 10})
+	require.NoError(t, err)
+	assert.Len(t, strg.withdrawals, 1, "the retry is answered from the stored result")
+
+	_, err = client.Withdraw(ctx, &pb.WithdrawRequest{Order: "2377225624", Sum: 20})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "the key was used for another request")
+
+	// Expected failures are stored and replayed too.
+	strg.withdrawErr = storage.ErrNotEnouthBalance
+	ctx = withMetadata(authorizationKey, "alice", idempotencyKey, "key-2")
+	_, err = client.Withdraw(ctx, &pb.WithdrawRequest{Order: "12345678903", Sum: 10})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	strg.withdrawErr = nil
+	_, err = client.Withdraw(ctx, &pb.WithdrawRequest{Order: "12345678903", Sum: 10})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Len(t, strg.withdrawals, 2)
+
+	// Internal errors release the key, so the retry runs again.
+	strg.withdrawErr = errors.New("connection reset")
+	ctx = withMetadata(authorizationKey, "alice", idempotencyKey, "key-3")
+	_, err = client.Withdraw(ctx, &pb.WithdrawRequest{Order: "79927398713", Sum: 10})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	strg.withdrawErr = nil
+	_, err = client.Withdraw(ctx, &pb.WithdrawRequest{Order: "79927398713", Sum: 10})
+	require.NoError(t, err)
+	assert.Len(t, strg.withdrawals, 4)
+
+	// Without a key every call withdraws.
+	_, err = client.Withdraw(withMetadata(authorizationKey, "alice"), &pb.WithdrawRequest{Order: "79927398713", Sum: 10})
+	require.NoError(t, err)
+	assert.Len(t, strg.withdrawals, 5)
+}
@@ -0,0 +1,219 @@
+// Package grpcapi serves the gRPC API defined in api/gophermart.proto on top of the same services
+// as the REST handlers.
+package grpcapi
+
+//go:generate protoc --proto_path=../../api --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative gophermart.proto
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"gophermart/internal/config"
+	"gophermart/internal/grpcapi/pb"
+	"gophermart/internal/ratelimit"
+	"gophermart/internal/service"
+	"gophermart/internal/storage"
+	"gophermart/internal/tracing"
+	"gophermart/internal/validation"
+)
+
+const (
+	authorizationKey = "authorization"
+	requestIDKey     = "x-request-id"
+)
+
+// publicMethods are the calls allowed without the authorization metadata.
+var publicMethods = map[string]bool{
+	pb.Gophermart_Register_FullMethodName: true,
+	pb.Gophermart_Login_FullMethodName:    true,
+}
+
+type userCtxKey struct{}
+
+// errInternal is returned for unexpected failures, whose cause is only logged.
+var errInternal = status.Error(codes.Internal, "internal error")
+
+type Server struct {
+	pb.UnimplementedGophermartServer
+	services       *service.Services
+	strg           storage.Storager
+	limiter        *ratelimit.Limiter
+	idempotencyTTL time.Duration
+}
+
+// NewServer serves the gRPC API on top of services, which the REST API shares. Calls are rate
+// limited by limiter, a nil limiter disables the limits.
+func NewServer(cfg *config.Config, services *service.Services, strg storage.Storager, limiter *ratelimit.Limiter) *grpc.Server {
+	srv := &Server{services: services, strg: strg, limiter: limiter, idempotencyTTL: cfg.IdempotencyTTL}
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor, clientInterceptor,
+		srv.rateLimitInterceptor, srv.authInterceptor, srv.idempotencyInterceptor))
+	pb.RegisterGophermartServer(server, srv)
+	return server
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) != 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func clientIP(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+	}
+	return ""
+}
+
+// clientInterceptor passes the peer address and request ID to the services.
+func clientInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	client := service.Client{RequestID: firstValue(md, requestIDKey), IP: clientIP(ctx), ClaimedUserID: firstValue(md, authorizationKey)}
+	if client.RequestID == "" {
+		client.RequestID = service.NewID(16)
+	}
+	return handler(service.WithClient(ctx, client), req)
+}
+
+// authInterceptor accepts the user ID in the authorization metadata as the REST API does in the Authorization header.
+func (s *Server) authInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if publicMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	userID := firstValue(md, authorizationKey)
+	err := s.services.Users.Authenticate(ctx, userID)
+	if errors.Is(err, storage.ErrAuthError) {
+		return nil, status.Error(codes.Unauthenticated, "user unauthorized")
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("grpc Authenticate err")
+		return nil, errInternal
+	}
+	return handler(context.WithValue(ctx, userCtxKey{}, userID), req)
+}
+
+func userFrom(ctx context.Context) string {
+	userID, _ := ctx.Value(userCtxKey{}).(string)
+	return userID
+}
+
+// statusError maps service errors to gRPC codes the way the REST handlers map them to HTTP statuses.
+func statusError(method string, err error) error {
+	var throttled *service.ThrottledError
+	switch {
+	case errors.Is(err, validation.ErrMalformed), errors.Is(err, validation.ErrInvalid),
+		errors.Is(err, storage.ErrReferralCode), errors.Is(err, service.ErrInvalidSum):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, storage.ErrConflict), errors.Is(err, storage.ErrAnotherUserUploaded):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, storage.ErrAuthError):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, storage.ErrNotEnouthBalance):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.As(err, &throttled):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	log.Error().Err(err).Msgf("grpc %s err", method)
+	return errInternal
+}
+
+var unmarshalJSON = protojson.UnmarshalOptions{DiscardUnknown: true}
+
+func (s *Server) Register(ctx context.Context, req *pb.Credentials) (*pb.AuthResponse, error) {
+	userID, err := s.services.Users.Register(ctx, req.GetLogin(), req.GetPassword(), req.GetReferralCode())
+	if err != nil {
+		return nil, statusError("Register", err)
+	}
+	return &pb.AuthResponse{UserId: userID}, nil
+}
+
+func (s *Server) Login(ctx context.Context, req *pb.Credentials) (*pb.AuthResponse, error) {
+	userID, err := s.services.Users.LogIn(ctx, req.GetLogin(), req.GetPassword())
+	if err != nil {
+		return nil, statusError("Login", err)
+	}
+	return &pb.AuthResponse{UserId: userID}, nil
+}
+
+func (s *Server) UploadOrder(ctx context.Context, req *pb.UploadOrderRequest) (*pb.UploadOrderResponse, error) {
+	err := s.services.Orders.Upload(ctx, userFrom(ctx), req.GetNumber())
+	if errors.Is(err, storage.ErrUploaded) {
+		return &pb.UploadOrderResponse{AlreadyUploaded: true}, nil
+	}
+	if err != nil {
+		return nil, statusError("UploadOrder", err)
+	}
+	return &pb.UploadOrderResponse{}, nil
+}
+
+// The services return the REST JSON bodies, the messages mirror them field by field.
+
+func (s *Server) ListOrders(ctx context.Context, req *pb.ListOrdersRequest) (*pb.ListOrdersResponse, error) {
+	response := &pb.ListOrdersResponse{}
+	orders, err := s.services.Orders.Orders(ctx, userFrom(ctx))
+	if errors.Is(err, storage.ErrNoContent) {
+		return response, nil
+	}
+	if err != nil {
+		return nil, statusError("ListOrders", err)
+	}
+	if err = unmarshalJSON.Unmarshal(wrapList("orders", orders), response); err != nil {
+		return nil, statusError("ListOrders", err)
+	}
+	return response, nil
+}
+
+func (s *Server) GetBalance(ctx context.Context, req *pb.GetBalanceRequest) (*pb.Balance, error) {
+	balance, err := s.services.Balance.Balance(ctx, userFrom(ctx))
+	if err != nil {
+		return nil, statusError("GetBalance", err)
+	}
+	response := &pb.Balance{}
+	if err = unmarshalJSON.Unmarshal(balance, response); err != nil {
+		return nil, statusError("GetBalance", err)
+	}
+	return response, nil
+}
+
+func (s *Server) Withdraw(ctx context.Context, req *pb.WithdrawRequest) (*pb.WithdrawResponse, error) {
+	err := s.services.Balance.Withdraw(ctx, userFrom(ctx), req.GetOrder(), float32(req.GetSum()))
+	if err != nil {
+		return nil, statusError("Withdraw", err)
+	}
+	return &pb.WithdrawResponse{}, nil
+}
+
+func (s *Server) ListWithdrawals(ctx context.Context, req *pb.ListWithdrawalsRequest) (*pb.ListWithdrawalsResponse, error) {
+	response := &pb.ListWithdrawalsResponse{}
+	withdrawals, err := s.services.Balance.Withdrawals(ctx, userFrom(ctx))
+	if errors.Is(err, storage.ErrNoContent) {
+		return response, nil
+	}
+	if err != nil {
+		return nil, statusError("ListWithdrawals", err)
+	}
+	if err = unmarshalJSON.Unmarshal(wrapList("withdrawals", withdrawals), response); err != nil {
+		return nil, statusError("ListWithdrawals", err)
+	}
+	return response, nil
+}
+
+func wrapList(field string, list []byte) []byte {
+	wrapped := make([]byte, 0, len(list)+len(field)+5)
+	wrapped = append(wrapped, `{"`+field+`":`...)
+	wrapped = append(wrapped, list...)
+	return append(wrapped, '}')
+}
@@ -0,0 +1,51 @@
+package grpcapi
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"gophermart/internal/grpcapi/pb"
+	"gophermart/internal/service"
+	"gophermart/internal/storage"
+	"gophermart/internal/validation"
+)
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{name: "invalid order", err: fmt.Errorf("order: %w", validation.ErrInvalid), code: codes.InvalidArgument},
+		{name: "invalid sum", err: service.ErrInvalidSum, code: codes.InvalidArgument},
+		{name: "login taken", err: storage.ErrConflict, code: codes.AlreadyExists},
+		{name: "order of another user", err: storage.ErrAnotherUserUploaded, code: codes.AlreadyExists},
+		{name: "bad credentials", err: storage.ErrAuthError, code: codes.Unauthenticated},
+		{name: "not enough balance", err: storage.ErrNotEnouthBalance, code: codes.FailedPrecondition},
+		{name: "throttled", err: &service.ThrottledError{Wait: time.Second}, code: codes.ResourceExhausted},
+		{name: "other", err: fmt.Errorf("connection reset"), code: codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.code, status.Code(statusError("test", tt.err)))
+		})
+	}
+	assert.Equal(t, "internal error", status.Convert(statusError("test", fmt.Errorf("dial tcp 10.0.0.5:5432: connection reset"))).Message(),
+		"the cause of an internal error is not sent to the client")
+}
+
+func TestUnmarshalList(t *testing.T) {
+	orders := []byte(`[{"number":"12345678903","status":"PROCESSED","accrual":500,"uploaded_at":"2020-12-10T15:15:45+03:00","attempts":1}]`)
+	response := &pb.ListOrdersResponse{}
+	require.NoError(t, unmarshalJSON.Unmarshal(wrapList("orders", orders), response))
+	require.Len(t, response.Orders, 1)
+	assert.Equal(t, "12345678903", response.Orders[0].Number)
+	assert.Equal(t, "PROCESSED", response.Orders[0].Status)
+	assert.EqualValues(t, 500, response.Orders[0].Accrual)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/service"
+	"gophermart/internal/storage"
+)
+
+var adjustmentReasons = map[string]bool{
+	"goodwill":     true,
+	"compensation": true,
+	"correction":   true,
+	"fraud":        true,
+	"chargeback":   true,
+}
+
+type newAdjustment struct {
+	Login      string  `json:"login"`
+	Amount     float32 `json:"amount"`
+	ReasonCode string  `json:"reason_code"`
+	Reference  string  `json:"reference"`
+	Comment    string  `json:"comment,omitempty"`
+}
+
+type adjustmentCreated struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+func (h *Handler) AdminCreateAdjustment(w http.ResponseWriter, r *http.Request) {
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("AdminCreateAdjustment read body err")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var adjustment newAdjustment
+	if err = json.Unmarshal(bytes, &adjustment); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	amount := int(adjustment.Amount * 100)
+	switch {
+	case amount == 0:
+		err = errors.New("amount must not be zero")
+	case !adjustmentReasons[adjustment.ReasonCode]:
+		err = errors.New("unknown reason code")
+	case strings.TrimSpace(adjustment.Reference) == "":
+		err = errors.New("reference is required")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.store(r).UserIDByLogin(adjustment.Login)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("AdminCreateAdjustment UserIDByLogin err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	created := adjustmentCreated{ID: service.NewID(16), Status: storage.AdjustmentPending}
+	err = h.store(r).CreateAdjustment(storage.Adjustment{
+		ID:         created.ID,
+		UserID:     userID,
+		Amount:     amount,
+		ReasonCode: adjustment.ReasonCode,
+		Reference:  strings.TrimSpace(adjustment.Reference),
+		Comment:    adjustment.Comment,
+		CreatedBy:  adminActor(r),
+	})
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("AdminCreateAdjustment err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	createdBZ, err := json.Marshal(created)
+	if err != nil {
+		log.Error().Err(err).Msg("AdminCreateAdjustment json.Marshal err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(createdBZ)
+}
+
+func (h *Handler) AdminAdjustments(w http.ResponseWriter, r *http.Request) {
+	status := strings.ToUpper(r.URL.Query().Get("status"))
+	adjustments, err := h.store(r).Adjustments(status)
+	if err != nil {
+		log.Error().Err(err).Msg("AdminAdjustments err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(adjustments)
+}
+
+func (h *Handler) decideAdjustment(w http.ResponseWriter, r *http.Request, decide func(id, approver string) (storage.Adjustment, error)) {
+	_, err := decide(chi.URLParam(r, "adjustment"), adminActor(r))
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrConflict) {
+		http.Error(w, "adjustment is already decided", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, storage.ErrSameApprover) {
+		http.Error(w, "adjustment must be decided by an admin other than its requester or recipient", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, storage.ErrNotEnouthBalance) {
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("decideAdjustment err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(nil)
+}
+
+func (h *Handler) AdminApproveAdjustment(w http.ResponseWriter, r *http.Request) {
+	h.decideAdjustment(w, r, h.store(r).ApproveAdjustment)
+}
+
+func (h *Handler) AdminRejectAdjustment(w http.ResponseWriter, r *http.Request) {
+	h.decideAdjustment(w, r, h.store(r).RejectAdjustment)
+}
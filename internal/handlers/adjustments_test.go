@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/rbac"
+	"gophermart/internal/storage"
+)
+
+func adjustmentsStorage() *fakeStorage {
+	strg := newFakeStorage("admin", "support", "alice")
+	strg.roles["admin"] = rbac.RoleAdmin
+	strg.roles["support"] = rbac.RoleSupport
+	strg.logins["alice"] = "alice-id"
+	return strg
+}
+
+func TestAdminCreateAdjustment(t *testing.T) {
+	strg := adjustmentsStorage()
+	h := newTestHandler(t, strg)
+	create := h.RequirePermission(rbac.AdjustmentsCreate)(http.HandlerFunc(h.AdminCreateAdjustment))
+
+	for body, want := range map[string]int{
+		`{"login":"alice","amount":5,"reason_code":"goodwill","reference":" ticket-1 "}`: http.StatusAccepted,
+		`{"login":"alice","amount":0,"reason_code":"goodwill","reference":"ticket-1"}`:   http.StatusBadRequest,
+		`{"login":"alice","amount":5,"reason_code":"gift","reference":"ticket-1"}`:       http.StatusBadRequest,
+		`{"login":"alice","amount":5,"reason_code":"goodwill","reference":" "}`:          http.StatusBadRequest,
+		`{"login":"bob","amount":5,"reason_code":"goodwill","reference":"ticket-1"}`:     http.StatusNotFound,
+		`{"login":`: http.StatusBadRequest,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/adjustments", strings.NewReader(body))
+		r.Header.Set("Authorization", "support")
+		w := httptest.NewRecorder()
+		create.ServeHTTP(w, r)
+		assert.Equal(t, want, w.Code, body)
+	}
+	require.Len(t, strg.adjustments, 1)
+	adjustment := strg.adjustments[0]
+	assert.Len(t, adjustment.ID, 16)
+	assert.Equal(t, "alice-id", adjustment.UserID)
+	assert.Equal(t, 500, adjustment.Amount)
+	assert.Equal(t, "ticket-1", adjustment.Reference)
+	assert.Equal(t, "admin:support", adjustment.CreatedBy)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/admin/adjustments", strings.NewReader(`{}`))
+	r.Header.Set("Authorization", "alice")
+	w := httptest.NewRecorder()
+	create.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAdminAdjustmentsReadPermission(t *testing.T) {
+	strg := adjustmentsStorage()
+	h := newTestHandler(t, strg)
+	list := h.RequirePermission(rbac.AdjustmentsRead)(http.HandlerFunc(h.AdminAdjustments))
+
+	for user, want := range map[string]int{"support": http.StatusOK, "admin": http.StatusOK, "alice": http.StatusForbidden, "": http.StatusUnauthorized} {
+		r := httptest.NewRequest(http.MethodGet, "/api/admin/adjustments?status=pending", nil)
+		r.Header.Set("Authorization", user)
+		w := httptest.NewRecorder()
+		list.ServeHTTP(w, r)
+		assert.Equal(t, want, w.Code, user)
+		if want == http.StatusOK {
+			assert.JSONEq(t, `{"status":"PENDING"}`, w.Body.String())
+		}
+	}
+}
+
+func TestAdminApproveAdjustment(t *testing.T) {
+	strg := adjustmentsStorage()
+	h := newTestHandler(t, strg)
+	approve := h.RequirePermission(rbac.AdjustmentsApprove)(http.HandlerFunc(h.AdminApproveAdjustment))
+	request := func(user string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/adjustments/adj-1/approve", nil)
+		r.Header.Set("Authorization", user)
+		routeCtx := chi.NewRouteContext()
+		routeCtx.URLParams.Add("adjustment", "adj-1")
+		return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, routeCtx))
+	}
+
+	w := httptest.NewRecorder()
+	approve.ServeHTTP(w, request("admin"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, strg.decisions, 1)
+	assert.Equal(t, decision{id: "adj-1", approver: "admin:admin"}, strg.decisions[0])
+
+	w = httptest.NewRecorder()
+	approve.ServeHTTP(w, request("support"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Len(t, strg.decisions, 1, "support may not approve")
+
+	for err, want := range map[error]int{
+		storage.ErrNotFound:         http.StatusNotFound,
+		storage.ErrConflict:         http.StatusConflict,
+		storage.ErrSameApprover:     http.StatusForbidden,
+		storage.ErrNotEnouthBalance: http.StatusPaymentRequired,
+	} {
+		strg.decisionErr = err
+		w = httptest.NewRecorder()
+		approve.ServeHTTP(w, request("admin"))
+		assert.Equal(t, want, w.Code, err.Error())
+	}
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/rbac"
+	"gophermart/internal/storage"
+)
+
+type adminCtxKey struct{}
+
+var orderStatuses = map[string]bool{"NEW": true, "PROCESSING": true, "INVALID": true, "PROCESSED": true}
+
+type userRole struct {
+	Role string `json:"role"`
+}
+
+type orderStatus struct {
+	Status  string  `json:"status"`
+	Accrual float32 `json:"accrual"`
+}
+
+// RequirePermission lets through users whose role grants the permission and writes every
+// admin request with its response status to the audit log.
+func (h *Handler) RequirePermission(permission rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := r.Header.Get("Authorization")
+			if userID == "" {
+				http.Error(w, "user unauthorized", http.StatusUnauthorized)
+				return
+			}
+			role, err := h.store(r).UserRole(userID)
+			if errors.Is(err, storage.ErrAuthError) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				log.Error().Err(err).Msg("RequirePermission UserRole err")
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !rbac.Allowed(role, permission) {
+				http.Error(w, "admin access required", http.StatusForbidden)
+				return
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), adminCtxKey{}, userID))
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			h.audit(r, storage.AuditEntry{
+				Actor:   adminActor(r),
+				Action:  "admin." + string(permission),
+				Target:  r.Method + " " + r.URL.Path,
+				Details: fmt.Sprintf("status=%d", ww.Status()),
+			})
+		})
+	}
+}
+
+func adminActor(r *http.Request) string {
+	userID, _ := r.Context().Value(adminCtxKey{}).(string)
+	return "admin:" + userID
+}
+
+func (h *Handler) AdminUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.store(r).AdminUser(chi.URLParam(r, "login"))
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("AdminUser err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(user)
+}
+
+func (h *Handler) AdminUserBalance(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.store(r).UserIDByLogin(chi.URLParam(r, "login"))
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("AdminUserBalance UserIDByLogin err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	balance, err := h.store(r).UserBalance(userID)
+	if err != nil {
+		log.Error().Err(err).Msg("AdminUserBalance UserBalance err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(balance)
+}
+
+func (h *Handler) AdminSetRole(w http.ResponseWriter, r *http.Request) {
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("AdminSetRole read body err")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var role userRole
+	if err = json.Unmarshal(bytes, &role); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !rbac.Valid(role.Role) {
+		http.Error(w, "unknown role", http.StatusBadRequest)
+		return
+	}
+	err = h.store(r).SetUserRole(chi.URLParam(r, "login"), role.Role)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("AdminSetRole err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(nil)
+}
+
+func (h *Handler) AdminOrder(w http.ResponseWriter, r *http.Request) {
+	order, err := h.store(r).AdminOrder(chi.URLParam(r, "order"))
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("AdminOrder err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(order)
+}
+
+func (h *Handler) AdminOrderStatus(w http.ResponseWriter, r *http.Request) {
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("AdminOrderStatus read body err")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var status orderStatus
+	if err = json.Unmarshal(bytes, &status); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !orderStatuses[status.Status] {
+		http.Error(w, "unknown order status", http.StatusBadRequest)
+		return
+	}
+	if status.Accrual < 0 || (status.Accrual != 0 && status.Status != "PROCESSED") {
+		http.Error(w, "accrual is only allowed for processed orders", http.StatusBadRequest)
+		return
+	}
+	err = h.store(r).OverrideOrderStatus(chi.URLParam(r, "order"), status.Status, status.Accrual)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrProcessed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("AdminOrderStatus err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(nil)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/realip"
+	"gophermart/internal/storage"
+)
+
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+// audit records the entry with the client IP and request ID of r; failures are only logged
+// since the action itself has already happened.
+func (h *Handler) audit(r *http.Request, entry storage.AuditEntry) {
+	entry.IP = realip.FromRequest(r)
+	entry.RequestID = middleware.GetReqID(r.Context())
+	if err := h.store(r).AddAuditEntry(entry); err != nil {
+		log.Error().Err(err).Msgf("AddAuditEntry %s err", entry.Action)
+	}
+}
+
+func (h *Handler) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := storage.AuditFilter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+		From:   time.Unix(0, 0),
+		To:     time.Now().Add(time.Minute),
+		Limit:  auditDefaultLimit,
+	}
+	var err error
+	if value := query.Get("from"); value != "" {
+		if filter.From, err = parseStatementDate(value, false); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if filter.To, err = parseStatementDate(value, true); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit <= 0 || filter.Limit > auditMaxLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(auditMaxLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := h.store(r).AuditEntries(filter)
+	if err != nil {
+		log.Error().Err(err).Msg("AdminAudit AuditEntries err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(entries)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gophermart/internal/rbac"
+)
+
+func TestAdminAudit(t *testing.T) {
+	strg := adjustmentsStorage()
+	h := newTestHandler(t, strg)
+	list := h.RequirePermission(rbac.AuditRead)(http.HandlerFunc(h.AdminAudit))
+	get := func(user, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/admin/audit?"+query, nil)
+		r.Header.Set("Authorization", user)
+		w := httptest.NewRecorder()
+		list.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("admin", "actor=user:alice&action=user.&target=alice&from=2024-01-01&to=2024-01-31&limit=10")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, "[]", w.Body.String())
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	assert.Equal(t, "user:alice", strg.auditFilter.Actor)
+	assert.Equal(t, "user.", strg.auditFilter.Action)
+	assert.Equal(t, "alice", strg.auditFilter.Target)
+	assert.True(t, strg.auditFilter.From.Equal(from), strg.auditFilter.From)
+	assert.True(t, strg.auditFilter.To.After(from.AddDate(0, 0, 30)), strg.auditFilter.To)
+	assert.Equal(t, 10, strg.auditFilter.Limit)
+
+	w = get("admin", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, auditDefaultLimit, strg.auditFilter.Limit)
+	assert.Empty(t, strg.auditFilter.Action)
+
+	for _, query := range []string{"limit=0", "limit=1001", "limit=ten", "from=yesterday", "to=2024-13-01"} {
+		assert.Equal(t, http.StatusBadRequest, get("admin", query).Code, query)
+	}
+	assert.Equal(t, http.StatusForbidden, get("support", "").Code)
+
+	// Reading the audit log is audited itself.
+	last := strg.audit[len(strg.audit)-1]
+	assert.Equal(t, "admin."+string(rbac.AuditRead), last.Action)
+	assert.Equal(t, "admin:admin", last.Actor)
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const eventsBatch = 100
+
+// Events streams the user's order and balance events as Server-Sent Events. A client reconnecting
+// with Last-Event-ID gets the events it missed first.
+func (h *Handler) Events(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before reading the last event so nothing stored in between is missed.
+	wakeup, unsubscribe := h.bus.Subscribe(userID)
+	defer unsubscribe()
+
+	var lastID int64
+	var err error
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		if lastID, err = strconv.ParseInt(value, 10, 64); err != nil || lastID < 0 {
+			http.Error(w, "wrong Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	} else if lastID, err = h.store(r).LastEventID(userID); err != nil {
+		log.Error().Err(err).Msg("Events LastEventID err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", h.cfg.EventsHeartbeat.Milliseconds())
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(h.cfg.EventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		// Read in batches until caught up, a wake-up during the reads is kept in the channel.
+		for {
+			events, err := h.store(r).UserEvents(userID, lastID, eventsBatch)
+			if err != nil {
+				log.Error().Err(err).Msg("Events UserEvents err")
+				return
+			}
+			for _, event := range events {
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Payload)
+				lastID = event.ID
+			}
+			if len(events) != 0 {
+				flusher.Flush()
+			}
+			if len(events) < eventsBatch {
+				break
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-wakeup:
+		case <-heartbeat.C:
+			// The comment keeps proxies from closing an idle stream; the next pass also
+			// picks up events whose notification was lost.
+			if _, err = fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/storage"
+)
+
+func (s *fakeStorage) dispatch(events ...storage.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+}
+
+// openEvents connects to the stream and returns a function reading the next event's "id: " line.
+func openEvents(t *testing.T, url, lastEventID string) (*http.Response, func() string) {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	request.Header.Set("Authorization", "user")
+	if lastEventID != "" {
+		request.Header.Set("Last-Event-ID", lastEventID)
+	}
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	t.Cleanup(func() { response.Body.Close() })
+	scanner := bufio.NewScanner(response.Body)
+	return response, func() string {
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "id: ") {
+				return strings.TrimPrefix(line, "id: ")
+			}
+		}
+		return ""
+	}
+}
+
+func TestEventsResume(t *testing.T) {
+	strg := newFakeStorage("user")
+	h := newTestHandler(t, strg)
+	server := httptest.NewServer(http.HandlerFunc(h.Events))
+	// Runs after the streams are closed by their own cleanups.
+	t.Cleanup(server.Close)
+
+	strg.dispatch(storage.Event{ID: 1, Type: "order.updated", Payload: []byte(`{}`)},
+		storage.Event{ID: 2, Type: "order.processed", Payload: []byte(`{}`)},
+		storage.Event{ID: 3, Type: "balance.changed", Payload: []byte(`{}`)})
+
+	response, next := openEvents(t, server.URL, "1")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+	assert.Equal(t, "2", next())
+	assert.Equal(t, "3", next())
+
+	// An event whose transaction committed late is numbered at dispatch, after the cursor.
+	strg.dispatch(storage.Event{ID: 4, Type: "order.updated", Payload: []byte(`{}`)})
+	h.bus.Publish("user")
+	assert.Equal(t, "4", next())
+}
+
+func TestEventsStartAtLastEvent(t *testing.T) {
+	strg := newFakeStorage("user")
+	h := newTestHandler(t, strg)
+	server := httptest.NewServer(http.HandlerFunc(h.Events))
+	// Runs after the streams are closed by their own cleanups.
+	t.Cleanup(server.Close)
+	strg.dispatch(storage.Event{ID: 7, Type: "order.updated", Payload: []byte(`{}`)})
+
+	_, next := openEvents(t, server.URL, "")
+	strg.dispatch(storage.Event{ID: 8, Type: "order.processed", Payload: []byte(`{}`)})
+	h.bus.Publish("user")
+	assert.Equal(t, "8", next(), "a new stream skips the events stored before it")
+
+	response, _ := openEvents(t, server.URL, "-1")
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	response, _ = openEvents(t, server.URL, "abc")
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
@@ -10,22 +10,11 @@ import (
 )
 
 func (h *Handler) Balance(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("Authorization")
-	if userID == "" {
-		http.Error(w, "user unauthorized", http.StatusUnauthorized)
-		return
-	}
-	err := h.strg.CheckUser(userID)
-	if errors.Is(err, storage.ErrAuthError) {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+	userID, ok := h.authenticate(w, r)
+	if !ok {
 		return
 	}
-	if err != nil {
-		log.Error().Err(err).Msg("OrdersHistory CheckUser err")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	balance, err := h.strg.UserBalance(userID)
+	balance, err := h.services.Balance.Balance(h.client(r), userID)
 	if err != nil {
 		log.Error().Err(err).Msg("Balance UserBalance err")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -38,23 +27,12 @@ func (h *Handler) Balance(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) OrdersHistory(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("Authorization")
-	if userID == "" {
-		http.Error(w, "user unauthorized", http.StatusUnauthorized)
-		return
-	}
-	err := h.strg.CheckUser(userID)
-	if errors.Is(err, storage.ErrAuthError) {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
-	}
-	if err != nil {
-		log.Error().Err(err).Msg("OrdersHistory CheckUser err")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	userID, ok := h.authenticate(w, r)
+	if !ok {
 		return
 	}
 
-	orders, err := h.strg.UserOrders(userID)
+	orders, err := h.services.Orders.Orders(h.client(r), userID)
 	if errors.Is(err, storage.ErrNoContent) {
 		http.Error(w, err.Error(), http.StatusNoContent)
 		return
@@ -71,23 +49,12 @@ func (h *Handler) OrdersHistory(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) WithdrawHistory(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("Authorization")
-	if userID == "" {
-		http.Error(w, "user unauthorized", http.StatusUnauthorized)
-		return
-	}
-	err := h.strg.CheckUser(userID)
-	if errors.Is(err, storage.ErrAuthError) {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
-	}
-	if err != nil {
-		log.Error().Err(err).Msg("WithdrawHistory CheckUser err")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	userID, ok := h.authenticate(w, r)
+	if !ok {
 		return
 	}
 
-	withdraws, err := h.strg.UserWithdrawals(userID)
+	withdraws, err := h.services.Balance.Withdrawals(h.client(r), userID)
 	if errors.Is(err, storage.ErrNoContent) {
 		http.Error(w, err.Error(), http.StatusNoContent)
 		return
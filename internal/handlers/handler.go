@@ -1,25 +1,41 @@
 package handlers
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"gophermart/internal/config"
-	"gophermart/internal/storage"
-	"math/rand"
+	"context"
+	"errors"
+	"math"
+	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/config"
+	"gophermart/internal/events"
+	"gophermart/internal/realip"
+	"gophermart/internal/service"
+	"gophermart/internal/storage"
+	"gophermart/internal/tier"
+	"gophermart/internal/validation"
 )
 
 type Handler struct {
-	cfg  *config.Config
-	strg storage.Storager
+	cfg      *config.Config
+	strg     storage.Storager
+	tiers    tier.Tiers
+	bus      *events.Bus
+	services *service.Services
+	// lastPrune is when expired idempotency keys were last deleted.
+	pruneMu   sync.Mutex
+	lastPrune time.Time
 }
 
 type username struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	Login        string `json:"login"`
+	Password     string `json:"password"`
+	ReferralCode string `json:"referral_code,omitempty"`
 }
 
 type userWithdraw struct {
@@ -27,50 +43,50 @@ type userWithdraw struct {
 	Sum   float32 `json:"sum"`
 }
 
-func NewHandler(cfg *config.Config, strg storage.Storager) *Handler {
+// NewHandler serves the REST API on top of services, which the gRPC API shares.
+func NewHandler(cfg *config.Config, strg storage.Storager, services *service.Services, tiers tier.Tiers, bus *events.Bus) *Handler {
 	return &Handler{
-		cfg:  cfg,
-		strg: strg,
+		cfg:      cfg,
+		strg:     strg,
+		tiers:    tiers,
+		bus:      bus,
+		services: services,
 	}
 }
 
-func (h *Handler) hashPasswd(password string) string {
-	hash := sha256.New()
-	hash.Write([]byte(password))
-	dst := hash.Sum(nil)
-	return hex.EncodeToString(dst)
+// store returns the storage bound to the request context.
+func (h *Handler) store(r *http.Request) storage.Storager {
+	return storage.WithContext(r.Context(), h.strg)
 }
 
-func (h *Handler) randomID(n int) string {
-	const letterBytes = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	bts := make([]byte, n)
-	rand.Seed(time.Now().UnixNano())
-	for i := 0; i < n; i++ {
-		bts[i] = letterBytes[rand.Intn(len(letterBytes))]
+// authenticate returns the user of the Authorization header, otherwise it writes the error response.
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := r.Header.Get("Authorization")
+	if verified, ok := r.Context().Value(verifiedUserKey{}).(string); ok && verified == userID {
+		return userID, true
+	}
+	err := h.services.Users.Authenticate(h.client(r), userID)
+	if errors.Is(err, storage.ErrAuthError) {
+		http.Error(w, "user unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("CheckUser err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
 	}
-	return string(bts)
+	return userID, true
+}
+
+// client passes the client address and request ID of r to the services.
+func (h *Handler) client(r *http.Request) context.Context {
+	return service.WithClient(r.Context(), service.Client{IP: realip.FromRequest(r), RequestID: middleware.GetReqID(r.Context()), ClaimedUserID: r.Header.Get("Authorization")})
+}
+
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
 }
 
 func (h *Handler) LynnCheckOrder(lynn []byte) bool {
-	lynnArr := make([]int, len(lynn))
-	for i, d := range lynn {
-		j, err := strconv.Atoi(string(d))
-		if err != nil {
-			log.Error().Err(err).Msg("LynnCheckOrder strconv err")
-			return false
-		}
-		lynnArr[i] = j
-	}
-	for i := len(lynnArr) - 2; i >= 0; i -= 2 {
-		n := lynnArr[i] * 2
-		if n >= 10 {
-			n -= 9
-		}
-		lynnArr[i] = n
-	}
-	sum := 0
-	for i := 0; i < len(lynnArr); i++ {
-		sum += lynnArr[i]
-	}
-	return sum%10 == 0
+	return validation.Luhn(string(lynn))
 }
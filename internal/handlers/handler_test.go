@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/config"
+	"gophermart/internal/events"
+	"gophermart/internal/rbac"
+	"gophermart/internal/service"
+	"gophermart/internal/storage"
+	"gophermart/internal/validation"
+)
+
+// fakeStorage implements the Storager calls the handler tests make, the embedded nil interface
+// panics on any other call.
+type fakeStorage struct {
+	storage.Storager
+	mu    sync.Mutex
+	users map[string]bool
+	// checks counts CheckUser calls and prunes PruneIdempotencyKeys calls.
+	checks      int
+	prunes      int
+	idempotency map[string]*storage.IdempotentResponse
+	fingerprint map[string]string
+	orders      map[string]string
+	// statement is streamed by UserStatement, which fails with statementErr instead of sending the last entry.
+	statement    []storage.StatementEntry
+	statementErr error
+	reversals    []reversal
+	reversalErr  error
+	audit        []storage.AuditEntry
+	// roles and logins back RequirePermission and UserIDByLogin, users without a role are plain users.
+	roles       map[string]string
+	logins      map[string]string
+	adjustments []storage.Adjustment
+	decisions   []decision
+	decisionErr error
+	auditFilter storage.AuditFilter
+	webhooks    []storage.Webhook
+	// events are the user's dispatched events in dispatch order.
+	events []storage.Event
+}
+
+type decision struct {
+	id       string
+	approver string
+}
+
+type reversal struct {
+	userID string
+	order  string
+	window time.Duration
+	actor  string
+}
+
+func newFakeStorage(users ...string) *fakeStorage {
+	strg := &fakeStorage{
+		users:       make(map[string]bool),
+		idempotency: make(map[string]*storage.IdempotentResponse),
+		fingerprint: make(map[string]string),
+		orders:      make(map[string]string),
+		roles:       make(map[string]string),
+		logins:      make(map[string]string),
+	}
+	for _, user := range users {
+		strg.users[user] = true
+	}
+	return strg
+}
+
+func newTestHandler(t *testing.T, strg storage.Storager) *Handler {
+	cnfg := &config.Config{
+		LoginMinLength:       3,
+		LoginMaxLength:       64,
+		LoginCharset:         "^[!-~]+$",
+		PasswordMinLength:    6,
+		PasswordMaxLength:    128,
+		OrderMinLength:       2,
+		OrderMaxLength:       32,
+		MaxOrdersBatch:       3,
+		WithdrawCancelWindow: time.Hour,
+		IdempotencyTTL:       time.Hour,
+		EventsHeartbeat:      time.Hour,
+	}
+	validator, err := validation.NewValidator(cnfg)
+	require.NoError(t, err)
+	return NewHandler(cnfg, strg, service.New(cnfg, strg, validator), nil, events.NewBus())
+}
+
+func (s *fakeStorage) CheckUser(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks++
+	if !s.users[userID] {
+		return storage.ErrAuthError
+	}
+	return nil
+}
+
+func (s *fakeStorage) ReserveIdempotencyKey(userID, key, fingerprint string, expiredBefore time.Time) (*storage.IdempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scoped := userID + "/" + key
+	stored, ok := s.fingerprint[scoped]
+	switch {
+	case !ok:
+		s.fingerprint[scoped] = fingerprint
+		return nil, nil
+	case stored != fingerprint:
+		return nil, storage.ErrIdempotencyMismatch
+	case s.idempotency[scoped] == nil:
+		return nil, storage.ErrIdempotencyInFlight
+	}
+	return s.idempotency[scoped], nil
+}
+
+func (s *fakeStorage) PruneIdempotencyKeys(expiredBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prunes++
+	return nil
+}
+
+func (s *fakeStorage) SaveIdempotentResponse(userID, key string, response storage.IdempotentResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idempotency[userID+"/"+key] = &response
+	return nil
+}
+
+func (s *fakeStorage) ReleaseIdempotencyKey(userID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.fingerprint, userID+"/"+key)
+	delete(s.idempotency, userID+"/"+key)
+	return nil
+}
+
+func (s *fakeStorage) AddNewOrders(userID string, orders []string) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := make([]error, len(orders))
+	for i, order := range orders {
+		owner, ok := s.orders[order]
+		switch {
+		case ok && owner == userID:
+			results[i] = storage.ErrUploaded
+		case ok:
+			results[i] = storage.ErrAnotherUserUploaded
+		default:
+			s.orders[order] = userID
+		}
+	}
+	return results, nil
+}
+
+func (s *fakeStorage) UserStatement(userID string, from, to time.Time, fn func(storage.StatementEntry) error) error {
+	balance := 0
+	for i, entry := range s.statement {
+		if s.statementErr != nil && i == len(s.statement)-1 {
+			return s.statementErr
+		}
+		balance += entry.Amount
+		entry.Balance = balance
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorage) ReverseWithdrawal(userID, order string, window time.Duration, actor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reversals = append(s.reversals, reversal{userID: userID, order: order, window: window, actor: actor})
+	return s.reversalErr
+}
+
+func (s *fakeStorage) AddAuditEntry(entry storage.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = append(s.audit, entry)
+	return nil
+}
+
+func (s *fakeStorage) UserRole(userID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.users[userID] {
+		return "", storage.ErrAuthError
+	}
+	if role, ok := s.roles[userID]; ok {
+		return role, nil
+	}
+	return rbac.RoleUser, nil
+}
+
+func (s *fakeStorage) UserIDByLogin(login string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userID, ok := s.logins[login]
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+	return userID, nil
+}
+
+func (s *fakeStorage) CreateAdjustment(adjustment storage.Adjustment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.adjustments = append(s.adjustments, adjustment)
+	return nil
+}
+
+func (s *fakeStorage) ApproveAdjustment(id, approver string) (storage.Adjustment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decisions = append(s.decisions, decision{id: id, approver: approver})
+	return storage.Adjustment{ID: id}, s.decisionErr
+}
+
+func (s *fakeStorage) Adjustments(status string) ([]byte, error) {
+	return json.Marshal(map[string]string{"status": status})
+}
+
+func (s *fakeStorage) AuditEntries(filter storage.AuditFilter) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auditFilter = filter
+	return []byte("[]"), nil
+}
+
+func (s *fakeStorage) AddWebhook(webhook storage.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks = append(s.webhooks, webhook)
+	return nil
+}
+
+func (s *fakeStorage) UserEvents(userID string, afterID int64, limit int) ([]storage.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]storage.Event, 0)
+	for _, event := range s.events {
+		if event.ID > afterID && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *fakeStorage) LastEventID(userID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.events) == 0 {
+		return 0, nil
+	}
+	return s.events[len(s.events)-1].ID, nil
+}
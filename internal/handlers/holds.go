@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/service"
+	"gophermart/internal/storage"
+	"gophermart/internal/validation"
+)
+
+type holdRequest struct {
+	Order string  `json:"order"`
+	Sum   float32 `json:"sum"`
+	TTL   string  `json:"ttl,omitempty"`
+}
+
+func (h *Handler) CreateHold(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("CreateHold read body err")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request holdRequest
+	if err = json.Unmarshal(bytes, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response, err := h.services.Balance.CreateHold(h.client(r), userID, request.Order, request.Sum, request.TTL)
+	if errors.Is(err, validation.ErrInvalid) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, validation.ErrMalformed) || errors.Is(err, service.ErrInvalidSum) || errors.Is(err, service.ErrInvalidTTL) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, storage.ErrNotEnouthBalance) {
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+		return
+	}
+	if errors.Is(err, storage.ErrConflict) {
+		http.Error(w, "order is already paid or held", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("CreateHold err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	responseBZ, err := json.Marshal(response)
+	if err != nil {
+		log.Error().Err(err).Msg("CreateHold json.Marshal err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(responseBZ)
+}
+
+func (h *Handler) settleHold(w http.ResponseWriter, r *http.Request, settle func(ctx context.Context, userID, holdID string) error) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	err := settle(h.client(r), userID, chi.URLParam(r, "hold"))
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrGone) {
+		http.Error(w, "hold expired", http.StatusGone)
+		return
+	}
+	if errors.Is(err, storage.ErrConflict) {
+		http.Error(w, "hold is already settled or its order is paid", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, storage.ErrNotEnouthBalance) {
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("settleHold err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(nil)
+}
+
+func (h *Handler) CaptureHold(w http.ResponseWriter, r *http.Request) {
+	h.settleHold(w, r, h.services.Balance.CaptureHold)
+}
+
+func (h *Handler) VoidHold(w http.ResponseWriter, r *http.Request) {
+	h.settleHold(w, r, h.services.Balance.VoidHold)
+}
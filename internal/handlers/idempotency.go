@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/storage"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// idempotencyPruneInterval is how often expired keys are deleted.
+	idempotencyPruneInterval = time.Minute
+)
+
+// verifiedUserKey holds the user the Idempotency middleware authenticated, so the handler
+// behind it does not ask the storage again.
+type verifiedUserKey struct{}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+// Idempotency replays the stored response when a request is retried with the same Idempotency-Key.
+// Keys are scoped by the authenticated user, requests of unknown users go straight to the handler
+// which rejects them.
+func (h *Handler) Idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("Authorization")
+		key := r.Header.Get(idempotencyHeader)
+		if userID == "" || key == "" || h.services.Users.Authenticate(h.client(r), userID) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), verifiedUserKey{}, userID))
+		h.pruneIdempotency(r)
+
+		bodyBZ, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Error().Err(err).Msg("Idempotency read body err")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(bodyBZ))
+
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		hash.Write(bodyBZ)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		stored, err := h.store(r).ReserveIdempotencyKey(userID, key, fingerprint, time.Now().Add(-h.cfg.IdempotencyTTL))
+		if errors.Is(err, storage.ErrIdempotencyMismatch) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if errors.Is(err, storage.ErrIdempotencyInFlight) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Idempotency ReserveIdempotencyKey err")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if stored != nil {
+			log.Debug().Msgf("Idempotency replay key: %s", key)
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		// Unless the response is saved the key is released, also when next panics, so a retry
+		// is not answered with 409 for the whole TTL.
+		saved := false
+		defer func() {
+			if saved {
+				return
+			}
+			if err := h.store(r).ReleaseIdempotencyKey(userID, key); err != nil {
+				log.Error().Err(err).Msg("Idempotency ReleaseIdempotencyKey err")
+			}
+			if p := recover(); p != nil {
+				panic(p)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		if recorder.status >= http.StatusInternalServerError {
+			return
+		}
+		response := storage.IdempotentResponse{
+			Status:      recorder.status,
+			ContentType: w.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		}
+		if err = h.store(r).SaveIdempotentResponse(userID, key, response); err != nil {
+			log.Error().Err(err).Msg("Idempotency SaveIdempotentResponse err")
+			return
+		}
+		saved = true
+	})
+}
+
+// pruneIdempotency deletes expired keys at most once per idempotencyPruneInterval.
+func (h *Handler) pruneIdempotency(r *http.Request) {
+	now := time.Now()
+	h.pruneMu.Lock()
+	if now.Sub(h.lastPrune) < idempotencyPruneInterval {
+		h.pruneMu.Unlock()
+		return
+	}
+	h.lastPrune = now
+	h.pruneMu.Unlock()
+	if err := h.store(r).PruneIdempotencyKeys(now.Add(-h.cfg.IdempotencyTTL)); err != nil {
+		log.Error().Err(err).Msg("Idempotency PruneIdempotencyKeys err")
+	}
+}
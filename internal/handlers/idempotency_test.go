@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func idempotentRequest(user, key, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(body))
+	r.Header.Set("Authorization", user)
+	r.Header.Set(idempotencyHeader, key)
+	return r
+}
+
+func TestIdempotency(t *testing.T) {
+	strg := newFakeStorage("user")
+	h := newTestHandler(t, strg)
+	calls := 0
+	var inner http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":1}`))
+	}
+	handler := h.Idempotency(inner)
+
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, idempotentRequest("user", "key", `{"sum":1}`))
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	t.Run("replay", func(t *testing.T) {
+		replay := httptest.NewRecorder()
+		handler.ServeHTTP(replay, idempotentRequest("user", "key", `{"sum":1}`))
+		assert.Equal(t, http.StatusCreated, replay.Code)
+		assert.Equal(t, `{"id":1}`, replay.Body.String())
+		assert.Equal(t, "true", replay.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, "application/json", replay.Header().Get("Content-Type"))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		mismatch := httptest.NewRecorder()
+		handler.ServeHTTP(mismatch, idempotentRequest("user", "key", `{"sum":2}`))
+		assert.Equal(t, http.StatusUnprocessableEntity, mismatch.Code)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("unknown user is not scoped", func(t *testing.T) {
+		unknown := httptest.NewRecorder()
+		handler.ServeHTTP(unknown, idempotentRequest("forged", "key", `{"sum":1}`))
+		assert.Equal(t, 2, calls)
+		assert.Empty(t, strg.fingerprint["forged/key"])
+	})
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	h := newTestHandler(t, newFakeStorage("user"))
+	var handler http.Handler
+	var nested *httptest.ResponseRecorder
+	handler = h.Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if nested == nil {
+			nested = httptest.NewRecorder()
+			handler.ServeHTTP(nested, idempotentRequest("user", "key", `{}`))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), idempotentRequest("user", "key", `{}`))
+	require.NotNil(t, nested)
+	assert.Equal(t, http.StatusConflict, nested.Code)
+}
+
+func TestIdempotencyReleasesKey(t *testing.T) {
+	strg := newFakeStorage("user")
+	h := newTestHandler(t, strg)
+
+	t.Run("server error", func(t *testing.T) {
+		handler := h.Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), idempotentRequest("user", "error", `{}`))
+		assert.NotContains(t, strg.fingerprint, "user/error")
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		handler := h.Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}))
+		assert.PanicsWithValue(t, "boom", func() {
+			handler.ServeHTTP(httptest.NewRecorder(), idempotentRequest("user", "panic", `{}`))
+		})
+		assert.NotContains(t, strg.fingerprint, "user/panic")
+	})
+}
+
+func TestIdempotencyAuthenticatesOnce(t *testing.T) {
+	strg := newFakeStorage("user")
+	h := newTestHandler(t, strg)
+	handler := h.Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := h.authenticate(w, r); ok {
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, idempotentRequest("user", "first", `{"sum":1}`))
+	require.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, 1, strg.checks, "the handler reuses the user verified by the middleware")
+
+	second := httptest.NewRecorder()
+	handler.ServeHTTP(second, idempotentRequest("user", "second", `{"sum":1}`))
+	require.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, 1, strg.prunes, "expired keys are pruned at most once per interval")
+}
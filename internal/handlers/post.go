@@ -4,11 +4,15 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"mime"
 	"net/http"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 
+	"gophermart/internal/service"
 	"gophermart/internal/storage"
+	"gophermart/internal/validation"
 )
 
 func (h *Handler) Registration(w http.ResponseWriter, r *http.Request) {
@@ -23,11 +27,12 @@ func (h *Handler) Registration(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	log.Debug().Msgf("received new user: %s, %s", newUser.Login, newUser.Password)
-	newUser.Password = h.hashPasswd(newUser.Password)
-	userID := h.randomID(16)
-	log.Debug().Msgf("generated ID, hash: %s, %s", userID, newUser.Password)
-	err = h.strg.AddNewUser(newUser.Login, newUser.Password, userID)
+	log.Debug().Msgf("received new user: %s", newUser.Login)
+	userID, err := h.services.Users.Register(h.client(r), newUser.Login, newUser.Password, newUser.ReferralCode)
+	if errors.Is(err, validation.ErrMalformed) || errors.Is(err, validation.ErrInvalid) || errors.Is(err, storage.ErrReferralCode) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if errors.Is(err, storage.ErrConflict) {
 		http.Error(w, err.Error(), http.StatusConflict)
 		return
@@ -54,14 +59,27 @@ func (h *Handler) LogIn(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	newUser.Password = h.hashPasswd(newUser.Password)
-	userID, err := h.strg.LogInUser(newUser.Login, newUser.Password)
+	userID, err := h.services.Users.LogIn(h.client(r), newUser.Login, newUser.Password)
+	if errors.Is(err, validation.ErrMalformed) || errors.Is(err, validation.ErrInvalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var throttled *service.ThrottledError
+	if errors.As(err, &throttled) {
+		status := http.StatusTooManyRequests
+		if throttled.Locked {
+			status = http.StatusLocked
+		}
+		setRetryAfter(w, throttled.Wait)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
 	if errors.Is(err, storage.ErrAuthError) {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
 	if err != nil {
-		log.Error().Err(err).Msg("LogInUser err")
+		log.Error().Err(err).Msg("LogIn err")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -71,19 +89,8 @@ func (h *Handler) LogIn(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) Orders(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("Authorization")
-	if userID == "" {
-		http.Error(w, "user unauthorized", http.StatusUnauthorized)
-		return
-	}
-	err := h.strg.CheckUser(userID)
-	if errors.Is(err, storage.ErrAuthError) {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
-	}
-	if err != nil {
-		log.Error().Err(err).Msg("CheckUser err")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	userID, ok := h.authenticate(w, r)
+	if !ok {
 		return
 	}
 
@@ -94,15 +101,16 @@ func (h *Handler) Orders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !h.LynnCheckOrder(bytes) {
-		log.Error().Err(err).Msg("lynnCheckOrder err")
-		http.Error(w, "lynn Check Order error", http.StatusUnprocessableEntity)
+	err = h.services.Orders.Upload(h.client(r), userID, string(bytes))
+	if errors.Is(err, validation.ErrMalformed) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, validation.ErrInvalid) {
+		log.Debug().Err(err).Msg("Orders validator err")
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
-
-	order := string(bytes)
-
-	err = h.strg.AddNewOrder(userID, order)
 	if errors.Is(err, storage.ErrUploaded) {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.WriteHeader(http.StatusOK)
@@ -126,19 +134,8 @@ func (h *Handler) Orders(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) Withdraw(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("Authorization")
-	if userID == "" {
-		http.Error(w, "user unauthorized", http.StatusUnauthorized)
-		return
-	}
-	err := h.strg.CheckUser(userID)
-	if errors.Is(err, storage.ErrAuthError) {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
-	}
-	if err != nil {
-		log.Error().Err(err).Msg("CheckUser err")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	userID, ok := h.authenticate(w, r)
+	if !ok {
 		return
 	}
 
@@ -150,23 +147,28 @@ func (h *Handler) Withdraw(w http.ResponseWriter, r *http.Request) {
 	}
 	var withdrawEntry userWithdraw
 	if err = json.Unmarshal(bytes, &withdrawEntry); err != nil {
-		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	lynnBz := []byte(withdrawEntry.Order)
-
-	if !h.LynnCheckOrder(lynnBz) {
-		log.Error().Err(err).Msg("lynnCheckOrder err")
-		http.Error(w, "lynn Check Order error", http.StatusUnprocessableEntity)
+	err = h.services.Balance.Withdraw(h.client(r), userID, withdrawEntry.Order, withdrawEntry.Sum)
+	if errors.Is(err, validation.ErrInvalid) {
+		log.Debug().Err(err).Msg("Withdraw validator err")
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, validation.ErrMalformed) || errors.Is(err, service.ErrInvalidSum) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	err = h.strg.UserWithdraw(userID, withdrawEntry.Order, withdrawEntry.Sum)
 	if errors.Is(err, storage.ErrNotEnouthBalance) {
 		log.Error().Err(err).Msg("Withdraw UserWithdraw err")
 		http.Error(w, err.Error(), http.StatusPaymentRequired)
 		return
 	}
+	if errors.Is(err, storage.ErrConflict) {
+		http.Error(w, "order is already paid or held", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("Withdraw UserWithdraw err")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -177,3 +179,55 @@ func (h *Handler) Withdraw(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write(nil)
 }
+
+func (h *Handler) OrdersBatch(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("OrdersBatch read body err")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var numbers []string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		if err = json.Unmarshal(bytes, &numbers); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		for _, line := range strings.Split(string(bytes), "\n") {
+			if strings.TrimSpace(line) != "" {
+				numbers = append(numbers, line)
+			}
+		}
+	}
+	results, accepted, err := h.services.Orders.UploadBatch(h.client(r), userID, numbers)
+	if errors.Is(err, service.ErrEmptyBatch) || errors.Is(err, service.ErrBatchTooLarge) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("OrdersBatch err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resultsBZ, err := json.Marshal(results)
+	if err != nil {
+		log.Error().Err(err).Msg("OrdersBatch json.Marshal err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if accepted != 0 {
+		w.WriteHeader(http.StatusAccepted)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.Write(resultsBZ)
+}
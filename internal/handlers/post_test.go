@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/service"
+)
+
+func TestWithdrawValidation(t *testing.T) {
+	h := newTestHandler(t, newFakeStorage("user"))
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "malformed json", body: `{"order":`, want: http.StatusBadRequest},
+		{name: "empty order", body: `{"order":"","sum":10}`, want: http.StatusBadRequest},
+		{name: "blank order", body: `{"order":"  ","sum":10}`, want: http.StatusBadRequest},
+		{name: "letters in order", body: `{"order":"12ab","sum":10}`, want: http.StatusUnprocessableEntity},
+		{name: "wrong checksum", body: `{"order":"2377225625","sum":10}`, want: http.StatusUnprocessableEntity},
+		{name: "zero sum", body: `{"order":"2377225624","sum":0}`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "user")
+			w := httptest.NewRecorder()
+			h.Withdraw(w, r)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestOrdersBatch(t *testing.T) {
+	strg := newFakeStorage("user", "other")
+	strg.orders["79927398713"] = "other"
+	h := newTestHandler(t, strg)
+
+	send := func(contentType, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/user/orders/batch", strings.NewReader(body))
+		r.Header.Set("Authorization", "user")
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		h.OrdersBatch(w, r)
+		return w
+	}
+
+	w := send("application/json", `["12345678903", "79927398713", "123"]`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var results []service.BatchResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	require.Len(t, results, 3)
+	assert.Equal(t, service.BatchAccepted, results[0].Result)
+	assert.Equal(t, service.BatchConflict, results[1].Result)
+	assert.Equal(t, service.BatchInvalid, results[2].Result)
+
+	// Nothing new accepted answers 200.
+	w = send("text/plain", "12345678903\n\n")
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	require.Len(t, results, 1)
+	assert.Equal(t, service.BatchUploaded, results[0].Result)
+
+	assert.Equal(t, http.StatusBadRequest, send("application/json", `[]`).Code)
+	assert.Equal(t, http.StatusBadRequest, send("application/json", `["1","2","3","4"]`).Code)
+	assert.Equal(t, http.StatusBadRequest, send("application/json", `{"number":"1"}`).Code)
+}
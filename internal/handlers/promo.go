@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/service"
+	"gophermart/internal/storage"
+)
+
+type newPromoCode struct {
+	Code      string     `json:"code"`
+	Type      string     `json:"type"`
+	Bonus     float32    `json:"bonus,omitempty"`
+	Percent   float64    `json:"percent,omitempty"`
+	Orders    int        `json:"orders,omitempty"`
+	MaxUses   int        `json:"max_uses,omitempty"`
+	ValidFrom *time.Time `json:"valid_from,omitempty"`
+	ValidTo   *time.Time `json:"valid_to,omitempty"`
+}
+
+type promoRedeem struct {
+	Code string `json:"code"`
+}
+
+type promoRedeemed struct {
+	Code    string  `json:"code"`
+	Type    string  `json:"type"`
+	Bonus   float32 `json:"bonus,omitempty"`
+	Percent float64 `json:"percent,omitempty"`
+	Orders  int     `json:"orders,omitempty"`
+}
+
+func (h *Handler) AdminCreatePromo(w http.ResponseWriter, r *http.Request) {
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("AdminCreatePromo read body err")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var promo newPromoCode
+	if err = json.Unmarshal(bytes, &promo); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	code := storage.PromoCode{
+		Code:      promo.Code,
+		Kind:      promo.Type,
+		Bonus:     int(promo.Bonus * 100),
+		Percent:   promo.Percent,
+		Orders:    promo.Orders,
+		MaxUses:   promo.MaxUses,
+		CreatedBy: adminActor(r),
+	}
+	if promo.ValidFrom != nil {
+		code.ValidFrom = *promo.ValidFrom
+	}
+	if promo.ValidTo != nil {
+		code.ValidTo = *promo.ValidTo
+	}
+	err = h.services.Promos.Create(h.client(r), code)
+	if errors.Is(err, service.ErrInvalidPromo) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, storage.ErrConflict) {
+		http.Error(w, "code already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("AdminCreatePromo Create err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(nil)
+}
+
+func (h *Handler) RedeemPromo(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("RedeemPromo read body err")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var redeem promoRedeem
+	if err = json.Unmarshal(bytes, &redeem); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	promo, err := h.services.Promos.Redeem(h.client(r), userID, redeem.Code)
+	if errors.Is(err, service.ErrEmptyPromo) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "unknown promo code", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrGone) {
+		http.Error(w, "promo code is expired or exhausted", http.StatusGone)
+		return
+	}
+	if errors.Is(err, storage.ErrConflict) {
+		http.Error(w, "promo code already redeemed", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("RedeemPromo Redeem err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	redeemedBZ, err := json.Marshal(promoRedeemed{Code: promo.Code, Type: promo.Kind, Bonus: float32(promo.Bonus) / 100, Percent: promo.Percent, Orders: promo.Orders})
+	if err != nil {
+		log.Error().Err(err).Msg("RedeemPromo json.Marshal err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(redeemedBZ)
+}
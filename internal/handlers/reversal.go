@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/storage"
+)
+
+func (h *Handler) reverseWithdrawal(w http.ResponseWriter, r *http.Request, userID, actor string) {
+	order := chi.URLParam(r, "order")
+	window := h.cfg.WithdrawCancelWindow
+	if userID == "" {
+		window = 0
+	}
+	err := h.store(r).ReverseWithdrawal(userID, order, window, actor)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrReversed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, storage.ErrGone) {
+		http.Error(w, "cancellation window is over", http.StatusGone)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("ReverseWithdrawal err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(r, storage.AuditEntry{Actor: actor, Action: "withdrawal.reverse", Target: order})
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(nil)
+}
+
+func (h *Handler) CancelWithdrawal(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	h.reverseWithdrawal(w, r, userID, "user:"+userID)
+}
+
+func (h *Handler) AdminReverseWithdrawal(w http.ResponseWriter, r *http.Request) {
+	h.reverseWithdrawal(w, r, "", adminActor(r))
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/storage"
+)
+
+func reversalRequest(user, order string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdrawals/"+order+"/cancel", nil)
+	r.Header.Set("Authorization", user)
+	routeCtx := chi.NewRouteContext()
+	routeCtx.URLParams.Add("order", order)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, routeCtx))
+}
+
+func TestCancelWithdrawal(t *testing.T) {
+	strg := newFakeStorage("user")
+	h := newTestHandler(t, strg)
+
+	w := httptest.NewRecorder()
+	h.CancelWithdrawal(w, reversalRequest("user", "2377225624"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, strg.reversals, 1)
+	assert.Equal(t, reversal{userID: "user", order: "2377225624", window: time.Hour, actor: "user:user"}, strg.reversals[0])
+	require.Len(t, strg.audit, 1)
+	assert.Equal(t, "withdrawal.reverse", strg.audit[0].Action)
+
+	w = httptest.NewRecorder()
+	h.AdminReverseWithdrawal(w, reversalRequest("", "2377225624"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", strg.reversals[1].userID)
+	assert.Zero(t, strg.reversals[1].window, "admins are not bound by the cancellation window")
+
+	for err, want := range map[error]int{
+		storage.ErrNotFound: http.StatusNotFound,
+		storage.ErrReversed: http.StatusConflict,
+		storage.ErrGone:     http.StatusGone,
+	} {
+		strg.reversalErr = err
+		w = httptest.NewRecorder()
+		h.CancelWithdrawal(w, reversalRequest("user", "2377225624"))
+		assert.Equal(t, want, w.Code, err.Error())
+	}
+	assert.Len(t, strg.audit, 2, "failed reversals are not audited")
+
+	w = httptest.NewRecorder()
+	h.CancelWithdrawal(w, reversalRequest("stranger", "2377225624"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/storage"
+)
+
+type statementRow struct {
+	Date      string  `json:"date"`
+	Kind      string  `json:"kind"`
+	Reference string  `json:"reference"`
+	Amount    float64 `json:"amount"`
+	Balance   float64 `json:"balance"`
+}
+
+func newStatementRow(entry storage.StatementEntry) statementRow {
+	return statementRow{
+		Date:      entry.At.Format(time.RFC3339),
+		Kind:      entry.Kind,
+		Reference: entry.Reference,
+		Amount:    float64(entry.Amount) / 100,
+		Balance:   float64(entry.Balance) / 100,
+	}
+}
+
+// parseStatementDate accepts RFC3339 timestamps or plain dates; a plain "to" date includes the whole day.
+func parseStatementDate(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("wrong date %q, expected RFC3339 or YYYY-MM-DD", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func (h *Handler) Statement(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	query := r.URL.Query()
+	from := time.Unix(0, 0)
+	to := time.Now()
+	if value := query.Get("from"); value != "" {
+		if from, err = parseStatementDate(value, false); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if to, err = parseStatementDate(value, true); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be earlier than to", http.StatusBadRequest)
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "format must be csv or json", http.StatusBadRequest)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	filename := fmt.Sprintf("statement_%s_%s.%s", from.Format("20060102"), to.Format("20060102"), format)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	rows := 0
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"date", "kind", "reference", "amount", "balance"})
+		err = h.store(r).UserStatement(userID, from, to, func(entry storage.StatementEntry) error {
+			row := newStatementRow(entry)
+			writer.Write([]string{row.Date, row.Kind, row.Reference, strconv.FormatFloat(row.Amount, 'f', 2, 64), strconv.FormatFloat(row.Balance, 'f', 2, 64)})
+			rows++
+			if rows%100 == 0 {
+				writer.Flush()
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			return writer.Error()
+		})
+		writer.Flush()
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("["))
+		err = h.store(r).UserStatement(userID, from, to, func(entry storage.StatementEntry) error {
+			rowBZ, err := json.Marshal(newStatementRow(entry))
+			if err != nil {
+				return err
+			}
+			if rows != 0 {
+				w.Write([]byte(","))
+			}
+			if _, err = w.Write(rowBZ); err != nil {
+				return err
+			}
+			rows++
+			if rows%100 == 0 && flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		})
+		if err == nil {
+			w.Write([]byte("]"))
+		}
+	}
+	if err != nil {
+		// The status is sent already, aborting the response keeps a truncated statement from
+		// looking complete to the client.
+		log.Error().Err(err).Msgf("Statement UserStatement err after %d rows", rows)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/storage"
+)
+
+func statementStorage() *fakeStorage {
+	strg := newFakeStorage("user")
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	strg.statement = []storage.StatementEntry{
+		{Kind: storage.PostingAccrual, Reference: "12345678903", Amount: 50000, At: at},
+		{Kind: storage.PostingWithdrawal, Reference: "2377225624", Amount: -12050, At: at.Add(time.Hour)},
+		{Kind: storage.PostingAccrual, Reference: "79927398713", Amount: 100, At: at.Add(2 * time.Hour)},
+	}
+	return strg
+}
+
+func statement(h *Handler, format string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/user/balance/statement?format="+format+"&from=2024-01-01", nil)
+	r.Header.Set("Authorization", "user")
+	w := httptest.NewRecorder()
+	h.Statement(w, r)
+	return w
+}
+
+func TestStatement(t *testing.T) {
+	h := newTestHandler(t, statementStorage())
+
+	w := statement(h, "json")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var rows []statementRow
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rows))
+	require.Len(t, rows, 3)
+	assert.Equal(t, 500.0, rows[0].Balance)
+	assert.Equal(t, -120.5, rows[1].Amount)
+	assert.Equal(t, 380.5, rows[2].Balance)
+
+	w = statement(h, "csv")
+	assert.Equal(t, http.StatusOK, w.Code)
+	records, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, []string{"date", "kind", "reference", "amount", "balance"}, records[0])
+	assert.Equal(t, "380.50", records[3][4])
+}
+
+func TestStatementAbortsOnError(t *testing.T) {
+	strg := statementStorage()
+	strg.statementErr = errors.New("connection reset")
+	h := newTestHandler(t, strg)
+
+	for _, format := range []string{"json", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			var w *httptest.ResponseRecorder
+			assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+				w = httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, "/api/user/balance/statement?format="+format, nil)
+				r.Header.Set("Authorization", "user")
+				h.Statement(w, r)
+			})
+			assert.False(t, strings.HasSuffix(w.Body.String(), "]"), "truncated statement must not be closed")
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/tier"
+)
+
+type userTier struct {
+	Tier          string  `json:"tier"`
+	Multiplier    float64 `json:"multiplier"`
+	Basis         string  `json:"basis"`
+	Points        float32 `json:"points"`
+	NextTier      string  `json:"next_tier,omitempty"`
+	NextThreshold float32 `json:"next_threshold,omitempty"`
+	Remaining     float32 `json:"remaining,omitempty"`
+}
+
+func (h *Handler) Tier(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if len(h.tiers) == 0 {
+		http.Error(w, "loyalty tiers are not enabled", http.StatusNotFound)
+		return
+	}
+
+	current, err := h.store(r).UserTier(userID, h.cfg.TierBasis, time.Now().AddDate(0, -tier.Period, 0))
+	if err != nil {
+		log.Error().Err(err).Msg("Tier UserTier err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Tiers are assigned by the recalculation job; until it runs the user stays in the lowest one.
+	if current.Tier == "" {
+		lowest, _ := h.tiers.For(0)
+		current.Tier = lowest.Name
+		current.Multiplier = lowest.Multiplier
+	}
+	result := userTier{
+		Tier:       current.Tier,
+		Multiplier: current.Multiplier,
+		Basis:      h.cfg.TierBasis,
+		Points:     float32(current.Points) / 100,
+	}
+	for i, t := range h.tiers {
+		if t.Name != current.Tier || i+1 == len(h.tiers) {
+			continue
+		}
+		next := h.tiers[i+1]
+		result.NextTier = next.Name
+		result.NextThreshold = float32(next.Threshold) / 100
+		if next.Threshold > current.Points {
+			result.Remaining = float32(next.Threshold-current.Points) / 100
+		}
+	}
+
+	resultBZ, err := json.Marshal(result)
+	if err != nil {
+		log.Error().Err(err).Msg("Tier json.Marshal err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resultBZ)
+}
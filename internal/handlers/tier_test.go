@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTierDisabled(t *testing.T) {
+	h := newTestHandler(t, newFakeStorage("user"))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/user/tier", nil)
+	r.Header.Set("Authorization", "user")
+	w := httptest.NewRecorder()
+	h.Tier(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
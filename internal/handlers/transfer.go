@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/service"
+	"gophermart/internal/storage"
+)
+
+type userTransfer struct {
+	Login   string  `json:"login"`
+	Sum     float32 `json:"sum"`
+	Comment string  `json:"comment,omitempty"`
+}
+
+func (h *Handler) Transfer(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("Transfer read body err")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var transfer userTransfer
+	if err = json.Unmarshal(bytes, &transfer); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = h.services.Balance.Transfer(h.client(r), userID, transfer.Login, transfer.Sum, transfer.Comment)
+	if errors.Is(err, service.ErrInvalidTransfer) || errors.Is(err, service.ErrCommentTooLong) || errors.Is(err, service.ErrSelfTransfer) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, service.ErrUnknownReceiver) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrNotEnouthBalance) {
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+		return
+	}
+	if errors.Is(err, storage.ErrLimitExceeded) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Transfer TransferPoints err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(nil)
+}
+
+func (h *Handler) TransferHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	transfers, err := h.services.Balance.Transfers(h.client(r), userID)
+	if errors.Is(err, storage.ErrNoContent) {
+		http.Error(w, err.Error(), http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("TransferHistory UserTransfers err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(transfers)
+}
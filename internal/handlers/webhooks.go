@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/service"
+	"gophermart/internal/storage"
+	"gophermart/internal/webhook"
+)
+
+const webhookSecretLength = 32
+
+type newWebhook struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events"`
+}
+
+type webhookCreated struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+func validateWebhook(ctx context.Context, hook newWebhook) error {
+	if err := webhook.CheckURL(ctx, hook.URL); err != nil {
+		return err
+	}
+	if len(hook.Events) == 0 {
+		return errors.New("at least one event is required")
+	}
+	for _, event := range hook.Events {
+		if !storage.WebhookEvents[event] {
+			return errors.New("unknown event " + event)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) AddWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	bytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error().Err(err).Msg("AddWebhook read body err")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var webhook newWebhook
+	if err = json.Unmarshal(bytes, &webhook); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = validateWebhook(r.Context(), webhook); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = service.NewID(webhookSecretLength)
+	}
+
+	created := webhookCreated{ID: service.NewID(16), URL: webhook.URL, Secret: webhook.Secret, Events: webhook.Events}
+	err = h.store(r).AddWebhook(storage.Webhook{ID: created.ID, UserID: userID, URL: created.URL, Secret: created.Secret, Events: created.Events})
+	if err != nil {
+		log.Error().Err(err).Msg("AddWebhook err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, storage.AuditEntry{Actor: "user:" + userID, Action: "webhook.create", Target: created.ID, Details: created.URL})
+
+	createdBZ, err := json.Marshal(created)
+	if err != nil {
+		log.Error().Err(err).Msg("AddWebhook json.Marshal err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(createdBZ)
+}
+
+func (h *Handler) Webhooks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	webhooks, err := h.store(r).UserWebhooks(userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Webhooks UserWebhooks err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(webhooks)
+}
+
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	webhookID := chi.URLParam(r, "webhook")
+	err := h.store(r).DeleteWebhook(userID, webhookID)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("DeleteWebhook err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, storage.AuditEntry{Actor: "user:" + userID, Action: "webhook.delete", Target: webhookID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := h.store(r).WebhookDeliveries(userID, chi.URLParam(r, "webhook"))
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("WebhookDeliveries err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(deliveries)
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddWebhook(t *testing.T) {
+	strg := newFakeStorage("user")
+	h := newTestHandler(t, strg)
+	post := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/user/webhooks", strings.NewReader(body))
+		r.Header.Set("Authorization", "user")
+		w := httptest.NewRecorder()
+		h.AddWebhook(w, r)
+		return w
+	}
+
+	w := post(`{"url":"https://203.0.113.10/hooks","events":["order.processed"]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created webhookCreated
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Len(t, created.Secret, webhookSecretLength)
+	require.Len(t, strg.webhooks, 1)
+	assert.Equal(t, created.Secret, strg.webhooks[0].Secret)
+	assert.Equal(t, "user", strg.webhooks[0].UserID)
+
+	for _, body := range []string{
+		`{"url":"http://127.0.0.1:8080/hooks","events":["order.processed"]}`,
+		`{"url":"http://10.0.0.5/hooks","events":["order.processed"]}`,
+		`{"url":"http://169.254.169.254/latest/meta-data/","events":["order.processed"]}`,
+		`{"url":"http://[::1]/hooks","events":["order.processed"]}`,
+		`{"url":"ftp://203.0.113.10/hooks","events":["order.processed"]}`,
+		`{"url":"https://203.0.113.10/hooks","events":[]}`,
+		`{"url":"https://203.0.113.10/hooks","events":["order.lost"]}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, post(body).Code, body)
+	}
+	assert.Len(t, strg.webhooks, 1)
+}
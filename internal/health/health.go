@@ -0,0 +1,143 @@
+// Package health serves the liveness and readiness probes.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/accrualreader"
+	"gophermart/internal/storage"
+)
+
+const (
+	statusOK       = "ok"
+	statusFail     = "fail"
+	statusDegraded = "degraded"
+
+	pingTimeout = 2 * time.Second
+)
+
+// AccrualStatus reports on the accrual reader.
+type AccrualStatus interface {
+	Heartbeat() time.Time
+	CircuitState() string
+}
+
+type Checker struct {
+	strg            storage.Storager
+	accrual         AccrualStatus
+	maxHeartbeatAge time.Duration
+	shuttingDown    atomic.Bool
+}
+
+func NewChecker(strg storage.Storager, accrual AccrualStatus, maxHeartbeatAge time.Duration) *Checker {
+	return &Checker{strg: strg, accrual: accrual, maxHeartbeatAge: maxHeartbeatAge}
+}
+
+type check struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Latency  string `json:"latency,omitempty"`
+	Version  int    `json:"version,omitempty"`
+	Expected int    `json:"expected,omitempty"`
+	Age      string `json:"heartbeat_age,omitempty"`
+	State    string `json:"state,omitempty"`
+}
+
+type readiness struct {
+	Status string           `json:"status"`
+	Checks map[string]check `json:"checks"`
+}
+
+// ShuttingDown makes the instance report not ready, so it is taken out of rotation while it drains.
+func (c *Checker) ShuttingDown() {
+	c.shuttingDown.Store(true)
+}
+
+// Healthz only tells that the process serves requests.
+func (c *Checker) Healthz(w http.ResponseWriter, r *http.Request) {
+	c.write(w, http.StatusOK, map[string]string{"status": statusOK})
+}
+
+// Readyz reports every check. The instance is ready when none of them failed; an open accrual
+// circuit only degrades it since new requests can still be served.
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	result := readiness{Status: "ready", Checks: map[string]check{
+		"database":        c.checkDatabase(r.Context()),
+		"schema":          c.checkSchema(),
+		"accrual_reader":  c.checkHeartbeat(),
+		"accrual_circuit": c.checkCircuit(),
+	}}
+	status := http.StatusOK
+	for _, ch := range result.Checks {
+		if ch.Status == statusFail {
+			result.Status = "not_ready"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	if c.shuttingDown.Load() {
+		result.Status = "shutting_down"
+		status = http.StatusServiceUnavailable
+	}
+	c.write(w, status, result)
+}
+
+func (c *Checker) checkDatabase(ctx context.Context) check {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	start := time.Now()
+	if err := c.strg.Ping(ctx); err != nil {
+		return check{Status: statusFail, Error: err.Error()}
+	}
+	return check{Status: statusOK, Latency: time.Since(start).String()}
+}
+
+func (c *Checker) checkSchema() check {
+	version, err := c.strg.SchemaVersion()
+	if err != nil {
+		return check{Status: statusFail, Error: err.Error()}
+	}
+	result := check{Status: statusOK, Version: version, Expected: storage.CurrentSchemaVersion}
+	if version < storage.CurrentSchemaVersion {
+		result.Status = statusFail
+		result.Error = "schema is older than this build expects"
+	}
+	return result
+}
+
+func (c *Checker) checkHeartbeat() check {
+	age := time.Since(c.accrual.Heartbeat())
+	result := check{Status: statusOK, Age: age.Round(time.Millisecond).String()}
+	if age > c.maxHeartbeatAge {
+		result.Status = statusFail
+		result.Error = fmt.Sprintf("no heartbeat for longer than %s", c.maxHeartbeatAge)
+	}
+	return result
+}
+
+func (c *Checker) checkCircuit() check {
+	state := c.accrual.CircuitState()
+	if state == accrualreader.CircuitClosed {
+		return check{Status: statusOK, State: state}
+	}
+	return check{Status: statusDegraded, State: state}
+}
+
+func (c *Checker) write(w http.ResponseWriter, status int, body interface{}) {
+	bodyBZ, err := json.Marshal(body)
+	if err != nil {
+		log.Error().Err(err).Msg("health json.Marshal err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(bodyBZ)
+}
@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/accrualreader"
+	"gophermart/internal/storage"
+)
+
+type fakeStorage struct {
+	storage.Storager
+	pingErr error
+	version int
+}
+
+func (s fakeStorage) Ping(ctx context.Context) error {
+	return s.pingErr
+}
+
+func (s fakeStorage) SchemaVersion() (int, error) {
+	return s.version, nil
+}
+
+type fakeAccrual struct {
+	heartbeat time.Time
+	circuit   string
+}
+
+func (a fakeAccrual) Heartbeat() time.Time {
+	return a.heartbeat
+}
+
+func (a fakeAccrual) CircuitState() string {
+	return a.circuit
+}
+
+func readyz(t *testing.T, checker *Checker) (int, readiness) {
+	recorder := httptest.NewRecorder()
+	checker.Readyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var body readiness
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	return recorder.Code, body
+}
+
+func TestReadyz(t *testing.T) {
+	healthy := fakeStorage{version: storage.CurrentSchemaVersion}
+	alive := fakeAccrual{heartbeat: time.Now(), circuit: accrualreader.CircuitClosed}
+
+	tests := []struct {
+		name    string
+		strg    fakeStorage
+		accrual fakeAccrual
+		code    int
+		status  string
+		failed  string
+	}{
+		{name: "ready", strg: healthy, accrual: alive, code: http.StatusOK, status: "ready"},
+		{name: "database down", strg: fakeStorage{pingErr: errors.New("connection refused"), version: storage.CurrentSchemaVersion}, accrual: alive,
+			code: http.StatusServiceUnavailable, status: "not_ready", failed: "database"},
+		{name: "old schema", strg: fakeStorage{version: storage.CurrentSchemaVersion - 1}, accrual: alive,
+			code: http.StatusServiceUnavailable, status: "not_ready", failed: "schema"},
+		{name: "stale reader", strg: healthy, accrual: fakeAccrual{heartbeat: time.Now().Add(-time.Minute), circuit: accrualreader.CircuitClosed},
+			code: http.StatusServiceUnavailable, status: "not_ready", failed: "accrual_reader"},
+		{name: "open circuit", strg: healthy, accrual: fakeAccrual{heartbeat: time.Now(), circuit: accrualreader.CircuitOpen},
+			code: http.StatusOK, status: "ready"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := readyz(t, NewChecker(tt.strg, tt.accrual, 30*time.Second))
+			assert.Equal(t, tt.code, code)
+			assert.Equal(t, tt.status, body.Status)
+			assert.Len(t, body.Checks, 4)
+			for name, ch := range body.Checks {
+				if name == tt.failed {
+					assert.Equal(t, statusFail, ch.Status, name)
+					assert.NotEmpty(t, ch.Error, name)
+					continue
+				}
+				assert.NotEqual(t, statusFail, ch.Status, name)
+			}
+		})
+	}
+}
+
+func TestReadyzShuttingDown(t *testing.T) {
+	checker := NewChecker(fakeStorage{version: storage.CurrentSchemaVersion}, fakeAccrual{heartbeat: time.Now(), circuit: accrualreader.CircuitClosed}, time.Minute)
+	code, _ := readyz(t, checker)
+	assert.Equal(t, http.StatusOK, code)
+
+	checker.ShuttingDown()
+	code, body := readyz(t, checker)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "shutting_down", body.Status)
+
+	recorder := httptest.NewRecorder()
+	checker.Healthz(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
@@ -0,0 +1,152 @@
+// Package metrics exposes Prometheus metrics of the HTTP API, the storage and the background workers.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/storage"
+)
+
+const namespace = "gophermart"
+
+var registry = prometheus.NewRegistry()
+
+var (
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by route pattern, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	dbDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_call_duration_seconds",
+		Help:      "Duration of storage calls by method.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"method"})
+
+	accrualPolls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accrual_polls_total",
+		Help:      "Requests to the accrual system by outcome: the response status or error.",
+	}, []string{"outcome"})
+
+	workerRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "worker_running",
+		Help:      "Whether the background worker is running.",
+	}, []string{"worker"})
+
+	workerLastRun = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "worker_last_run_timestamp_seconds",
+		Help:      "When the background worker last finished a pass.",
+	}, []string{"worker"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpDuration,
+		dbDuration,
+		accrualPolls,
+		workerRunning,
+		workerLastRun,
+	)
+}
+
+// RegisterStats adds the order backlog and point totals of strg, read on every scrape.
+func RegisterStats(strg storage.Storager) {
+	registry.MustRegister(newStatsCollector(strg))
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Middleware observes request durations by the chi route pattern so that path parameters
+// do not multiply the series.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpDuration.WithLabelValues(route, r.Method, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// AccrualPoll counts a request to the accrual system, status 0 for a request that failed.
+func AccrualPoll(status int) {
+	outcome := "error"
+	if status != 0 {
+		outcome = strconv.Itoa(status)
+	}
+	accrualPolls.WithLabelValues(outcome).Inc()
+}
+
+func WorkerStarted(worker string) {
+	workerRunning.WithLabelValues(worker).Set(1)
+}
+
+func WorkerStopped(worker string) {
+	workerRunning.WithLabelValues(worker).Set(0)
+}
+
+func WorkerRan(worker string) {
+	workerLastRun.WithLabelValues(worker).SetToCurrentTime()
+}
+
+type statsCollector struct {
+	strg      storage.Storager
+	backlog   *prometheus.Desc
+	credited  *prometheus.Desc
+	withdrawn *prometheus.Desc
+}
+
+func newStatsCollector(strg storage.Storager) *statsCollector {
+	return &statsCollector{
+		strg:      strg,
+		backlog:   prometheus.NewDesc(namespace+"_orders_backlog", "Orders waiting for the accrual system.", nil, nil),
+		credited:  prometheus.NewDesc(namespace+"_points_credited_total", "Points credited to users by kind.", []string{"kind"}, nil),
+		withdrawn: prometheus.NewDesc(namespace+"_points_withdrawn_total", "Points withdrawn by users.", nil, nil),
+	}
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.backlog
+	ch <- c.credited
+	ch <- c.withdrawn
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.strg.Stats()
+	if err != nil {
+		log.Error().Err(err).Msg("metrics Stats err")
+		ch <- prometheus.NewInvalidMetric(c.backlog, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.backlog, prometheus.GaugeValue, float64(stats.Backlog))
+	for kind, amount := range stats.Credited {
+		ch <- prometheus.MustNewConstMetric(c.credited, prometheus.CounterValue, float64(amount)/100, kind)
+	}
+	ch <- prometheus.MustNewConstMetric(c.withdrawn, prometheus.CounterValue, float64(stats.Withdrawn)/100)
+}
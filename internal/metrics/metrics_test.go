@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/api/user/webhooks/{webhook}/deliveries", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	router.Get("/api/user/balance", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	})
+
+	for _, target := range []string{"/api/user/webhooks/a/deliveries", "/api/user/webhooks/b/deliveries", "/api/user/balance", "/nowhere"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(httpDuration))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	observed := make(map[string]uint64)
+	for _, metric := range families[0].GetMetric() {
+		labels := make(map[string]string)
+		for _, label := range metric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		observed[labels["route"]+" "+labels["status"]] = metric.GetHistogram().GetSampleCount()
+	}
+	assert.Equal(t, map[string]uint64{
+		"/api/user/webhooks/{webhook}/deliveries 404": 2,
+		"/api/user/balance 200":                       1,
+		"unmatched 404":                               1,
+	}, observed)
+}
+
+func TestAccrualPoll(t *testing.T) {
+	AccrualPoll(http.StatusOK)
+	AccrualPoll(http.StatusOK)
+	AccrualPoll(http.StatusTooManyRequests)
+	AccrualPoll(0)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(accrualPolls.WithLabelValues("200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(accrualPolls.WithLabelValues("429")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(accrualPolls.WithLabelValues("error")))
+}
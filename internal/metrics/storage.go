@@ -0,0 +1,340 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"gophermart/internal/storage"
+)
+
+// Storage times every call of the wrapped storage.
+type Storage struct {
+	strg storage.Storager
+}
+
+func NewStorage(strg storage.Storager) *Storage {
+	return &Storage{strg: strg}
+}
+
+func observeDB(method string, start time.Time) {
+	dbDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func (s *Storage) AddNewUser(user storage.NewUser) error {
+	defer observeDB("AddNewUser", time.Now())
+	return s.strg.AddNewUser(user)
+}
+
+func (s *Storage) LogInUser(login, password string) (string, error) {
+	defer observeDB("LogInUser", time.Now())
+	return s.strg.LogInUser(login, password)
+}
+
+func (s *Storage) CheckUser(userID string) error {
+	defer observeDB("CheckUser", time.Now())
+	return s.strg.CheckUser(userID)
+}
+
+func (s *Storage) AddNewOrder(userID, orders string) error {
+	defer observeDB("AddNewOrder", time.Now())
+	return s.strg.AddNewOrder(userID, orders)
+}
+
+func (s *Storage) AddNewOrders(userID string, orders []string) ([]error, error) {
+	defer observeDB("AddNewOrders", time.Now())
+	return s.strg.AddNewOrders(userID, orders)
+}
+
+func (s *Storage) UserWithdraw(userID, order string, sum float32) error {
+	defer observeDB("UserWithdraw", time.Now())
+	return s.strg.UserWithdraw(userID, order, sum)
+}
+
+func (s *Storage) ReverseWithdrawal(userID, order string, window time.Duration, actor string) error {
+	defer observeDB("ReverseWithdrawal", time.Now())
+	return s.strg.ReverseWithdrawal(userID, order, window, actor)
+}
+
+func (s *Storage) CreateHold(userID, holdID, order string, sum float32, expiresAt time.Time) error {
+	defer observeDB("CreateHold", time.Now())
+	return s.strg.CreateHold(userID, holdID, order, sum, expiresAt)
+}
+
+func (s *Storage) CaptureHold(userID, holdID string) error {
+	defer observeDB("CaptureHold", time.Now())
+	return s.strg.CaptureHold(userID, holdID)
+}
+
+func (s *Storage) VoidHold(userID, holdID string) error {
+	defer observeDB("VoidHold", time.Now())
+	return s.strg.VoidHold(userID, holdID)
+}
+
+func (s *Storage) ExpireHolds(now time.Time) (int, error) {
+	defer observeDB("ExpireHolds", time.Now())
+	return s.strg.ExpireHolds(now)
+}
+
+func (s *Storage) TransferPoints(userID, transferID, toLogin string, sum float32, comment string, dailyAmount, dailyCount int) error {
+	defer observeDB("TransferPoints", time.Now())
+	return s.strg.TransferPoints(userID, transferID, toLogin, sum, comment, dailyAmount, dailyCount)
+}
+
+func (s *Storage) UserTransfers(userID string) ([]byte, error) {
+	defer observeDB("UserTransfers", time.Now())
+	return s.strg.UserTransfers(userID)
+}
+
+func (s *Storage) AddPromoCode(promo storage.PromoCode) error {
+	defer observeDB("AddPromoCode", time.Now())
+	return s.strg.AddPromoCode(promo)
+}
+
+func (s *Storage) RedeemPromoCode(userID, code string) (storage.PromoCode, error) {
+	defer observeDB("RedeemPromoCode", time.Now())
+	return s.strg.RedeemPromoCode(userID, code)
+}
+
+func (s *Storage) UserReferrals(userID string) ([]byte, error) {
+	defer observeDB("UserReferrals", time.Now())
+	return s.strg.UserReferrals(userID)
+}
+
+func (s *Storage) UserRole(userID string) (string, error) {
+	defer observeDB("UserRole", time.Now())
+	return s.strg.UserRole(userID)
+}
+
+func (s *Storage) SetUserRole(login, role string) error {
+	defer observeDB("SetUserRole", time.Now())
+	return s.strg.SetUserRole(login, role)
+}
+
+func (s *Storage) UserIDByLogin(login string) (string, error) {
+	defer observeDB("UserIDByLogin", time.Now())
+	return s.strg.UserIDByLogin(login)
+}
+
+func (s *Storage) AdminUser(login string) ([]byte, error) {
+	defer observeDB("AdminUser", time.Now())
+	return s.strg.AdminUser(login)
+}
+
+func (s *Storage) AdminOrder(order string) ([]byte, error) {
+	defer observeDB("AdminOrder", time.Now())
+	return s.strg.AdminOrder(order)
+}
+
+func (s *Storage) OverrideOrderStatus(order, status string, accrual float32) error {
+	defer observeDB("OverrideOrderStatus", time.Now())
+	return s.strg.OverrideOrderStatus(order, status, accrual)
+}
+
+func (s *Storage) CreateAdjustment(adjustment storage.Adjustment) error {
+	defer observeDB("CreateAdjustment", time.Now())
+	return s.strg.CreateAdjustment(adjustment)
+}
+
+func (s *Storage) ApproveAdjustment(id, approver string) (storage.Adjustment, error) {
+	defer observeDB("ApproveAdjustment", time.Now())
+	return s.strg.ApproveAdjustment(id, approver)
+}
+
+func (s *Storage) RejectAdjustment(id, approver string) (storage.Adjustment, error) {
+	defer observeDB("RejectAdjustment", time.Now())
+	return s.strg.RejectAdjustment(id, approver)
+}
+
+func (s *Storage) Adjustments(status string) ([]byte, error) {
+	defer observeDB("Adjustments", time.Now())
+	return s.strg.Adjustments(status)
+}
+
+func (s *Storage) UserBalance(userID string) ([]byte, error) {
+	defer observeDB("UserBalance", time.Now())
+	return s.strg.UserBalance(userID)
+}
+
+func (s *Storage) UserOrders(userID string) ([]byte, error) {
+	defer observeDB("UserOrders", time.Now())
+	return s.strg.UserOrders(userID)
+}
+
+func (s *Storage) UserWithdrawals(userID string) ([]byte, error) {
+	defer observeDB("UserWithdrawals", time.Now())
+	return s.strg.UserWithdrawals(userID)
+}
+
+func (s *Storage) UserStatement(userID string, from, to time.Time, fn func(storage.StatementEntry) error) error {
+	defer observeDB("UserStatement", time.Now())
+	return s.strg.UserStatement(userID, from, to, fn)
+}
+
+func (s *Storage) GetProcessedOrders() ([]storage.ProcessedOrders, error) {
+	defer observeDB("GetProcessedOrders", time.Now())
+	return s.strg.GetProcessedOrders()
+}
+
+func (s *Storage) UpdateOrderStatus(result storage.AccuralResult) error {
+	defer observeDB("UpdateOrderStatus", time.Now())
+	return s.strg.UpdateOrderStatus(result)
+}
+
+func (s *Storage) ReconcileBalances(fix bool) ([]storage.BalanceDrift, error) {
+	defer observeDB("ReconcileBalances", time.Now())
+	return s.strg.ReconcileBalances(fix)
+}
+
+func (s *Storage) ExpirePoints(now time.Time) (int, error) {
+	defer observeDB("ExpirePoints", time.Now())
+	return s.strg.ExpirePoints(now)
+}
+
+func (s *Storage) TierPoints(basis string, since time.Time, fn func(userID, currentTier string, points int) error) error {
+	defer observeDB("TierPoints", time.Now())
+	return s.strg.TierPoints(basis, since, fn)
+}
+
+func (s *Storage) UserTier(userID, basis string, since time.Time) (storage.UserTier, error) {
+	defer observeDB("UserTier", time.Now())
+	return s.strg.UserTier(userID, basis, since)
+}
+
+func (s *Storage) SetUserTier(userID, tier string, multiplier float64) error {
+	defer observeDB("SetUserTier", time.Now())
+	return s.strg.SetUserTier(userID, tier, multiplier)
+}
+
+func (s *Storage) ReserveIdempotencyKey(userID, key, fingerprint string, expiredBefore time.Time) (*storage.IdempotentResponse, error) {
+	defer observeDB("ReserveIdempotencyKey", time.Now())
+	return s.strg.ReserveIdempotencyKey(userID, key, fingerprint, expiredBefore)
+}
+
+func (s *Storage) SaveIdempotentResponse(userID, key string, response storage.IdempotentResponse) error {
+	defer observeDB("SaveIdempotentResponse", time.Now())
+	return s.strg.SaveIdempotentResponse(userID, key, response)
+}
+
+func (s *Storage) ReleaseIdempotencyKey(userID, key string) error {
+	defer observeDB("ReleaseIdempotencyKey", time.Now())
+	return s.strg.ReleaseIdempotencyKey(userID, key)
+}
+
+func (s *Storage) IncrementRateCounter(bucket string, windowStart time.Time, window time.Duration) (int, error) {
+	defer observeDB("IncrementRateCounter", time.Now())
+	return s.strg.IncrementRateCounter(bucket, windowStart, window)
+}
+
+func (s *Storage) PruneIdempotencyKeys(expiredBefore time.Time) error {
+	defer observeDB("PruneIdempotencyKeys", time.Now())
+	return s.strg.PruneIdempotencyKeys(expiredBefore)
+}
+
+func (s *Storage) PruneRateCounters(expiredBefore time.Time) error {
+	defer observeDB("PruneRateCounters", time.Now())
+	return s.strg.PruneRateCounters(expiredBefore)
+}
+
+func (s *Storage) ReserveLoginAttempt(subject string, limits storage.LoginLimits) (storage.LoginFailures, error) {
+	defer observeDB("ReserveLoginAttempt", time.Now())
+	return s.strg.ReserveLoginAttempt(subject, limits)
+}
+
+func (s *Storage) ReleaseLoginAttempt(subject string) error {
+	defer observeDB("ReleaseLoginAttempt", time.Now())
+	return s.strg.ReleaseLoginAttempt(subject)
+}
+
+func (s *Storage) ResetLoginFailures(subject string) error {
+	defer observeDB("ResetLoginFailures", time.Now())
+	return s.strg.ResetLoginFailures(subject)
+}
+
+func (s *Storage) AddAuditEntry(entry storage.AuditEntry) error {
+	defer observeDB("AddAuditEntry", time.Now())
+	return s.strg.AddAuditEntry(entry)
+}
+
+func (s *Storage) AuditEntries(filter storage.AuditFilter) ([]byte, error) {
+	defer observeDB("AuditEntries", time.Now())
+	return s.strg.AuditEntries(filter)
+}
+
+func (s *Storage) SealAudit() (int, error) {
+	defer observeDB("SealAudit", time.Now())
+	return s.strg.SealAudit()
+}
+
+func (s *Storage) AddWebhook(webhook storage.Webhook) error {
+	defer observeDB("AddWebhook", time.Now())
+	return s.strg.AddWebhook(webhook)
+}
+
+func (s *Storage) DeleteWebhook(userID, webhookID string) error {
+	defer observeDB("DeleteWebhook", time.Now())
+	return s.strg.DeleteWebhook(userID, webhookID)
+}
+
+func (s *Storage) UserWebhooks(userID string) ([]byte, error) {
+	defer observeDB("UserWebhooks", time.Now())
+	return s.strg.UserWebhooks(userID)
+}
+
+func (s *Storage) WebhookDeliveries(userID, webhookID string) ([]byte, error) {
+	defer observeDB("WebhookDeliveries", time.Now())
+	return s.strg.WebhookDeliveries(userID, webhookID)
+}
+
+func (s *Storage) DispatchOutbox() (int, error) {
+	defer observeDB("DispatchOutbox", time.Now())
+	return s.strg.DispatchOutbox()
+}
+
+func (s *Storage) ClaimDeliveries(limit int, lease time.Duration) ([]storage.WebhookDelivery, error) {
+	defer observeDB("ClaimDeliveries", time.Now())
+	return s.strg.ClaimDeliveries(limit, lease)
+}
+
+func (s *Storage) RecordDeliveryAttempt(attempt storage.DeliveryAttempt, nextAttempt time.Time) error {
+	defer observeDB("RecordDeliveryAttempt", time.Now())
+	return s.strg.RecordDeliveryAttempt(attempt, nextAttempt)
+}
+
+func (s *Storage) PruneOutbox(dispatchedBefore time.Time) (int, error) {
+	defer observeDB("PruneOutbox", time.Now())
+	return s.strg.PruneOutbox(dispatchedBefore)
+}
+
+// ListenEvents holds its connection until ctx is done, so it is not timed.
+func (s *Storage) ListenEvents(ctx context.Context, fn func(userID string, id int64)) error {
+	return s.strg.ListenEvents(ctx, fn)
+}
+
+func (s *Storage) UserEvents(userID string, afterID int64, limit int) ([]storage.Event, error) {
+	defer observeDB("UserEvents", time.Now())
+	return s.strg.UserEvents(userID, afterID, limit)
+}
+
+func (s *Storage) LastEventID(userID string) (int64, error) {
+	defer observeDB("LastEventID", time.Now())
+	return s.strg.LastEventID(userID)
+}
+
+func (s *Storage) Stats() (storage.Stats, error) {
+	defer observeDB("Stats", time.Now())
+	return s.strg.Stats()
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	defer observeDB("Ping", time.Now())
+	return s.strg.Ping(ctx)
+}
+
+func (s *Storage) SchemaVersion() (int, error) {
+	defer observeDB("SchemaVersion", time.Now())
+	return s.strg.SchemaVersion()
+}
+
+func (s *Storage) CloseDB() {
+	s.strg.CloseDB()
+}
@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	windowStart time.Time
+	expires     time.Time
+	hits        int
+}
+
+// MemoryCounter keeps counters in process and is suitable for a single instance.
+type MemoryCounter struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+}
+
+func NewMemoryCounter() *MemoryCounter {
+	return &MemoryCounter{
+		entries:   make(map[string]*memoryEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *MemoryCounter) IncrementRateCounter(bucket string, windowStart time.Time, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, e := range m.entries {
+			if e.expires.Before(now) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	entry, ok := m.entries[bucket]
+	if !ok || !entry.windowStart.Equal(windowStart) {
+		entry = &memoryEntry{windowStart: windowStart, expires: windowStart.Add(window)}
+		m.entries[bucket] = entry
+	}
+	entry.hits++
+	return entry.hits, nil
+}
@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/config"
+	"gophermart/internal/realip"
+)
+
+type KeyBy int
+
+const (
+	ByIP KeyBy = iota
+	ByUser
+)
+
+type Policy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+	By     KeyBy
+}
+
+// Counter increments a fixed-window hit counter and returns the hits inside the window.
+type Counter interface {
+	IncrementRateCounter(bucket string, windowStart time.Time, window time.Duration) (int, error)
+}
+
+// Pruner is implemented by counters that keep expired windows until they are deleted.
+type Pruner interface {
+	PruneRateCounters(expiredBefore time.Time) error
+}
+
+// Authenticator checks the user ID sent by the client, per-user buckets are only used for known users.
+type Authenticator interface {
+	Authenticate(ctx context.Context, userID string) error
+}
+
+// pruneInterval is how often expired windows are deleted from a Pruner.
+const pruneInterval = time.Minute
+
+type Policies struct {
+	Auth   Policy
+	Orders Policy
+	API    Policy
+}
+
+type Limiter struct {
+	Policies  Policies
+	counter   Counter
+	users     Authenticator
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// ParsePolicy reads policies written as "<limit>/<window>", e.g. "10/1m". An empty string disables the policy.
+func ParsePolicy(name, value string, by KeyBy) (Policy, error) {
+	policy := Policy{Name: name, By: by}
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" {
+		return policy, nil
+	}
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return policy, fmt.Errorf("rate limit %s: expected <limit>/<window>, got %q", name, value)
+	}
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil || limit < 0 {
+		return policy, fmt.Errorf("rate limit %s: wrong limit %q", name, parts[0])
+	}
+	window, err := time.ParseDuration(parts[1])
+	if err != nil || window <= 0 {
+		return policy, fmt.Errorf("rate limit %s: wrong window %q", name, parts[1])
+	}
+	policy.Limit = limit
+	policy.Window = window
+	return policy, nil
+}
+
+// NewLimiter reads the policies of cfg. Without users every request is keyed by the client IP.
+func NewLimiter(cfg *config.Config, counter Counter, users Authenticator) (*Limiter, error) {
+	if counter == nil {
+		return nil, errors.New("rate limit counter not provided")
+	}
+	limiter := &Limiter{counter: counter, users: users, lastPrune: time.Now()}
+	var err error
+	if limiter.Policies.Auth, err = ParsePolicy("auth", cfg.RateLimitAuth, ByIP); err != nil {
+		return nil, err
+	}
+	if limiter.Policies.Orders, err = ParsePolicy("orders", cfg.RateLimitOrders, ByUser); err != nil {
+		return nil, err
+	}
+	if limiter.Policies.API, err = ParsePolicy("api", cfg.RateLimitAPI, ByIP); err != nil {
+		return nil, err
+	}
+	return limiter, nil
+}
+
+// Allow registers a hit for the key and reports how long the client has to wait when the limit is exceeded.
+func (l *Limiter) Allow(policy Policy, key string) (bool, time.Duration, error) {
+	if policy.Limit == 0 {
+		return true, 0, nil
+	}
+	now := time.Now()
+	l.prune(now)
+	windowStart := now.Truncate(policy.Window)
+	hits, err := l.counter.IncrementRateCounter(policy.Name+":"+key, windowStart, policy.Window)
+	if err != nil {
+		return false, 0, err
+	}
+	if hits > policy.Limit {
+		return false, windowStart.Add(policy.Window).Sub(now), nil
+	}
+	return true, 0, nil
+}
+
+// prune deletes expired windows of the counter at most once per pruneInterval.
+func (l *Limiter) prune(now time.Time) {
+	pruner, ok := l.counter.(Pruner)
+	if !ok {
+		return
+	}
+	l.mu.Lock()
+	if now.Sub(l.lastPrune) < pruneInterval {
+		l.mu.Unlock()
+		return
+	}
+	l.lastPrune = now
+	l.mu.Unlock()
+	if err := pruner.PruneRateCounters(now); err != nil {
+		log.Error().Err(err).Msg("ratelimit PruneRateCounters err")
+	}
+}
+
+// Key returns the bucket key of the client: the user for ByUser policies when the user is known,
+// the client IP otherwise. Unverified Authorization values must not get buckets of their own.
+func (l *Limiter) Key(ctx context.Context, policy Policy, userID, ip string) string {
+	if policy.By == ByUser && userID != "" && l.users != nil && l.users.Authenticate(ctx, userID) == nil {
+		return "user:" + userID
+	}
+	return "ip:" + ip
+}
+
+// Middleware rejects requests above the policy limit with 429 and Retry-After.
+func (l *Limiter) Middleware(policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if policy.Limit == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := l.Key(r.Context(), policy, r.Header.Get("Authorization"), realip.FromRequest(r))
+			allowed, retryAfter, err := l.Allow(policy, key)
+			if err != nil {
+				log.Error().Err(err).Msg("ratelimit Allow err")
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !allowed {
+				log.Debug().Msgf("ratelimit %s exceeded by %s", policy.Name, key)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, fmt.Sprintf("No more than %d requests per %s allowed", policy.Limit, policy.Window), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/config"
+	"gophermart/internal/realip"
+)
+
+func TestMiddleware(t *testing.T) {
+	cfg := &config.Config{RateLimitAuth: "2/1m", TrustedProxies: []string{"10.0.0.1"}}
+	limiter, err := NewLimiter(cfg, NewMemoryCounter(), nil)
+	require.NoError(t, err)
+	resolver, err := realip.NewResolver(cfg.TrustedProxies)
+	require.NoError(t, err)
+
+	handler := resolver.Middleware(limiter.Middleware(limiter.Policies.Auth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	send := func(remote, forwarded string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/api/user/login", nil)
+		request.RemoteAddr = remote
+		if forwarded != "" {
+			request.Header.Set("X-Forwarded-For", forwarded)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, send("192.0.2.1:5000", "").Code)
+	assert.Equal(t, http.StatusOK, send("192.0.2.1:5001", "").Code)
+	result := send("192.0.2.1:5002", "")
+	assert.Equal(t, http.StatusTooManyRequests, result.Code)
+	assert.NotEmpty(t, result.Header().Get("Retry-After"))
+
+	// Forwarded header is ignored for untrusted peers and followed for trusted ones.
+	assert.Equal(t, http.StatusTooManyRequests, send("192.0.2.1:5003", "198.51.100.7").Code)
+	assert.Equal(t, http.StatusOK, send("10.0.0.1:5000", "198.51.100.7").Code)
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("orders", "10/30s", ByUser)
+	require.NoError(t, err)
+	assert.Equal(t, 10, policy.Limit)
+	assert.Equal(t, "30s", policy.Window.String())
+
+	policy, err = ParsePolicy("orders", "", ByUser)
+	require.NoError(t, err)
+	assert.Equal(t, 0, policy.Limit)
+
+	_, err = ParsePolicy("orders", "ten/1m", ByUser)
+	assert.Error(t, err)
+}
+
+type knownUsers map[string]bool
+
+func (u knownUsers) Authenticate(ctx context.Context, userID string) error {
+	if !u[userID] {
+		return errors.New("unknown user")
+	}
+	return nil
+}
+
+func TestMiddlewareByUser(t *testing.T) {
+	cfg := &config.Config{RateLimitOrders: "1/1m"}
+	limiter, err := NewLimiter(cfg, NewMemoryCounter(), knownUsers{"alice": true})
+	require.NoError(t, err)
+
+	handler := limiter.Middleware(limiter.Policies.Orders)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(remote, user string) int {
+		request := httptest.NewRequest(http.MethodPost, "/api/user/orders", nil)
+		request.RemoteAddr = remote
+		request.Header.Set("Authorization", user)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send("192.0.2.1:5000", "alice"))
+	assert.Equal(t, http.StatusTooManyRequests, send("192.0.2.2:5000", "alice"))
+	// Made up users share the bucket of their IP instead of getting a fresh one per value.
+	assert.Equal(t, http.StatusOK, send("192.0.2.3:5000", "forged-1"))
+	assert.Equal(t, http.StatusTooManyRequests, send("192.0.2.3:5000", "forged-2"))
+}
+
+type pruningCounter struct {
+	*MemoryCounter
+	pruned []time.Time
+}
+
+func (c *pruningCounter) PruneRateCounters(expiredBefore time.Time) error {
+	c.pruned = append(c.pruned, expiredBefore)
+	return nil
+}
+
+func TestAllowPrunes(t *testing.T) {
+	counter := &pruningCounter{MemoryCounter: NewMemoryCounter()}
+	limiter, err := NewLimiter(&config.Config{RateLimitAPI: "10/1m"}, counter, nil)
+	require.NoError(t, err)
+
+	_, _, err = limiter.Allow(limiter.Policies.API, "ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.Empty(t, counter.pruned)
+
+	limiter.lastPrune = time.Now().Add(-2 * pruneInterval)
+	_, _, err = limiter.Allow(limiter.Policies.API, "ip:192.0.2.1")
+	require.NoError(t, err)
+	_, _, err = limiter.Allow(limiter.Policies.API, "ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.Len(t, counter.pruned, 1)
+}
@@ -0,0 +1,55 @@
+package rbac
+
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+type Permission string
+
+const (
+	UsersRead          Permission = "users.read"
+	UsersManage        Permission = "users.manage"
+	OrdersRead         Permission = "orders.read"
+	OrdersWrite        Permission = "orders.write"
+	BalancesRead       Permission = "balances.read"
+	WithdrawalsReverse Permission = "withdrawals.reverse"
+	PromoWrite         Permission = "promo.write"
+	AdjustmentsRead    Permission = "adjustments.read"
+	AdjustmentsCreate  Permission = "adjustments.create"
+	AdjustmentsApprove Permission = "adjustments.approve"
+	AuditRead          Permission = "audit.read"
+)
+
+// permissions lists what each role may do, a plain user has no admin permissions.
+var permissions = map[string]map[Permission]bool{
+	RoleSupport: {
+		UsersRead:         true,
+		OrdersRead:        true,
+		BalancesRead:      true,
+		AdjustmentsRead:   true,
+		AdjustmentsCreate: true,
+	},
+	RoleAdmin: {
+		UsersRead:          true,
+		UsersManage:        true,
+		OrdersRead:         true,
+		OrdersWrite:        true,
+		BalancesRead:       true,
+		WithdrawalsReverse: true,
+		PromoWrite:         true,
+		AdjustmentsRead:    true,
+		AdjustmentsCreate:  true,
+		AdjustmentsApprove: true,
+		AuditRead:          true,
+	},
+}
+
+func Allowed(role string, permission Permission) bool {
+	return permissions[role][permission]
+}
+
+func Valid(role string) bool {
+	return role == RoleUser || permissions[role] != nil
+}
@@ -0,0 +1,25 @@
+package rbac
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowed(t *testing.T) {
+	assert.True(t, Allowed(RoleAdmin, OrdersWrite))
+	assert.True(t, Allowed(RoleSupport, OrdersRead))
+	assert.False(t, Allowed(RoleSupport, OrdersWrite))
+	assert.True(t, Allowed(RoleSupport, AdjustmentsRead))
+	assert.True(t, Allowed(RoleSupport, AdjustmentsCreate))
+	assert.False(t, Allowed(RoleSupport, AdjustmentsApprove))
+	assert.False(t, Allowed(RoleUser, UsersRead))
+	assert.False(t, Allowed("", UsersRead))
+}
+
+func TestValid(t *testing.T) {
+	assert.True(t, Valid(RoleUser))
+	assert.True(t, Valid(RoleSupport))
+	assert.True(t, Valid(RoleAdmin))
+	assert.False(t, Valid("root"))
+}
@@ -0,0 +1,80 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/metrics"
+	"gophermart/internal/storage"
+)
+
+// Reconciler periodically recomputes balances from the ledger and flags cached balances that drifted.
+type Reconciler struct {
+	interval time.Duration
+	fix      bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+func NewReconciler(interval time.Duration, fix bool) *Reconciler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Reconciler{
+		interval: interval,
+		fix:      fix,
+		ctx:      ctx,
+		cancel:   cancel,
+		finished: make(chan struct{}),
+	}
+}
+
+func (rc *Reconciler) Run(strg storage.Storager) {
+	go func() {
+		log.Debug().Msg("Reconciler started")
+		metrics.WorkerStarted("reconciler")
+		ticker := time.NewTicker(rc.interval)
+		defer ticker.Stop()
+	loop:
+		for {
+			rc.reconcile(strg)
+			metrics.WorkerRan("reconciler")
+			select {
+			case <-rc.ctx.Done():
+				break loop
+			case <-ticker.C:
+			}
+		}
+		metrics.WorkerStopped("reconciler")
+		close(rc.finished)
+		log.Debug().Msg("Reconciler finished")
+	}()
+}
+
+func (rc *Reconciler) reconcile(strg storage.Storager) {
+	drifts, err := strg.ReconcileBalances(rc.fix)
+	if err != nil {
+		log.Error().Err(err).Msg("Reconciler ReconcileBalances error")
+	}
+	for _, drift := range drifts {
+		log.Warn().Msgf("balance drift for user %s: cached %d/%d, ledger %d/%d", drift.UserID,
+			drift.CachedBalance, drift.CachedWithdrawn, drift.LedgerBalance, drift.LedgerWithdrawn)
+		entry := storage.AuditEntry{
+			Actor:  "system",
+			Action: "ledger.drift",
+			Target: drift.UserID,
+			Details: fmt.Sprintf("cached balance %d withdrawn %d, ledger balance %d withdrawn %d, fixed %t",
+				drift.CachedBalance, drift.CachedWithdrawn, drift.LedgerBalance, drift.LedgerWithdrawn, rc.fix),
+		}
+		if err = strg.AddAuditEntry(entry); err != nil {
+			log.Error().Err(err).Msg("Reconciler AddAuditEntry error")
+		}
+	}
+}
+
+func (rc *Reconciler) Stop() {
+	rc.cancel()
+	<-rc.finished
+}
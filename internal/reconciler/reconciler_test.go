@@ -0,0 +1,67 @@
+package reconciler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/storage"
+)
+
+type fakeStorage struct {
+	storage.Storager
+	drifts []storage.BalanceDrift
+	err    error
+	fixed  []bool
+	audit  []storage.AuditEntry
+}
+
+func (s *fakeStorage) ReconcileBalances(fix bool) ([]storage.BalanceDrift, error) {
+	s.fixed = append(s.fixed, fix)
+	return s.drifts, s.err
+}
+
+func (s *fakeStorage) AddAuditEntry(entry storage.AuditEntry) error {
+	s.audit = append(s.audit, entry)
+	return nil
+}
+
+func TestReconcileAuditsDrifts(t *testing.T) {
+	strg := &fakeStorage{drifts: []storage.BalanceDrift{
+		{UserID: "alice", CachedBalance: 1000, LedgerBalance: 900},
+		{UserID: "bob", CachedWithdrawn: 50, LedgerWithdrawn: 0},
+	}}
+	rc := NewReconciler(time.Hour, true)
+	rc.reconcile(strg)
+
+	assert.Equal(t, []bool{true}, strg.fixed)
+	require.Len(t, strg.audit, 2)
+	assert.Equal(t, "ledger.drift", strg.audit[0].Action)
+	assert.Equal(t, "alice", strg.audit[0].Target)
+	assert.Contains(t, strg.audit[0].Details, "cached balance 1000 withdrawn 0, ledger balance 900 withdrawn 0, fixed true")
+	assert.Equal(t, "bob", strg.audit[1].Target)
+}
+
+func TestReconcileWithoutDrift(t *testing.T) {
+	strg := &fakeStorage{}
+	rc := NewReconciler(time.Hour, false)
+	rc.reconcile(strg)
+	assert.Equal(t, []bool{false}, strg.fixed)
+	assert.Empty(t, strg.audit)
+
+	// Drifts found before a failure are still reported.
+	strg = &fakeStorage{drifts: []storage.BalanceDrift{{UserID: "alice"}}, err: errors.New("rebuild failed")}
+	rc.reconcile(strg)
+	assert.Len(t, strg.audit, 1)
+}
+
+func TestRunStops(t *testing.T) {
+	strg := &fakeStorage{}
+	rc := NewReconciler(time.Hour, false)
+	rc.Run(strg)
+	rc.Stop()
+	assert.NotEmpty(t, strg.fixed)
+}
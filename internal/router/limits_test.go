@@ -0,0 +1,108 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/config"
+	"gophermart/internal/events"
+	"gophermart/internal/handlers"
+	"gophermart/internal/health"
+	"gophermart/internal/ratelimit"
+	"gophermart/internal/realip"
+	"gophermart/internal/service"
+	"gophermart/internal/validation"
+)
+
+func newLimitsRouter(t *testing.T, rateLimitAPI string) http.Handler {
+	cnfg := &config.Config{
+		LoginMinLength:     3,
+		LoginMaxLength:     64,
+		LoginCharset:       "^[!-~]+$",
+		PasswordMinLength:  6,
+		PasswordMaxLength:  128,
+		OrderMinLength:     2,
+		OrderMaxLength:     32,
+		MaxBodyCredentials: 64,
+		MaxBodyOrder:       16,
+		MaxBodyWithdraw:    64,
+		RateLimitAPI:       rateLimitAPI,
+	}
+	validator, err := validation.NewValidator(cnfg)
+	require.NoError(t, err)
+	limiter, err := ratelimit.NewLimiter(cnfg, ratelimit.NewMemoryCounter(), nil)
+	require.NoError(t, err)
+	resolver, err := realip.NewResolver(nil)
+	require.NoError(t, err)
+	// Storage is never reached: every request below is rejected before the handler touches it.
+	hndlr := handlers.NewHandler(cnfg, nil, service.New(cnfg, nil, validator), nil, events.NewBus())
+	return NewRouter(cnfg, hndlr, resolver, limiter, health.NewChecker(nil, nil, 0))
+}
+
+func TestBodyLimits(t *testing.T) {
+	router := newLimitsRouter(t, "")
+	long := strings.Repeat("1", 200)
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		chunked     bool
+		want        int
+	}{
+		{name: "register without content type", path: "/api/user/register", body: `{"login":"a","password":"b"}`, want: http.StatusUnsupportedMediaType},
+		{name: "register text body", path: "/api/user/register", contentType: "text/plain", body: `{"login":"a","password":"b"}`, want: http.StatusUnsupportedMediaType},
+		{name: "register too large", path: "/api/user/register", contentType: "application/json", body: `{"login":"` + long + `"}`, want: http.StatusBadRequest},
+		{name: "register chunked too large", path: "/api/user/register", contentType: "application/json", body: `{"login":"` + long + `"}`, chunked: true, want: http.StatusBadRequest},
+		{name: "register broken json", path: "/api/user/register", contentType: "application/json; charset=utf-8", body: `{"login":`, want: http.StatusBadRequest},
+		{name: "login json body", path: "/api/user/login", contentType: "text/plain", body: `{"login":"a","password":"b"}`, want: http.StatusUnsupportedMediaType},
+		{name: "login too large", path: "/api/user/login", contentType: "application/json", body: `{"login":"` + long + `"}`, want: http.StatusBadRequest},
+		{name: "orders json body", path: "/api/user/orders", contentType: "application/json", body: `"12345678903"`, want: http.StatusUnsupportedMediaType},
+		{name: "orders too large", path: "/api/user/orders", contentType: "text/plain", body: long, want: http.StatusBadRequest},
+		{name: "withdraw text body", path: "/api/user/balance/withdraw", contentType: "text/plain", body: `{"order":"12345678903","sum":1}`, want: http.StatusUnsupportedMediaType},
+		{name: "withdraw too large", path: "/api/user/balance/withdraw", contentType: "application/json", body: `{"order":"` + long + `"}`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader = strings.NewReader(tt.body)
+			if tt.chunked {
+				body = io.MultiReader(body)
+			}
+			request := httptest.NewRequest(http.MethodPost, tt.path, body)
+			if tt.chunked {
+				request.ContentLength = -1
+			}
+			if tt.contentType != "" {
+				request.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, request)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestProbesSkipAPILimit(t *testing.T) {
+	router := newLimitsRouter(t, "1/1m")
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	want := []int{http.StatusUnsupportedMediaType, http.StatusTooManyRequests}
+	for _, code := range want {
+		request := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader(`"12345678903"`))
+		request.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		assert.Equal(t, code, w.Code)
+	}
+}
@@ -4,23 +4,87 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"gophermart/internal/bodylimit"
+	"gophermart/internal/config"
 	"gophermart/internal/handlers"
+	"gophermart/internal/health"
+	"gophermart/internal/metrics"
+	"gophermart/internal/ratelimit"
+	"gophermart/internal/rbac"
+	"gophermart/internal/realip"
+	"gophermart/internal/tracing"
 )
 
-func NewRouter(handler *handlers.Handler) *chi.Mux {
+const (
+	contentJSON = "application/json"
+	contentText = "text/plain"
+)
+
+func NewRouter(cfg *config.Config, handler *handlers.Handler, resolver *realip.Resolver, limiter *ratelimit.Limiter, checker *health.Checker) *chi.Mux {
 	router := chi.NewRouter()
 
+	router.Use(middleware.RequestID)
+	router.Use(metrics.Middleware)
+	router.Use(tracing.Middleware)
 	router.Use(middleware.Logger)
 	router.Use(middleware.Recoverer)
+	router.Use(resolver.Middleware)
+
+	// The probes are mounted ahead of the API limiter, so a busy instance is not reported as down.
+	router.Get("/healthz", checker.Healthz)
+	router.Get("/readyz", checker.Readyz)
+
+	api := router.With(limiter.Middleware(limiter.Policies.API))
+
+	credentials := bodylimit.Rule{MaxBytes: cfg.MaxBodyCredentials, ContentTypes: []string{contentJSON}}
+	order := bodylimit.Rule{MaxBytes: cfg.MaxBodyOrder, ContentTypes: []string{contentText}}
+	withdraw := bodylimit.Rule{MaxBytes: cfg.MaxBodyWithdraw, ContentTypes: []string{contentJSON}}
+	ordersBatch := bodylimit.Rule{MaxBytes: cfg.MaxBodyOrdersBatch, ContentTypes: []string{contentJSON, contentText}}
+
+	api.Group(func(r chi.Router) {
+		r.Use(limiter.Middleware(limiter.Policies.Auth))
+		r.Use(bodylimit.Enforce(credentials))
+		r.Post("/api/user/register", handler.Registration)
+		r.Post("/api/user/login", handler.LogIn)
+	})
+	api.With(limiter.Middleware(limiter.Policies.Orders), bodylimit.Enforce(order), handler.Idempotency).Post("/api/user/orders", handler.Orders)
+	api.With(limiter.Middleware(limiter.Policies.Orders), bodylimit.Enforce(ordersBatch), handler.Idempotency).Post("/api/user/orders/batch", handler.OrdersBatch)
+	api.With(bodylimit.Enforce(withdraw), handler.Idempotency).Post("/api/user/balance/withdraw", handler.Withdraw)
+
+	api.Post("/api/user/balance/withdrawals/{order}/cancel", handler.CancelWithdrawal)
+	api.With(bodylimit.Enforce(withdraw), handler.Idempotency).Post("/api/user/balance/holds", handler.CreateHold)
+	api.With(handler.Idempotency).Post("/api/user/balance/holds/{hold}/capture", handler.CaptureHold)
+	api.Post("/api/user/balance/holds/{hold}/void", handler.VoidHold)
+	api.With(bodylimit.Enforce(withdraw), handler.Idempotency).Post("/api/user/balance/transfer", handler.Transfer)
+	api.With(limiter.Middleware(limiter.Policies.Auth), bodylimit.Enforce(withdraw)).Post("/api/user/promo", handler.RedeemPromo)
+	api.With(bodylimit.Enforce(withdraw)).Post("/api/user/webhooks", handler.AddWebhook)
+	api.Delete("/api/user/webhooks/{webhook}", handler.DeleteWebhook)
 
-	router.Post("/api/user/register", handler.Registration)
-	router.Post("/api/user/login", handler.LogIn)
-	router.Post("/api/user/orders", handler.Orders)
-	router.Post("/api/user/balance/withdraw", handler.Withdraw)
+	api.Route("/api/admin", func(r chi.Router) {
+		r.With(handler.RequirePermission(rbac.UsersRead)).Get("/users/{login}", handler.AdminUser)
+		r.With(handler.RequirePermission(rbac.BalancesRead)).Get("/users/{login}/balance", handler.AdminUserBalance)
+		r.With(handler.RequirePermission(rbac.UsersManage), bodylimit.Enforce(withdraw)).Put("/users/{login}/role", handler.AdminSetRole)
+		r.With(handler.RequirePermission(rbac.OrdersRead)).Get("/orders/{order}", handler.AdminOrder)
+		r.With(handler.RequirePermission(rbac.OrdersWrite), bodylimit.Enforce(withdraw)).Post("/orders/{order}/status", handler.AdminOrderStatus)
+		r.With(handler.RequirePermission(rbac.WithdrawalsReverse)).Post("/withdrawals/{order}/reverse", handler.AdminReverseWithdrawal)
+		r.With(handler.RequirePermission(rbac.PromoWrite), bodylimit.Enforce(withdraw)).Post("/promo", handler.AdminCreatePromo)
+		r.With(handler.RequirePermission(rbac.AuditRead)).Get("/audit", handler.AdminAudit)
+		r.With(handler.RequirePermission(rbac.AdjustmentsRead)).Get("/adjustments", handler.AdminAdjustments)
+		r.With(handler.RequirePermission(rbac.AdjustmentsCreate), bodylimit.Enforce(withdraw)).Post("/adjustments", handler.AdminCreateAdjustment)
+		r.With(handler.RequirePermission(rbac.AdjustmentsApprove)).Post("/adjustments/{adjustment}/approve", handler.AdminApproveAdjustment)
+		r.With(handler.RequirePermission(rbac.AdjustmentsApprove)).Post("/adjustments/{adjustment}/reject", handler.AdminRejectAdjustment)
+	})
 
-	router.Get("/api/user/balance", handler.Balance)
-	router.Get("/api/user/orders", handler.OrdersHistory)
-	router.Get("/api/user/withdrawals", handler.WithdrawHistory)
+	api.Get("/api/user/balance", handler.Balance)
+	api.Get("/api/user/orders", handler.OrdersHistory)
+	api.Get("/api/user/withdrawals", handler.WithdrawHistory)
+	api.Get("/api/user/statement", handler.Statement)
+	api.Get("/api/user/tier", handler.Tier)
+	api.Get("/api/user/transfers", handler.TransferHistory)
+	api.Get("/api/user/referrals", handler.Referrals)
+	api.Get("/api/user/events", handler.Events)
+	api.Get("/api/user/webhooks", handler.Webhooks)
+	api.Get("/api/user/webhooks/{webhook}/deliveries", handler.WebhookDeliveries)
 
 	return router
 }
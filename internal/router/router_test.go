@@ -14,9 +14,16 @@ import (
 
 	"gophermart/internal/accrualreader"
 	"gophermart/internal/config"
+	"gophermart/internal/events"
 	"gophermart/internal/handlers"
+	"gophermart/internal/health"
 	"gophermart/internal/logger"
+	"gophermart/internal/ratelimit"
+	"gophermart/internal/realip"
+	"gophermart/internal/service"
 	"gophermart/internal/storage"
+	"gophermart/internal/tier"
+	"gophermart/internal/validation"
 )
 
 type username struct {
@@ -36,10 +43,18 @@ func TestRouter(t *testing.T) {
 	require.NoError(t, err)
 	strg := storage.NewStorage(cnfg)
 	log.Debug().Msg("storage init")
-	accrual := accrualreader.NewAccrualReader(cnfg.AccuralSystemAddress)
+	accrual := accrualreader.NewAccrualReader(cnfg)
 	accrual.Run(strg)
-	hndlr := handlers.NewHandler(cnfg, strg)
-	router := NewRouter(hndlr)
+	validator, err := validation.NewValidator(cnfg)
+	require.NoError(t, err)
+	tiers, err := tier.Parse(cnfg.Tiers)
+	require.NoError(t, err)
+	hndlr := handlers.NewHandler(cnfg, strg, service.New(cnfg, strg, validator), tiers, events.NewBus())
+	limiter, err := ratelimit.NewLimiter(cnfg, ratelimit.NewMemoryCounter(), nil)
+	require.NoError(t, err)
+	resolver, err := realip.NewResolver(cnfg.TrustedProxies)
+	require.NoError(t, err)
+	router := NewRouter(cnfg, hndlr, resolver, limiter, health.NewChecker(strg, accrual, cnfg.ReadyHeartbeatAge))
 	log.Debug().Msg("handler init")
 
 	l, err := net.Listen("tcp", cnfg.RunAddress)
@@ -0,0 +1,62 @@
+package sealer
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/metrics"
+	"gophermart/internal/storage"
+)
+
+// Sealer periodically chains the audit entries, which are written unsealed.
+type Sealer struct {
+	interval time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+func NewSealer(interval time.Duration) *Sealer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Sealer{
+		interval: interval,
+		ctx:      ctx,
+		cancel:   cancel,
+		finished: make(chan struct{}),
+	}
+}
+
+func (s *Sealer) Run(strg storage.Storager) {
+	go func() {
+		log.Debug().Msg("Sealer started")
+		metrics.WorkerStarted("sealer")
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+	loop:
+		for {
+			sealed, err := strg.SealAudit()
+			if err != nil {
+				log.Error().Err(err).Msg("Sealer SealAudit error")
+			}
+			if sealed != 0 {
+				log.Debug().Msgf("Sealer sealed %d audit entries", sealed)
+			}
+			metrics.WorkerRan("sealer")
+			select {
+			case <-s.ctx.Done():
+				break loop
+			case <-ticker.C:
+			}
+		}
+		metrics.WorkerStopped("sealer")
+		close(s.finished)
+		log.Debug().Msg("Sealer finished")
+	}()
+}
+
+func (s *Sealer) Stop() {
+	s.cancel()
+	<-s.finished
+}
@@ -0,0 +1,49 @@
+package sealer
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gophermart/internal/storage"
+)
+
+type fakeStorage struct {
+	storage.Storager
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *fakeStorage) SealAudit() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.calls == 1 {
+		return 0, errors.New("chain locked")
+	}
+	return 3, nil
+}
+
+func TestSealer(t *testing.T) {
+	strg := &fakeStorage{}
+	s := NewSealer(10 * time.Millisecond)
+	s.Run(strg)
+	// A failed run does not stop the sealer.
+	assert.Eventually(t, func() bool {
+		strg.mu.Lock()
+		defer strg.mu.Unlock()
+		return strg.calls >= 3
+	}, time.Second, 5*time.Millisecond)
+	s.Stop()
+
+	strg.mu.Lock()
+	calls := strg.calls
+	strg.mu.Unlock()
+	time.Sleep(30 * time.Millisecond)
+	strg.mu.Lock()
+	defer strg.mu.Unlock()
+	assert.Equal(t, calls, strg.calls, "no runs after Stop")
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gophermart/internal/config"
+	"gophermart/internal/storage"
+	"gophermart/internal/validation"
+)
+
+var (
+	ErrInvalidSum      = errors.New("sum must be positive")
+	ErrInvalidTTL      = errors.New("ttl must be a positive duration")
+	ErrInvalidTransfer = errors.New("login and positive sum are required")
+	ErrCommentTooLong  = errors.New("comment is too long")
+	ErrSelfTransfer    = errors.New("can not transfer to yourself")
+	ErrUnknownReceiver = errors.New("recipient not found")
+)
+
+const maxTransferComment = 200
+
+type BalanceService struct {
+	cfg       *config.Config
+	strg      storage.Storager
+	validator *validation.Validator
+}
+
+func NewBalanceService(cfg *config.Config, strg storage.Storager, validator *validation.Validator) *BalanceService {
+	return &BalanceService{cfg: cfg, strg: strg, validator: validator}
+}
+
+type Hold struct {
+	ID        string  `json:"id"`
+	Order     string  `json:"order"`
+	Sum       float32 `json:"sum"`
+	Status    string  `json:"status"`
+	ExpiresAt string  `json:"expires_at"`
+}
+
+// Balance returns the user's balance as JSON.
+func (s *BalanceService) Balance(ctx context.Context, userID string) ([]byte, error) {
+	return storage.WithContext(ctx, s.strg).UserBalance(userID)
+}
+
+// Withdraw spends sum points on the order. A wrong order number returns a validation error,
+// a non-positive sum ErrInvalidSum and a short balance storage.ErrNotEnouthBalance.
+func (s *BalanceService) Withdraw(ctx context.Context, userID, number string, sum float32) error {
+	order, err := s.validator.Order(number)
+	if err != nil {
+		return err
+	}
+	if sum <= 0 {
+		return ErrInvalidSum
+	}
+	if err = storage.WithContext(ctx, s.strg).UserWithdraw(userID, order, sum); err != nil {
+		return err
+	}
+	audit(ctx, s.strg, storage.AuditEntry{Actor: "user:" + userID, Action: "balance.withdraw", Target: order, Details: fmt.Sprintf("sum %.2f", sum)})
+	return nil
+}
+
+// Withdrawals returns the user's withdrawals as JSON.
+func (s *BalanceService) Withdrawals(ctx context.Context, userID string) ([]byte, error) {
+	return storage.WithContext(ctx, s.strg).UserWithdrawals(userID)
+}
+
+// CreateHold reserves sum points for the order for ttl, the configured default when ttl is empty.
+// Besides the Withdraw errors it returns ErrInvalidTTL and storage.ErrConflict when the order is
+// already paid or held.
+func (s *BalanceService) CreateHold(ctx context.Context, userID, number string, sum float32, ttl string) (Hold, error) {
+	order, err := s.validator.Order(number)
+	if err != nil {
+		return Hold{}, err
+	}
+	if sum <= 0 {
+		return Hold{}, ErrInvalidSum
+	}
+	holdTTL := s.cfg.HoldTTL
+	if ttl != "" {
+		holdTTL, err = time.ParseDuration(ttl)
+		if err != nil || holdTTL <= 0 || holdTTL > s.cfg.HoldMaxTTL {
+			return Hold{}, fmt.Errorf("%w not longer than %s", ErrInvalidTTL, s.cfg.HoldMaxTTL)
+		}
+	}
+
+	hold := Hold{ID: NewID(16), Order: order, Sum: sum, Status: storage.HoldActive}
+	expiresAt := time.Now().Add(holdTTL)
+	if err = storage.WithContext(ctx, s.strg).CreateHold(userID, hold.ID, order, sum, expiresAt); err != nil {
+		return Hold{}, err
+	}
+	hold.ExpiresAt = expiresAt.Format(time.RFC3339)
+	return hold, nil
+}
+
+// CaptureHold turns the hold into a withdrawal; it fails the same way as VoidHold and with
+// storage.ErrNotEnouthBalance when the balance dropped below the hold.
+func (s *BalanceService) CaptureHold(ctx context.Context, userID, holdID string) error {
+	return storage.WithContext(ctx, s.strg).CaptureHold(userID, holdID)
+}
+
+// VoidHold releases the held points. Both settlements return storage.ErrNotFound for an unknown hold,
+// storage.ErrGone for an expired one and storage.ErrConflict for a settled one.
+func (s *BalanceService) VoidHold(ctx context.Context, userID, holdID string) error {
+	return storage.WithContext(ctx, s.strg).VoidHold(userID, holdID)
+}
+
+// Transfer moves sum points to the user with the login within the configured daily limits.
+// Besides the input errors it returns ErrUnknownReceiver, ErrSelfTransfer,
+// storage.ErrNotEnouthBalance and storage.ErrLimitExceeded.
+func (s *BalanceService) Transfer(ctx context.Context, userID, login string, sum float32, comment string) error {
+	login = strings.TrimSpace(login)
+	if login == "" || sum <= 0 {
+		return ErrInvalidTransfer
+	}
+	if utf8.RuneCountInString(comment) > maxTransferComment {
+		return ErrCommentTooLong
+	}
+	err := storage.WithContext(ctx, s.strg).TransferPoints(userID, NewID(16), login, sum, comment, int(s.cfg.TransferDailyLimit*100), s.cfg.TransferDailyCount)
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrUnknownReceiver
+	}
+	if errors.Is(err, storage.ErrConflict) {
+		return ErrSelfTransfer
+	}
+	return err
+}
+
+// Transfers returns the user's transfers as JSON, storage.ErrNoContent when there are none.
+func (s *BalanceService) Transfers(ctx context.Context, userID string) ([]byte, error) {
+	return storage.WithContext(ctx, s.strg).UserTransfers(userID)
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/storage"
+)
+
+// ThrottledError is returned while login attempts are refused: Locked after too many failures,
+// otherwise until the progressive delay after the last failure is over.
+type ThrottledError struct {
+	Locked bool
+	Wait   time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("locked out, retry in %s", e.Wait.Round(time.Second))
+	}
+	return fmt.Sprintf("too many attempts, retry in %s", e.Wait.Round(time.Second))
+}
+
+// loginSubject is a throttled subject of a login attempt.
+type loginSubject struct {
+	name   string
+	limits storage.LoginLimits
+}
+
+// loginSubjects returns the client IP and the login with their limits, those without one are left out.
+func (s *UserService) loginSubjects(login, ip string) []loginSubject {
+	subjects := []loginSubject{
+		{name: "ip:" + ip, limits: storage.LoginLimits{MaxFailures: s.cfg.LoginMaxIPFailures, Lockout: s.cfg.LoginLockout}},
+		{name: "login:" + login, limits: storage.LoginLimits{MaxFailures: s.cfg.LoginMaxFailures, Lockout: s.cfg.LoginLockout, Delay: s.loginDelay}},
+	}
+	limited := subjects[:0]
+	for _, subject := range subjects {
+		if subject.limits.MaxFailures > 0 {
+			limited = append(limited, subject)
+		}
+	}
+	return limited
+}
+
+// loginDelay is the wait after the last failure, doubling with every failure past the free ones.
+func (s *UserService) loginDelay(failures int) time.Duration {
+	if failures < s.cfg.LoginFreeFailures {
+		return 0
+	}
+	exp := failures - s.cfg.LoginFreeFailures
+	if exp > 16 {
+		exp = 16
+	}
+	delay := s.cfg.LoginDelayBase << exp
+	if delay > s.cfg.LoginDelayMax {
+		delay = s.cfg.LoginDelayMax
+	}
+	return delay
+}
+
+// reserveLoginAttempt counts the attempt against every subject before the password is checked,
+// so concurrent guesses are throttled as if they came one after the other. It returns the
+// subjects to release when the password turns out right.
+func (s *UserService) reserveLoginAttempt(ctx context.Context, login, ip string) ([]loginSubject, error) {
+	var reserved []loginSubject
+	for _, subject := range s.loginSubjects(login, ip) {
+		now := time.Now()
+		failures, err := storage.WithContext(ctx, s.strg).ReserveLoginAttempt(subject.name, subject.limits)
+		if err == nil {
+			reserved = append(reserved, subject)
+			continue
+		}
+		// The attempt is refused, so it is no guess against the subjects already counted.
+		s.releaseLoginAttempt(ctx, reserved)
+		switch {
+		case errors.Is(err, storage.ErrLocked):
+			if failures.JustLocked {
+				log.Info().Msgf("login lockout for %s until %s", subject.name, failures.LockedUntil.Format(time.RFC3339))
+				audit(ctx, s.strg, storage.AuditEntry{Actor: "system", Action: "login.lockout", Target: subject.name, Details: "locked until " + failures.LockedUntil.Format(time.RFC3339)})
+			}
+			return nil, &ThrottledError{Locked: true, Wait: failures.LockedUntil.Sub(now)}
+		case errors.Is(err, storage.ErrLoginDelayed):
+			return nil, &ThrottledError{Wait: failures.LastFailure.Add(subject.limits.Delay(failures.Failures)).Sub(now)}
+		}
+		return nil, fmt.Errorf("ReserveLoginAttempt: %w", err)
+	}
+	return reserved, nil
+}
+
+func (s *UserService) releaseLoginAttempt(ctx context.Context, subjects []loginSubject) {
+	for _, subject := range subjects {
+		if err := storage.WithContext(ctx, s.strg).ReleaseLoginAttempt(subject.name); err != nil {
+			log.Error().Err(err).Msg("releaseLoginAttempt ReleaseLoginAttempt err")
+		}
+	}
+}
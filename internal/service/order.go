@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gophermart/internal/storage"
+	"gophermart/internal/validation"
+)
+
+type OrderService struct {
+	strg      storage.Storager
+	validator *validation.Validator
+	maxBatch  int
+}
+
+func NewOrderService(strg storage.Storager, validator *validation.Validator, maxBatch int) *OrderService {
+	return &OrderService{strg: strg, validator: validator, maxBatch: maxBatch}
+}
+
+// Upload registers the order number for accrual. A malformed number returns validation.ErrMalformed,
+// a wrong one validation.ErrInvalid; numbers uploaded before return storage.ErrUploaded or
+// storage.ErrAnotherUserUploaded.
+func (s *OrderService) Upload(ctx context.Context, userID, number string) error {
+	order, err := s.validator.Order(number)
+	if err != nil {
+		return err
+	}
+	if err = storage.WithContext(ctx, s.strg).AddNewOrder(userID, order); err != nil {
+		return err
+	}
+	audit(ctx, s.strg, storage.AuditEntry{Actor: "user:" + userID, Action: "order.upload", Target: order})
+	return nil
+}
+
+// Orders returns the user's orders as JSON, storage.ErrNoContent when there are none.
+func (s *OrderService) Orders(ctx context.Context, userID string) ([]byte, error) {
+	return storage.WithContext(ctx, s.strg).UserOrders(userID)
+}
+
+var (
+	ErrEmptyBatch    = errors.New("no orders in batch")
+	ErrBatchTooLarge = errors.New("too many orders in batch")
+)
+
+const (
+	BatchAccepted = "accepted"
+	BatchUploaded = "already_uploaded"
+	BatchConflict = "conflict"
+	BatchInvalid  = "invalid"
+)
+
+type BatchResult struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// UploadBatch registers the valid numbers in one go and returns a result per number in the
+// given order along with how many were accepted.
+func (s *OrderService) UploadBatch(ctx context.Context, userID string, numbers []string) ([]BatchResult, int, error) {
+	if len(numbers) == 0 {
+		return nil, 0, ErrEmptyBatch
+	}
+	if len(numbers) > s.maxBatch {
+		return nil, 0, fmt.Errorf("%w: no more than %d allowed", ErrBatchTooLarge, s.maxBatch)
+	}
+
+	results := make([]BatchResult, len(numbers))
+	valid := make([]string, 0, len(numbers))
+	validIdx := make([]int, 0, len(numbers))
+	for i, number := range numbers {
+		order, err := s.validator.Order(number)
+		if err != nil {
+			results[i] = BatchResult{Number: number, Result: BatchInvalid, Reason: err.Error()}
+			continue
+		}
+		results[i] = BatchResult{Number: order, Result: BatchAccepted}
+		valid = append(valid, order)
+		validIdx = append(validIdx, i)
+	}
+	if len(valid) == 0 {
+		return results, 0, nil
+	}
+
+	uploadErrs, err := storage.WithContext(ctx, s.strg).AddNewOrders(userID, valid)
+	if err != nil {
+		return nil, 0, fmt.Errorf("AddNewOrders: %w", err)
+	}
+	accepted := 0
+	for j, uploadErr := range uploadErrs {
+		i := validIdx[j]
+		switch {
+		case errors.Is(uploadErr, storage.ErrUploaded):
+			results[i].Result = BatchUploaded
+		case errors.Is(uploadErr, storage.ErrAnotherUserUploaded):
+			results[i].Result = BatchConflict
+		default:
+			accepted++
+		}
+	}
+	return results, accepted, nil
+}
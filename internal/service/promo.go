@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gophermart/internal/storage"
+)
+
+var (
+	ErrInvalidPromo = errors.New("invalid promo code")
+	ErrEmptyPromo   = errors.New("code is required")
+)
+
+// maxPromoPercent caps the extra accrual of a boost code.
+const maxPromoPercent = 100
+
+type PromoService struct {
+	strg storage.Storager
+}
+
+func NewPromoService(strg storage.Storager) *PromoService {
+	return &PromoService{strg: strg}
+}
+
+// normalizePromoCode makes codes case and whitespace insensitive.
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Create adds the code. Inconsistent settings return ErrInvalidPromo and a taken code
+// storage.ErrConflict.
+func (s *PromoService) Create(ctx context.Context, promo storage.PromoCode) error {
+	promo.Code = normalizePromoCode(promo.Code)
+	var reason string
+	switch {
+	case promo.Code == "":
+		reason = "code is required"
+	case promo.Kind == storage.PromoFixed && promo.Bonus <= 0:
+		reason = "fixed code needs a positive bonus"
+	case promo.Kind == storage.PromoBoost && (promo.Percent <= 0 || promo.Orders <= 0):
+		reason = "boost code needs positive percent and orders"
+	case promo.Kind == storage.PromoBoost && promo.Percent > maxPromoPercent:
+		reason = fmt.Sprintf("percent can not exceed %d", maxPromoPercent)
+	case promo.Kind != storage.PromoFixed && promo.Kind != storage.PromoBoost:
+		reason = "type must be fixed or boost"
+	case promo.MaxUses < 0:
+		reason = "max_uses can not be negative"
+	case !promo.ValidFrom.IsZero() && !promo.ValidTo.IsZero() && !promo.ValidFrom.Before(promo.ValidTo):
+		reason = "valid_from must be earlier than valid_to"
+	}
+	if reason != "" {
+		return fmt.Errorf("%w: %s", ErrInvalidPromo, reason)
+	}
+	return storage.WithContext(ctx, s.strg).AddPromoCode(promo)
+}
+
+// Redeem applies the code for the user. Besides ErrEmptyPromo it returns storage.ErrNotFound for an
+// unknown code, storage.ErrGone outside the validity window or past max uses and storage.ErrConflict
+// when the user already redeemed it.
+func (s *PromoService) Redeem(ctx context.Context, userID, code string) (storage.PromoCode, error) {
+	code = normalizePromoCode(code)
+	if code == "" {
+		return storage.PromoCode{}, ErrEmptyPromo
+	}
+	return storage.WithContext(ctx, s.strg).RedeemPromoCode(userID, code)
+}
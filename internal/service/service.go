@@ -0,0 +1,85 @@
+// Package service holds the business rules shared by the HTTP and gRPC transports.
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/config"
+	"gophermart/internal/storage"
+	"gophermart/internal/validation"
+)
+
+type Services struct {
+	Users   *UserService
+	Orders  *OrderService
+	Balance *BalanceService
+	Promos  *PromoService
+}
+
+func New(cfg *config.Config, strg storage.Storager, validator *validation.Validator) *Services {
+	return &Services{
+		Users:   NewUserService(cfg, strg, validator),
+		Orders:  NewOrderService(strg, validator, cfg.MaxOrdersBatch),
+		Balance: NewBalanceService(cfg, strg, validator),
+		Promos:  NewPromoService(strg),
+	}
+}
+
+type clientCtxKey struct{}
+
+// Client describes who sent the request, for throttling and audit.
+type Client struct {
+	IP        string
+	RequestID string
+	// ClaimedUserID is the user ID the client sent, it is not verified.
+	ClaimedUserID string
+}
+
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientCtxKey{}, client)
+}
+
+func clientFrom(ctx context.Context) Client {
+	client, _ := ctx.Value(clientCtxKey{}).(Client)
+	return client
+}
+
+// audit records the entry with the client of ctx; failures are only logged since the action
+// itself has already happened.
+func audit(ctx context.Context, strg storage.Storager, entry storage.AuditEntry) {
+	client := clientFrom(ctx)
+	entry.IP = client.IP
+	entry.RequestID = client.RequestID
+	if err := storage.WithContext(ctx, strg).AddAuditEntry(entry); err != nil {
+		log.Error().Err(err).Msgf("AddAuditEntry %s err", entry.Action)
+	}
+}
+
+func hashPassword(password string) string {
+	hash := sha256.New()
+	hash.Write([]byte(password))
+	dst := hash.Sum(nil)
+	return hex.EncodeToString(dst)
+}
+
+// NewID returns a random identifier of n letters and digits. It is also used for secrets, so the
+// letters come from crypto/rand.
+func NewID(n int) string {
+	const letterBytes = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	letters := big.NewInt(int64(len(letterBytes)))
+	bts := make([]byte, n)
+	for i := 0; i < n; i++ {
+		index, err := rand.Int(rand.Reader, letters)
+		if err != nil {
+			panic("crypto/rand: " + err.Error())
+		}
+		bts[i] = letterBytes[index.Int64()]
+	}
+	return string(bts)
+}
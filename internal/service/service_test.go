@@ -0,0 +1,439 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/config"
+	"gophermart/internal/storage"
+	"gophermart/internal/validation"
+)
+
+// mockStorage implements the Storager calls the services make, the embedded nil interface panics on
+// any other call so the tests notice unexpected storage access.
+type mockStorage struct {
+	storage.Storager
+	users     map[string]storage.NewUser
+	orders    map[string]string
+	failures  map[string]storage.LoginFailures
+	balance   int
+	withdrawn []string
+	transfers int
+	audit     []storage.AuditEntry
+	promos    map[string]storage.PromoCode
+	// collisions makes the next AddNewUser calls fail as if the generated IDs were taken.
+	collisions int
+}
+
+func newMockStorage() *mockStorage {
+	return &mockStorage{
+		users:    make(map[string]storage.NewUser),
+		orders:   make(map[string]string),
+		failures: make(map[string]storage.LoginFailures),
+		promos:   make(map[string]storage.PromoCode),
+	}
+}
+
+func (m *mockStorage) AddNewUser(user storage.NewUser) error {
+	if m.collisions > 0 {
+		m.collisions--
+		return storage.ErrDuplicateID
+	}
+	for _, u := range m.users {
+		if u.Login == user.Login {
+			return storage.ErrConflict
+		}
+	}
+	m.users[user.UserID] = user
+	return nil
+}
+
+func (m *mockStorage) LogInUser(login, password string) (string, error) {
+	for id, u := range m.users {
+		if u.Login == login && u.Password == password {
+			return id, nil
+		}
+	}
+	return "", storage.ErrAuthError
+}
+
+func (m *mockStorage) CheckUser(userID string) error {
+	if _, ok := m.users[userID]; !ok {
+		return storage.ErrAuthError
+	}
+	return nil
+}
+
+func (m *mockStorage) AddNewOrder(userID, order string) error {
+	owner, ok := m.orders[order]
+	switch {
+	case ok && owner == userID:
+		return storage.ErrUploaded
+	case ok:
+		return storage.ErrAnotherUserUploaded
+	}
+	m.orders[order] = userID
+	return nil
+}
+
+func (m *mockStorage) AddNewOrders(userID string, orders []string) ([]error, error) {
+	errs := make([]error, len(orders))
+	for i, order := range orders {
+		errs[i] = m.AddNewOrder(userID, order)
+	}
+	return errs, nil
+}
+
+func (m *mockStorage) UserWithdraw(userID, order string, sum float32) error {
+	if int(sum*100) > m.balance {
+		return storage.ErrNotEnouthBalance
+	}
+	m.balance -= int(sum * 100)
+	m.withdrawn = append(m.withdrawn, order)
+	return nil
+}
+
+func (m *mockStorage) TransferPoints(userID, transferID, toLogin string, sum float32, comment string, dailyAmount, dailyCount int) error {
+	var toID string
+	for id, u := range m.users {
+		if u.Login == toLogin {
+			toID = id
+		}
+	}
+	switch {
+	case toID == "":
+		return storage.ErrNotFound
+	case toID == userID:
+		return storage.ErrConflict
+	case m.transfers >= dailyCount:
+		return storage.ErrLimitExceeded
+	}
+	m.transfers++
+	return nil
+}
+
+func (m *mockStorage) ReserveLoginAttempt(subject string, limits storage.LoginLimits) (storage.LoginFailures, error) {
+	failures := m.failures[subject]
+	now := time.Now()
+	if failures.LockedUntil.After(now) {
+		return failures, storage.ErrLocked
+	}
+	if failures.Failures >= limits.MaxFailures && failures.LastFailure.After(failures.LockedUntil) {
+		failures.LockedUntil = now.Add(limits.Lockout)
+		m.failures[subject] = failures
+		failures.JustLocked = true
+		return failures, storage.ErrLocked
+	}
+	failures.Failures++
+	failures.LastFailure = now
+	m.failures[subject] = failures
+	return failures, nil
+}
+
+func (m *mockStorage) ReleaseLoginAttempt(subject string) error {
+	failures := m.failures[subject]
+	if failures.Failures > 0 {
+		failures.Failures--
+	}
+	m.failures[subject] = failures
+	return nil
+}
+
+func (m *mockStorage) ResetLoginFailures(subject string) error {
+	delete(m.failures, subject)
+	return nil
+}
+
+func (m *mockStorage) AddAuditEntry(entry storage.AuditEntry) error {
+	m.audit = append(m.audit, entry)
+	return nil
+}
+
+func (m *mockStorage) AddPromoCode(promo storage.PromoCode) error {
+	if _, ok := m.promos[promo.Code]; ok {
+		return storage.ErrConflict
+	}
+	m.promos[promo.Code] = promo
+	return nil
+}
+
+func (m *mockStorage) RedeemPromoCode(userID, code string) (storage.PromoCode, error) {
+	promo, ok := m.promos[code]
+	if !ok {
+		return storage.PromoCode{}, storage.ErrNotFound
+	}
+	return promo, nil
+}
+
+func testServices(t *testing.T) (*Services, *mockStorage) {
+	cfg := &config.Config{
+		LoginMinLength:     3,
+		LoginMaxLength:     16,
+		LoginCharset:       "^[!-~]+$",
+		PasswordMinLength:  6,
+		PasswordMaxLength:  32,
+		OrderMinLength:     2,
+		OrderMaxLength:     20,
+		LoginMaxFailures:   3,
+		LoginMaxIPFailures: 100,
+		LoginLockout:       time.Minute,
+		LoginFreeFailures:  100,
+		MaxOrdersBatch:     3,
+		TransferDailyLimit: 1000,
+		TransferDailyCount: 1,
+	}
+	validator, err := validation.NewValidator(cfg)
+	require.NoError(t, err)
+	strg := newMockStorage()
+	return New(cfg, strg, validator), strg
+}
+
+func testContext() context.Context {
+	return WithClient(context.Background(), Client{IP: "192.0.2.1", RequestID: "req-1"})
+}
+
+func TestRegisterAndLogIn(t *testing.T) {
+	services, strg := testServices(t)
+	ctx := testContext()
+
+	userID, err := services.Users.Register(ctx, " gopher ", "s3cret-pass", "")
+	require.NoError(t, err)
+	assert.Len(t, userID, 16)
+	user := strg.users[userID]
+	assert.Equal(t, "gopher", user.Login)
+	assert.Equal(t, hashPassword("s3cret-pass"), user.Password)
+	assert.NotEqual(t, "s3cret-pass", user.Password)
+	assert.Equal(t, "192.0.2.1", user.IP)
+	assert.Len(t, user.ReferralCode, 8)
+
+	_, err = services.Users.Register(ctx, "gopher", "other-pass", "")
+	assert.ErrorIs(t, err, storage.ErrConflict)
+	_, err = services.Users.Register(ctx, "go", "s3cret-pass", "")
+	assert.ErrorIs(t, err, validation.ErrMalformed)
+
+	loggedIn, err := services.Users.LogIn(ctx, "gopher", "s3cret-pass")
+	require.NoError(t, err)
+	assert.Equal(t, userID, loggedIn)
+	assert.NoError(t, services.Users.Authenticate(ctx, userID))
+	assert.ErrorIs(t, services.Users.Authenticate(ctx, ""), storage.ErrAuthError)
+	assert.ErrorIs(t, services.Users.Authenticate(ctx, "unknown"), storage.ErrAuthError)
+
+	require.NotEmpty(t, strg.audit)
+	last := strg.audit[len(strg.audit)-1]
+	assert.Equal(t, "user.login", last.Action)
+	assert.Equal(t, "req-1", last.RequestID)
+}
+
+func TestRegisterRetriesTakenID(t *testing.T) {
+	services, strg := testServices(t)
+	ctx := testContext()
+
+	strg.collisions = registerAttempts - 1
+	userID, err := services.Users.Register(ctx, "gopher", "s3cret-pass", "")
+	require.NoError(t, err)
+	assert.Contains(t, strg.users, userID)
+
+	strg.collisions = registerAttempts
+	_, err = services.Users.Register(ctx, "beaver", "s3cret-pass", "")
+	assert.ErrorIs(t, err, storage.ErrDuplicateID)
+}
+
+func TestRegisterPassesClientUser(t *testing.T) {
+	services, strg := testServices(t)
+	ctx := WithClient(context.Background(), Client{IP: "192.0.2.1", ClaimedUserID: "referrer"})
+
+	userID, err := services.Users.Register(ctx, "gopher", "s3cret-pass", " code ")
+	require.NoError(t, err)
+	user := strg.users[userID]
+	assert.Equal(t, "code", user.ReferredBy)
+	assert.Equal(t, "referrer", user.ClientUserID, "storage rejects a referrer inviting themselves")
+}
+
+func TestLogInLockout(t *testing.T) {
+	services, _ := testServices(t)
+	ctx := testContext()
+	_, err := services.Users.Register(ctx, "gopher", "s3cret-pass", "")
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = services.Users.LogIn(ctx, "gopher", "wrong-pass")
+		assert.ErrorIs(t, err, storage.ErrAuthError)
+	}
+	_, err = services.Users.LogIn(ctx, "gopher", "s3cret-pass")
+	var throttled *ThrottledError
+	require.True(t, errors.As(err, &throttled), "got %v", err)
+	assert.True(t, throttled.Locked)
+	assert.Greater(t, throttled.Wait, time.Duration(0))
+}
+
+func TestLogInCountsAttemptsUpFront(t *testing.T) {
+	services, strg := testServices(t)
+	ctx := testContext()
+	_, err := services.Users.Register(ctx, "gopher", "s3cret-pass", "")
+	require.NoError(t, err)
+
+	// Guesses still being checked already count, so concurrent ones cannot pass the limit together.
+	for i := 0; i < 3; i++ {
+		_, err = services.Users.reserveLoginAttempt(ctx, "gopher", "192.0.2.1")
+		require.NoError(t, err)
+	}
+	_, err = services.Users.reserveLoginAttempt(ctx, "gopher", "192.0.2.1")
+	var throttled *ThrottledError
+	require.True(t, errors.As(err, &throttled), "got %v", err)
+	assert.True(t, throttled.Locked)
+	assert.Equal(t, "login.lockout", strg.audit[len(strg.audit)-1].Action)
+	assert.Equal(t, 3, strg.failures["ip:192.0.2.1"].Failures, "the refused attempt is given back")
+
+	// A right password gives the attempt back to the client IP.
+	delete(strg.failures, "login:gopher")
+	_, err = services.Users.LogIn(ctx, "gopher", "s3cret-pass")
+	require.NoError(t, err)
+	assert.Equal(t, 3, strg.failures["ip:192.0.2.1"].Failures)
+	assert.NotContains(t, strg.failures, "login:gopher")
+}
+
+func TestUploadOrder(t *testing.T) {
+	services, strg := testServices(t)
+	ctx := testContext()
+
+	require.NoError(t, services.Orders.Upload(ctx, "u1", "12345678903"))
+	assert.ErrorIs(t, services.Orders.Upload(ctx, "u1", "12345678903"), storage.ErrUploaded)
+	assert.ErrorIs(t, services.Orders.Upload(ctx, "u2", "12345678903"), storage.ErrAnotherUserUploaded)
+	assert.ErrorIs(t, services.Orders.Upload(ctx, "u1", "12345678904"), validation.ErrInvalid)
+	assert.ErrorIs(t, services.Orders.Upload(ctx, "u1", "12a45"), validation.ErrInvalid)
+	assert.Len(t, strg.orders, 1)
+}
+
+func TestUploadBatch(t *testing.T) {
+	services, strg := testServices(t)
+	ctx := testContext()
+	strg.orders["79927398713"] = "u1"
+	strg.orders["4561261212345467"] = "u2"
+
+	results, accepted, err := services.Orders.UploadBatch(ctx, "u1", []string{"12345678903", "79927398713", "4561261212345467"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, accepted)
+	assert.Equal(t, []BatchResult{
+		{Number: "12345678903", Result: BatchAccepted},
+		{Number: "79927398713", Result: BatchUploaded},
+		{Number: "4561261212345467", Result: BatchConflict},
+	}, results)
+
+	results, accepted, err = services.Orders.UploadBatch(ctx, "u1", []string{"12345678904"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, accepted)
+	assert.Equal(t, BatchInvalid, results[0].Result)
+	assert.NotEmpty(t, results[0].Reason)
+
+	_, _, err = services.Orders.UploadBatch(ctx, "u1", nil)
+	assert.ErrorIs(t, err, ErrEmptyBatch)
+	_, _, err = services.Orders.UploadBatch(ctx, "u1", []string{"1", "2", "3", "4"})
+	assert.ErrorIs(t, err, ErrBatchTooLarge)
+}
+
+func TestWithdraw(t *testing.T) {
+	services, strg := testServices(t)
+	ctx := testContext()
+	strg.balance = 10000
+
+	require.NoError(t, services.Balance.Withdraw(ctx, "u1", "12345678903", 40))
+	assert.Equal(t, 6000, strg.balance)
+	assert.ErrorIs(t, services.Balance.Withdraw(ctx, "u1", "79927398713", 100), storage.ErrNotEnouthBalance)
+	assert.ErrorIs(t, services.Balance.Withdraw(ctx, "u1", "79927398713", 0), ErrInvalidSum)
+	assert.ErrorIs(t, services.Balance.Withdraw(ctx, "u1", "12345678904", 1), validation.ErrInvalid)
+	assert.Equal(t, []string{"12345678903"}, strg.withdrawn)
+}
+
+func TestTransfer(t *testing.T) {
+	services, strg := testServices(t)
+	ctx := testContext()
+	strg.users["u1"] = storage.NewUser{UserID: "u1", Login: "alice"}
+	strg.users["u2"] = storage.NewUser{UserID: "u2", Login: "bob"}
+
+	tests := []struct {
+		login   string
+		sum     float32
+		comment string
+		err     error
+	}{
+		{login: " ", sum: 1, err: ErrInvalidTransfer},
+		{login: "bob", sum: -1, err: ErrInvalidTransfer},
+		{login: "bob", sum: 1, comment: fmt.Sprintf("%0201d", 0), err: ErrCommentTooLong},
+		{login: "carol", sum: 1, err: ErrUnknownReceiver},
+		{login: "alice", sum: 1, err: ErrSelfTransfer},
+		{login: " bob ", sum: 1},
+		{login: "bob", sum: 1, err: storage.ErrLimitExceeded},
+	}
+	for _, tt := range tests {
+		err := services.Balance.Transfer(ctx, "u1", tt.login, tt.sum, tt.comment)
+		if tt.err == nil {
+			assert.NoError(t, err, tt.login)
+			continue
+		}
+		assert.ErrorIs(t, err, tt.err, tt.login)
+	}
+}
+
+func TestCreatePromo(t *testing.T) {
+	services, strg := testServices(t)
+	ctx := testContext()
+	from := time.Now()
+
+	tests := []struct {
+		name  string
+		promo storage.PromoCode
+		err   error
+	}{
+		{name: "no code", promo: storage.PromoCode{Code: " ", Kind: storage.PromoFixed, Bonus: 100}, err: ErrInvalidPromo},
+		{name: "no bonus", promo: storage.PromoCode{Code: "FIXED", Kind: storage.PromoFixed}, err: ErrInvalidPromo},
+		{name: "no orders", promo: storage.PromoCode{Code: "BOOST", Kind: storage.PromoBoost, Percent: 10}, err: ErrInvalidPromo},
+		{name: "no percent", promo: storage.PromoCode{Code: "BOOST", Kind: storage.PromoBoost, Orders: 1}, err: ErrInvalidPromo},
+		{name: "large percent", promo: storage.PromoCode{Code: "BOOST", Kind: storage.PromoBoost, Percent: 101, Orders: 1}, err: ErrInvalidPromo},
+		{name: "unknown type", promo: storage.PromoCode{Code: "OTHER", Kind: "other", Bonus: 100}, err: ErrInvalidPromo},
+		{name: "negative max uses", promo: storage.PromoCode{Code: "FIXED", Kind: storage.PromoFixed, Bonus: 100, MaxUses: -1}, err: ErrInvalidPromo},
+		{name: "empty window", promo: storage.PromoCode{Code: "FIXED", Kind: storage.PromoFixed, Bonus: 100, ValidFrom: from, ValidTo: from}, err: ErrInvalidPromo},
+		{name: "fixed", promo: storage.PromoCode{Code: " fixed ", Kind: storage.PromoFixed, Bonus: 100, ValidFrom: from}},
+		{name: "boost", promo: storage.PromoCode{Code: "boost", Kind: storage.PromoBoost, Percent: 100, Orders: 2}},
+		{name: "taken", promo: storage.PromoCode{Code: "Fixed", Kind: storage.PromoFixed, Bonus: 200}, err: storage.ErrConflict},
+	}
+	for _, tt := range tests {
+		err := services.Promos.Create(ctx, tt.promo)
+		if tt.err == nil {
+			assert.NoError(t, err, tt.name)
+			continue
+		}
+		assert.ErrorIs(t, err, tt.err, tt.name)
+	}
+	assert.Len(t, strg.promos, 2)
+	assert.Equal(t, 100, strg.promos["FIXED"].Bonus)
+}
+
+func TestRedeemPromo(t *testing.T) {
+	services, strg := testServices(t)
+	ctx := testContext()
+	strg.promos["FIXED"] = storage.PromoCode{Code: "FIXED", Kind: storage.PromoFixed, Bonus: 100}
+
+	promo, err := services.Promos.Redeem(ctx, "u1", " fixed ")
+	require.NoError(t, err)
+	assert.Equal(t, "FIXED", promo.Code)
+	_, err = services.Promos.Redeem(ctx, "u1", " ")
+	assert.ErrorIs(t, err, ErrEmptyPromo)
+	_, err = services.Promos.Redeem(ctx, "u1", "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestNewID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := NewID(16)
+		assert.Regexp(t, "^[0-9a-zA-Z]{16}$", id)
+		assert.False(t, seen[id], "duplicate ID %s", id)
+		seen[id] = true
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/config"
+	"gophermart/internal/storage"
+	"gophermart/internal/validation"
+)
+
+// registerAttempts bounds the retries when a generated user ID or referral code is already taken.
+const registerAttempts = 3
+
+type UserService struct {
+	cfg       *config.Config
+	strg      storage.Storager
+	validator *validation.Validator
+}
+
+func NewUserService(cfg *config.Config, strg storage.Storager, validator *validation.Validator) *UserService {
+	return &UserService{cfg: cfg, strg: strg, validator: validator}
+}
+
+// Register creates the user and returns its ID. Wrong credentials return a validation error,
+// a taken login storage.ErrConflict and an unknown referral code storage.ErrReferralCode.
+func (s *UserService) Register(ctx context.Context, login, password, referralCode string) (string, error) {
+	login, err := s.validator.Registration(login, password)
+	if err != nil {
+		return "", err
+	}
+	var userID string
+	for attempt := 0; attempt < registerAttempts; attempt++ {
+		userID = NewID(16)
+		err = storage.WithContext(ctx, s.strg).AddNewUser(storage.NewUser{
+			UserID:       userID,
+			Login:        login,
+			Password:     hashPassword(password),
+			ReferralCode: NewID(8),
+			ReferredBy:   strings.TrimSpace(referralCode),
+			IP:           clientFrom(ctx).IP,
+			ClientUserID: clientFrom(ctx).ClaimedUserID,
+		})
+		if !errors.Is(err, storage.ErrDuplicateID) {
+			break
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	audit(ctx, s.strg, storage.AuditEntry{Actor: "user:" + userID, Action: "user.register", Target: login})
+	return userID, nil
+}
+
+// LogIn returns the user ID for the credentials, storage.ErrAuthError when they are wrong and
+// a *ThrottledError while the login or the client is throttled.
+func (s *UserService) LogIn(ctx context.Context, login, password string) (string, error) {
+	login, err := s.validator.LogIn(login, password)
+	if err != nil {
+		return "", err
+	}
+	reserved, err := s.reserveLoginAttempt(ctx, login, clientFrom(ctx).IP)
+	if err != nil {
+		return "", err
+	}
+	userID, err := storage.WithContext(ctx, s.strg).LogInUser(login, hashPassword(password))
+	if errors.Is(err, storage.ErrAuthError) {
+		// The attempt was already counted as a failure.
+		audit(ctx, s.strg, storage.AuditEntry{Actor: "anonymous", Action: "user.login_failed", Target: login})
+		return "", err
+	}
+	if err != nil {
+		s.releaseLoginAttempt(ctx, reserved)
+		return "", fmt.Errorf("LogInUser: %w", err)
+	}
+	s.releaseLoginAttempt(ctx, reserved)
+	if err = storage.WithContext(ctx, s.strg).ResetLoginFailures("login:" + login); err != nil {
+		log.Error().Err(err).Msg("LogIn ResetLoginFailures err")
+	}
+	audit(ctx, s.strg, storage.AuditEntry{Actor: "user:" + userID, Action: "user.login", Target: login})
+	return userID, nil
+}
+
+// Authenticate checks the user ID given by the client, unknown IDs return storage.ErrAuthError.
+func (s *UserService) Authenticate(ctx context.Context, userID string) error {
+	if userID == "" {
+		return storage.ErrAuthError
+	}
+	return storage.WithContext(ctx, s.strg).CheckUser(userID)
+}
+
+// Referrals returns the user's referral code and referred users as JSON.
+func (s *UserService) Referrals(ctx context.Context, userID string) ([]byte, error) {
+	return storage.WithContext(ctx, s.strg).UserReferrals(userID)
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	AdjustmentPending  = "PENDING"
+	AdjustmentApproved = "APPROVED"
+	AdjustmentRejected = "REJECTED"
+)
+
+func createAdjustments(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS gophermart_adjustments(adjustment_id text UNIQUE, user_id text NOT NULL, amount bigint NOT NULL, reason_code text NOT NULL, reference text DEFAULT '', comment text DEFAULT '', status text DEFAULT 'PENDING', created_by text NOT NULL, created_at timestamptz DEFAULT now(), decided_by text, decided_at timestamptz);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_adjustments_status_idx ON gophermart_adjustments(status, created_at);")
+	return err
+}
+
+// CreateAdjustment records an adjustment request; the balance is untouched until another admin approves it.
+func (s *SQLStorage) CreateAdjustment(adjustment Adjustment) error {
+	var exists bool
+	if err := s.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM gophermart_users WHERE user_id = $1)", adjustment.UserID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	_, err := s.DB.Exec("INSERT INTO gophermart_adjustments(adjustment_id, user_id, amount, reason_code, reference, comment, created_by) VALUES($1, $2, $3, $4, $5, $6, $7)",
+		adjustment.ID, adjustment.UserID, adjustment.Amount, adjustment.ReasonCode, adjustment.Reference, adjustment.Comment, adjustment.CreatedBy)
+	return err
+}
+
+func lockAdjustment(tx *sql.Tx, id, approver string) (Adjustment, error) {
+	var adjustment Adjustment
+	err := tx.QueryRow("SELECT adjustment_id, user_id, amount, status, created_by FROM gophermart_adjustments WHERE adjustment_id = $1 FOR UPDATE", id).
+		Scan(&adjustment.ID, &adjustment.UserID, &adjustment.Amount, &adjustment.Status, &adjustment.CreatedBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return adjustment, ErrNotFound
+	}
+	if err != nil {
+		return adjustment, err
+	}
+	if adjustment.Status != AdjustmentPending {
+		return adjustment, ErrConflict
+	}
+	// Neither the admin who requested the adjustment nor the user it credits may decide it.
+	if adjustment.CreatedBy == approver || approver == "admin:"+adjustment.UserID {
+		return adjustment, ErrSameApprover
+	}
+	return adjustment, nil
+}
+
+// ApproveAdjustment applies a pending adjustment as a ledger posting against the adjustments account.
+func (s *SQLStorage) ApproveAdjustment(id, approver string) (Adjustment, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return Adjustment{}, err
+	}
+	defer tx.Rollback()
+
+	adjustment, err := lockAdjustment(tx, id, approver)
+	if err != nil {
+		return adjustment, err
+	}
+	balance, err := lockBalance(tx, adjustment.UserID)
+	if err != nil {
+		return adjustment, err
+	}
+	reference := "adjustment:" + adjustment.ID
+	if adjustment.Amount > 0 {
+		err = s.post(tx, PostingAdjustment, reference, AccountAdjustments, UserAccount(adjustment.UserID), adjustment.Amount)
+		if err != nil {
+			return adjustment, err
+		}
+	} else {
+		held, err := heldAmount(tx, adjustment.UserID)
+		if err != nil {
+			return adjustment, err
+		}
+		if balance-held < -adjustment.Amount {
+			return adjustment, ErrNotEnouthBalance
+		}
+		err = s.post(tx, PostingAdjustment, reference, UserAccount(adjustment.UserID), AccountAdjustments, -adjustment.Amount)
+		if err != nil {
+			return adjustment, err
+		}
+	}
+	_, err = tx.Exec("UPDATE gophermart_adjustments SET status=$1, decided_by=$2, decided_at=now() WHERE adjustment_id = $3", AdjustmentApproved, approver, id)
+	if err != nil {
+		return adjustment, err
+	}
+	adjustment.Status = AdjustmentApproved
+	return adjustment, tx.Commit()
+}
+
+func (s *SQLStorage) RejectAdjustment(id, approver string) (Adjustment, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return Adjustment{}, err
+	}
+	defer tx.Rollback()
+
+	adjustment, err := lockAdjustment(tx, id, approver)
+	if err != nil {
+		return adjustment, err
+	}
+	_, err = tx.Exec("UPDATE gophermart_adjustments SET status=$1, decided_by=$2, decided_at=now() WHERE adjustment_id = $3", AdjustmentRejected, approver, id)
+	if err != nil {
+		return adjustment, err
+	}
+	adjustment.Status = AdjustmentRejected
+	return adjustment, tx.Commit()
+}
+
+// Adjustments lists adjustment requests with the status, all of them when status is empty.
+func (s *SQLStorage) Adjustments(status string) ([]byte, error) {
+	rows, err := s.DB.Query(`SELECT a.adjustment_id, u.login, a.amount, a.reason_code, a.reference, a.comment, a.status, a.created_by, a.created_at, COALESCE(a.decided_by, ''), a.decided_at
+		FROM gophermart_adjustments a JOIN gophermart_users u ON u.user_id = a.user_id WHERE $1 = '' OR a.status = $1 ORDER BY a.created_at DESC LIMIT 500`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]adjustments, 0)
+	for rows.Next() {
+		var entry adjustments
+		var amount int
+		var createdAt time.Time
+		var decidedAt sql.NullTime
+		err = rows.Scan(&entry.ID, &entry.Login, &amount, &entry.ReasonCode, &entry.Reference, &entry.Comment, &entry.Status, &entry.CreatedBy, &createdAt, &entry.DecidedBy, &decidedAt)
+		if err != nil {
+			return nil, err
+		}
+		entry.Amount = float32(amount) / 100
+		entry.CreatedAt = createdAt.Format(time.RFC3339)
+		if decidedAt.Valid {
+			entry.DecidedAt = decidedAt.Time.Format(time.RFC3339)
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(entries)
+}
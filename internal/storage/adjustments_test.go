@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApproveAdjustment(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	addTestUser(t, s, "admin")
+	assert.ErrorIs(t, s.CreateAdjustment(Adjustment{ID: "missing", UserID: "bob", Amount: 100, ReasonCode: "goodwill", CreatedBy: "admin:support"}), ErrNotFound)
+
+	require.NoError(t, s.CreateAdjustment(Adjustment{ID: "credit", UserID: "alice", Amount: 500, ReasonCode: "goodwill", Reference: "ticket-1", CreatedBy: "admin:support"}))
+	_, err := s.ApproveAdjustment("credit", "admin:support")
+	assert.ErrorIs(t, err, ErrSameApprover, "the requester may not approve")
+	_, err = s.ApproveAdjustment("credit", "admin:alice")
+	assert.ErrorIs(t, err, ErrSameApprover, "the recipient may not approve")
+	balance, _ := balanceOf(t, s, "alice")
+	assert.Zero(t, balance)
+
+	adjustment, err := s.ApproveAdjustment("credit", "admin:admin")
+	require.NoError(t, err)
+	assert.Equal(t, AdjustmentApproved, adjustment.Status)
+	balance, _ = balanceOf(t, s, "alice")
+	assert.Equal(t, 500, balance)
+	assert.Equal(t, 0, ledgerBalance(t, s, AccountAdjustments)+balance)
+	_, err = s.ApproveAdjustment("credit", "admin:admin")
+	assert.ErrorIs(t, err, ErrConflict)
+	_, err = s.ApproveAdjustment("unknown", "admin:admin")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, s.CreateAdjustment(Adjustment{ID: "debit", UserID: "alice", Amount: -600, ReasonCode: "correction", CreatedBy: "admin:support"}))
+	_, err = s.ApproveAdjustment("debit", "admin:admin")
+	assert.ErrorIs(t, err, ErrNotEnouthBalance)
+	adjustment, err = s.RejectAdjustment("debit", "admin:admin")
+	require.NoError(t, err)
+	assert.Equal(t, AdjustmentRejected, adjustment.Status)
+	balance, _ = balanceOf(t, s, "alice")
+	assert.Equal(t, 500, balance)
+
+	body, err := s.Adjustments(AdjustmentRejected)
+	require.NoError(t, err)
+	var listed []adjustments
+	require.NoError(t, json.Unmarshal(body, &listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, "debit", listed[0].ID)
+	assert.Equal(t, "login-alice", listed[0].Login)
+	assert.Equal(t, "admin:admin", listed[0].DecidedBy)
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+func createRoles(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE gophermart_users ADD COLUMN IF NOT EXISTS role text DEFAULT 'user';")
+	return err
+}
+
+func (s *SQLStorage) UserRole(userID string) (string, error) {
+	var role string
+	err := s.DB.QueryRow("SELECT role FROM gophermart_users WHERE user_id = $1", userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrAuthError
+	}
+	if err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+func (s *SQLStorage) SetUserRole(login, role string) error {
+	result, err := s.DB.Exec("UPDATE gophermart_users SET role=$1 WHERE login = $2", role, login)
+	if err != nil {
+		return err
+	}
+	changes, _ := result.RowsAffected()
+	if changes == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLStorage) UserIDByLogin(login string) (string, error) {
+	var userID string
+	err := s.DB.QueryRow("SELECT user_id FROM gophermart_users WHERE login = $1", login).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+func (s *SQLStorage) AdminUser(login string) ([]byte, error) {
+	var user adminUser
+	var balance, withdrawn int
+	var registeredAt sql.NullTime
+	err := s.DB.QueryRow("SELECT user_id, login, role, tier, balance, withdrawn, registered_at FROM gophermart_users WHERE login = $1", login).
+		Scan(&user.UserID, &user.Login, &user.Role, &user.Tier, &balance, &withdrawn, &registeredAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = s.DB.QueryRow("SELECT count(*) FROM gophermart_orders WHERE user_id = $1", user.UserID).Scan(&user.Orders); err != nil {
+		return nil, err
+	}
+	user.Balance = float32(balance) / 100
+	user.Withdrawn = float32(withdrawn) / 100
+	if registeredAt.Valid {
+		user.RegisteredAt = registeredAt.Time.Format(time.RFC3339)
+	}
+	return json.Marshal(user)
+}
+
+func (s *SQLStorage) AdminOrder(order string) ([]byte, error) {
+	var entry adminOrder
+	var accrual int
+	var processedAt sql.NullString
+	err := s.DB.QueryRow(`SELECT o.order_no, o.user_id, u.login, o.status, o.accrual, o.date, o.processed_at FROM gophermart_orders o
+		JOIN gophermart_users u ON u.user_id = o.user_id WHERE o.order_no = $1`, order).
+		Scan(&entry.Number, &entry.UserID, &entry.Login, &entry.Status, &accrual, &entry.UploadedAt, &processedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry.Accrual = float32(accrual) / 100
+	entry.ProcessedAt = processedAt.String
+	return json.Marshal(entry)
+}
+
+// OverrideOrderStatus sets the order status by hand. Processed orders are final since their accrual
+// is already in the ledger; moving an order to PROCESSED credits the accrual like the accrual system does.
+func (s *SQLStorage) OverrideOrderStatus(order, status string, accrual float32) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var userID, current string
+	err = tx.QueryRow("SELECT user_id, status FROM gophermart_orders WHERE order_no = $1 FOR UPDATE", order).Scan(&userID, &current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if current == "PROCESSED" {
+		return ErrProcessed
+	}
+	if err = s.setOrderStatus(tx, AccuralResult{UserID: userID, Order: order, Status: status, Accrual: accrual}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func orderStatus(t *testing.T, s *SQLStorage, order string) string {
+	t.Helper()
+	var status string
+	require.NoError(t, s.DB.QueryRow("SELECT status FROM gophermart_orders WHERE order_no = $1", order).Scan(&status))
+	return status
+}
+
+func TestOverrideOrderStatus(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	require.NoError(t, s.AddNewOrder("alice", "12345678903"))
+
+	assert.ErrorIs(t, s.OverrideOrderStatus("79927398713", "INVALID", 0), ErrNotFound)
+
+	require.NoError(t, s.OverrideOrderStatus("12345678903", "PROCESSING", 0))
+	assert.Equal(t, "PROCESSING", orderStatus(t, s, "12345678903"))
+
+	require.NoError(t, s.OverrideOrderStatus("12345678903", "PROCESSED", 25))
+	assert.Equal(t, "PROCESSED", orderStatus(t, s, "12345678903"))
+	balance, _ := balanceOf(t, s, "alice")
+	assert.Equal(t, 2500, balance)
+
+	// Processed orders are final and credit nothing twice.
+	assert.ErrorIs(t, s.OverrideOrderStatus("12345678903", "INVALID", 0), ErrProcessed)
+	assert.ErrorIs(t, s.OverrideOrderStatus("12345678903", "PROCESSED", 25), ErrProcessed)
+	assert.Equal(t, "PROCESSED", orderStatus(t, s, "12345678903"))
+	balance, _ = balanceOf(t, s, "alice")
+	assert.Equal(t, 2500, balance)
+}
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gophermart/internal/audit"
+)
+
+// auditLockKey serializes sealing of audit entries into the hash chain.
+const auditLockKey = 7041917
+
+// auditSealBatch bounds the entries sealed in one transaction.
+const auditSealBatch = 1000
+
+const auditColumns = "a.id, a.created_at, COALESCE(a.actor, ''), COALESCE(a.action, ''), COALESCE(a.target, ''), COALESCE(a.ip, ''), a.request_id, a.before_amount, a.after_amount, COALESCE(a.details, '')"
+
+// Audit entries are appended to gophermart_audit, possibly inside a money transaction, and sealed
+// afterwards: gophermart_audit_chain links every committed entry to the previous one by hash.
+// Both tables are append-only.
+func createAudit(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE gophermart_audit ADD COLUMN IF NOT EXISTS request_id text DEFAULT '', ADD COLUMN IF NOT EXISTS before_amount bigint, ADD COLUMN IF NOT EXISTS after_amount bigint;")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_audit_chain(seq bigserial PRIMARY KEY, audit_id bigint UNIQUE NOT NULL, prev_hash text NOT NULL, hash text NOT NULL);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_audit_target_idx ON gophermart_audit(target, created_at);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE OR REPLACE FUNCTION gophermart_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+		END;
+		$$ LANGUAGE plpgsql;`)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"gophermart_audit", "gophermart_audit_chain"} {
+		_, err = db.Exec("DROP TRIGGER IF EXISTS " + table + "_append_only ON " + table + ";")
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec("CREATE TRIGGER " + table + "_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON " + table + " FOR EACH STATEMENT EXECUTE FUNCTION gophermart_append_only();")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nullAmount(amount *int64) sql.NullInt64 {
+	if amount == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: *amount, Valid: true}
+}
+
+func (s *SQLStorage) AddAuditEntry(entry AuditEntry) error {
+	_, err := s.DB.Exec("INSERT INTO gophermart_audit(actor, action, target, ip, request_id, before_amount, after_amount, details) VALUES($1, $2, $3, $4, $5, $6, $7, $8)",
+		entry.Actor, entry.Action, entry.Target, entry.IP, entry.RequestID, nullAmount(entry.Before), nullAmount(entry.After), entry.Details)
+	return err
+}
+
+// auditBalance records a balance change made by a ledger posting inside tx.
+func auditBalance(tx *sql.Tx, kind, reference, userID string, after, change int) error {
+	_, err := tx.Exec("INSERT INTO gophermart_audit(actor, action, target, before_amount, after_amount, details) VALUES('ledger', $1, $2, $3, $4, $5)",
+		"balance."+kind, userID, after-change, after, reference)
+	return err
+}
+
+func scanAuditRecord(row interface{ Scan(dest ...any) error }, extra ...any) (audit.Record, error) {
+	var record audit.Record
+	var before, after sql.NullInt64
+	dest := []any{&record.ID, &record.CreatedAt, &record.Actor, &record.Action, &record.Target, &record.IP, &record.RequestID, &before, &after, &record.Details}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return record, err
+	}
+	if before.Valid {
+		record.Before = &before.Int64
+	}
+	if after.Valid {
+		record.After = &after.Int64
+	}
+	return record, nil
+}
+
+// SealAudit chains the committed entries that are not sealed yet and returns how many it sealed.
+// Writers only append entries, the sealer runs it periodically so they never wait for the chain lock.
+func (s *SQLStorage) SealAudit() (int, error) {
+	sealed := 0
+	for {
+		batch, err := s.sealAuditBatch()
+		sealed += batch
+		if err != nil || batch < auditSealBatch {
+			return sealed, err
+		}
+	}
+}
+
+func (s *SQLStorage) sealAuditBatch() (int, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", auditLockKey); err != nil {
+		return 0, err
+	}
+	var prev string
+	err = tx.QueryRow("SELECT hash FROM gophermart_audit_chain ORDER BY seq DESC LIMIT 1").Scan(&prev)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	rows, err := tx.Query("SELECT "+auditColumns+" FROM gophermart_audit a LEFT JOIN gophermart_audit_chain c ON c.audit_id = a.id WHERE c.audit_id IS NULL ORDER BY a.id LIMIT $1", auditSealBatch)
+	if err != nil {
+		return 0, err
+	}
+	records := make([]audit.Record, 0)
+	for rows.Next() {
+		record, err := scanAuditRecord(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		records = append(records, record)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, record := range records {
+		hash := audit.Hash(prev, record)
+		if _, err = tx.Exec("INSERT INTO gophermart_audit_chain(audit_id, prev_hash, hash) VALUES($1, $2, $3)", record.ID, prev, hash); err != nil {
+			return 0, err
+		}
+		prev = hash
+	}
+	return len(records), tx.Commit()
+}
+
+// AuditEntries returns sealed audit entries matching the filter, newest first. Entries show up
+// once the sealer has chained them.
+func (s *SQLStorage) AuditEntries(filter AuditFilter) ([]byte, error) {
+	rows, err := s.DB.Query("SELECT "+auditColumns+`, c.hash FROM gophermart_audit a JOIN gophermart_audit_chain c ON c.audit_id = a.id
+		WHERE ($1 = '' OR a.actor = $1) AND starts_with(a.action, $2) AND ($3 = '' OR a.target = $3) AND a.created_at >= $4 AND a.created_at < $5
+		ORDER BY c.seq DESC LIMIT $6`, filter.Actor, filter.Action, filter.Target, filter.From, filter.To, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]auditEntries, 0)
+	for rows.Next() {
+		var hash string
+		record, err := scanAuditRecord(rows, &hash)
+		if err != nil {
+			return nil, err
+		}
+		entry := auditEntries{
+			ID:        record.ID,
+			At:        record.CreatedAt.Format(time.RFC3339),
+			Actor:     record.Actor,
+			Action:    record.Action,
+			Target:    record.Target,
+			IP:        record.IP,
+			RequestID: record.RequestID,
+			Details:   record.Details,
+			Hash:      hash,
+		}
+		if record.Before != nil {
+			before := float32(*record.Before) / 100
+			entry.Before = &before
+		}
+		if record.After != nil {
+			after := float32(*record.After) / 100
+			entry.After = &after
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(entries)
+}
+
+// VerifyAudit walks the hash chain from the start and returns the number of verified entries,
+// or ErrAuditTampered for the first entry that was changed, removed or reordered. Entries left
+// unsealed for longer than maxUnsealedAge fail the check too since nothing protects them; a zero
+// maxUnsealedAge skips that check.
+func VerifyAudit(db *sql.DB, maxUnsealedAge time.Duration) (int, error) {
+	rows, err := db.Query("SELECT " + auditColumns + ", c.audit_id, c.prev_hash, c.hash FROM gophermart_audit_chain c LEFT JOIN gophermart_audit a ON a.id = c.audit_id ORDER BY c.seq")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	verified := 0
+	prev := ""
+	for rows.Next() {
+		var id sql.NullInt64
+		var createdAt sql.NullTime
+		var actor, action, target, ip, requestID, details sql.NullString
+		var before, after sql.NullInt64
+		var auditID int64
+		var prevHash, hash string
+		err = rows.Scan(&id, &createdAt, &actor, &action, &target, &ip, &requestID, &before, &after, &details, &auditID, &prevHash, &hash)
+		if err != nil {
+			return verified, err
+		}
+		if !id.Valid {
+			return verified, fmt.Errorf("%w: entry %d is missing", ErrAuditTampered, auditID)
+		}
+		record := audit.Record{ID: id.Int64, CreatedAt: createdAt.Time, Actor: actor.String, Action: action.String, Target: target.String,
+			IP: ip.String, RequestID: requestID.String, Details: details.String}
+		if before.Valid {
+			record.Before = &before.Int64
+		}
+		if after.Valid {
+			record.After = &after.Int64
+		}
+		if prevHash != prev || audit.Hash(prev, record) != hash {
+			return verified, fmt.Errorf("%w: entry %d does not match its hash", ErrAuditTampered, auditID)
+		}
+		prev = hash
+		verified++
+	}
+	if err = rows.Err(); err != nil || maxUnsealedAge <= 0 {
+		return verified, err
+	}
+
+	var unsealed int
+	var oldest sql.NullTime
+	err = db.QueryRow("SELECT count(*), min(a.created_at) FROM gophermart_audit a LEFT JOIN gophermart_audit_chain c ON c.audit_id = a.id WHERE c.audit_id IS NULL").Scan(&unsealed, &oldest)
+	if err != nil {
+		return verified, err
+	}
+	if oldest.Valid && time.Since(oldest.Time) > maxUnsealedAge {
+		return verified, fmt.Errorf("%w: %d entries are unsealed, the oldest since %s", ErrAuditUnsealed, unsealed, oldest.Time.Format(time.RFC3339))
+	}
+	return verified, nil
+}
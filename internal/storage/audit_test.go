@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func auditCount(t *testing.T, s *SQLStorage) int {
+	t.Helper()
+	var count int
+	require.NoError(t, s.DB.QueryRow("SELECT count(*) FROM gophermart_audit").Scan(&count))
+	return count
+}
+
+func TestSealAudit(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	require.NoError(t, s.AddAuditEntry(AuditEntry{Actor: "user:alice", Action: "user.login", Target: "alice"}))
+
+	// Ledger postings only append entries, the sealer chains them.
+	credit(t, s, "alice", PostingAccrual, "12345678903", 500)
+	sealed, err := s.SealAudit()
+	require.NoError(t, err)
+	assert.Positive(t, sealed)
+	sealed, err = s.SealAudit()
+	require.NoError(t, err)
+	assert.Zero(t, sealed)
+
+	verified, err := VerifyAudit(s.DB, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, auditCount(t, s), verified)
+}
+
+func TestVerifyAuditUnsealed(t *testing.T) {
+	s := newTestStorage(t)
+	require.NoError(t, s.AddAuditEntry(AuditEntry{Actor: "system", Action: "ledger.drift"}))
+	_, err := s.DB.Exec("INSERT INTO gophermart_audit(actor, action, created_at) VALUES('ledger', 'balance.ACCRUAL', now() - interval '1 hour')")
+	require.NoError(t, err)
+
+	_, err = VerifyAudit(s.DB, time.Minute)
+	assert.ErrorIs(t, err, ErrAuditUnsealed)
+	_, err = VerifyAudit(s.DB, 2*time.Hour)
+	assert.NoError(t, err)
+	_, err = VerifyAudit(s.DB, 0)
+	assert.NoError(t, err)
+
+	_, err = s.SealAudit()
+	require.NoError(t, err)
+	verified, err := VerifyAudit(s.DB, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 2, verified)
+}
+
+func TestVerifyAuditTampered(t *testing.T) {
+	s := newTestStorage(t)
+	for _, action := range []string{"user.register", "user.login", "withdrawal.reverse"} {
+		require.NoError(t, s.AddAuditEntry(AuditEntry{Actor: "user:alice", Action: action}))
+	}
+	_, err := s.SealAudit()
+	require.NoError(t, err)
+	_, err = s.DB.Exec("ALTER TABLE gophermart_audit DISABLE TRIGGER gophermart_audit_append_only")
+	require.NoError(t, err)
+	_, err = s.DB.Exec("UPDATE gophermart_audit SET actor = 'user:mallory' WHERE action = 'user.login'")
+	require.NoError(t, err)
+
+	verified, err := VerifyAudit(s.DB, time.Minute)
+	assert.ErrorIs(t, err, ErrAuditTampered)
+	assert.Equal(t, 1, verified)
+
+	_, err = s.DB.Exec("DELETE FROM gophermart_audit WHERE action = 'user.login'")
+	require.NoError(t, err)
+	_, err = VerifyAudit(s.DB, time.Minute)
+	assert.ErrorIs(t, err, ErrAuditTampered)
+}
+
+func TestAuditEntriesActionPrefix(t *testing.T) {
+	s := newTestStorage(t)
+	for _, action := range []string{"user.login", "userXlogin", "user_login", "admin.users.read"} {
+		require.NoError(t, s.AddAuditEntry(AuditEntry{Actor: "user:alice", Action: action}))
+	}
+	_, err := s.SealAudit()
+	require.NoError(t, err)
+	actions := func(prefix string) []string {
+		body, err := s.AuditEntries(AuditFilter{Action: prefix, From: time.Unix(0, 0), To: time.Now().Add(time.Minute), Limit: 10})
+		require.NoError(t, err)
+		var entries []auditEntries
+		require.NoError(t, json.Unmarshal(body, &entries))
+		actions := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			assert.NotEmpty(t, entry.Hash)
+			actions = append(actions, entry.Action)
+		}
+		return actions
+	}
+
+	assert.Equal(t, []string{"user_login", "userXlogin", "user.login"}, actions("user"))
+	assert.Equal(t, []string{"user_login"}, actions("user_"))
+	assert.Empty(t, actions("%"))
+	assert.Len(t, actions(""), 4)
+}
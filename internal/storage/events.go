@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// eventsChannel is the NOTIFY channel announcing "<user id>:<outbox id>" for every outbox event.
+const eventsChannel = "gophermart_events"
+
+// outboxLockKey serializes outbox dispatches, which number the events for the streams.
+const outboxLockKey = 7041918
+
+type balanceEvent struct {
+	Event     string  `json:"event"`
+	Kind      string  `json:"kind"`
+	Reference string  `json:"reference,omitempty"`
+	Amount    float32 `json:"amount"`
+	Balance   float32 `json:"balance"`
+	At        string  `json:"at"`
+}
+
+// ListenEvents calls fn for every event stored by any instance until ctx is done or the connection breaks.
+func (s *SQLStorage) ListenEvents(ctx context.Context, fn func(userID string, id int64)) error {
+	conn, err := s.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		if _, err := pgConn.Exec(ctx, "LISTEN "+eventsChannel); err != nil {
+			return err
+		}
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				// The connection is still listening, never give it back to the pool.
+				return fmt.Errorf("%w: %s", driver.ErrBadConn, err)
+			}
+			userID, idStr, found := strings.Cut(notification.Payload, ":")
+			id, err := strconv.ParseInt(idStr, 10, 64)
+			if !found || err != nil {
+				continue
+			}
+			fn(userID, id)
+		}
+	})
+}
+
+// UserEvents returns up to limit dispatched events of the user that follow the event afterSeq.
+// Events are numbered at dispatch, so one committed late still comes after the cursor.
+func (s *SQLStorage) UserEvents(userID string, afterSeq int64, limit int) ([]Event, error) {
+	rows, err := s.DB.Query("SELECT seq, event, payload FROM gophermart_outbox WHERE user_id = $1 AND seq > $2 ORDER BY seq LIMIT $3", userID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]Event, 0)
+	for rows.Next() {
+		var event Event
+		if err = rows.Scan(&event.ID, &event.Type, &event.Payload); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// LastEventID returns the seq of the user's last dispatched event.
+func (s *SQLStorage) LastEventID(userID string) (int64, error) {
+	var seq int64
+	err := s.DB.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM gophermart_outbox WHERE user_id = $1", userID).Scan(&seq)
+	return seq, err
+}
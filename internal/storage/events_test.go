@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserEventsCommitOrder(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+
+	early, err := s.DB.Begin()
+	require.NoError(t, err)
+	defer early.Rollback()
+	require.NoError(t, writeOutbox(early, "alice", EventOrderUpdated, orderEvent{Event: EventOrderUpdated, Order: "12345678903", Status: "PROCESSING"}))
+
+	late, err := s.DB.Begin()
+	require.NoError(t, err)
+	defer late.Rollback()
+	require.NoError(t, writeOutbox(late, "alice", EventOrderUpdated, orderEvent{Event: EventOrderUpdated, Order: "2377225624", Status: "PROCESSING"}))
+	require.NoError(t, late.Commit())
+
+	// Undispatched events are not streamed yet.
+	events, err := s.UserEvents("alice", 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	_, err = s.DispatchOutbox()
+	require.NoError(t, err)
+	events, err = s.UserEvents("alice", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Contains(t, string(events[0].Payload), "2377225624")
+	cursor, err := s.LastEventID("alice")
+	require.NoError(t, err)
+	assert.Equal(t, events[0].ID, cursor)
+
+	// The event inserted first but committed last still follows the cursor.
+	require.NoError(t, early.Commit())
+	_, err = s.DispatchOutbox()
+	require.NoError(t, err)
+	events, err = s.UserEvents("alice", cursor, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Contains(t, string(events[0].Payload), "12345678903")
+	assert.Greater(t, events[0].ID, cursor)
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	HoldActive   = "ACTIVE"
+	HoldCaptured = "CAPTURED"
+	HoldVoided   = "VOIDED"
+	HoldExpired  = "EXPIRED"
+)
+
+// activeHolds is the condition for holds that still reserve points.
+const activeHolds = "status = 'ACTIVE' AND expires_at > now()"
+
+func createHolds(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS gophermart_holds(hold_id text UNIQUE, user_id text NOT NULL, order_no text NOT NULL, amount bigint NOT NULL, status text DEFAULT 'ACTIVE', created_at timestamptz DEFAULT now(), expires_at timestamptz NOT NULL, updated_at timestamptz DEFAULT now());")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_holds_user_idx ON gophermart_holds(user_id) WHERE status = 'ACTIVE';")
+	return err
+}
+
+func heldAmount(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, userID string) (int, error) {
+	var held int
+	err := q.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM gophermart_holds WHERE user_id = $1 AND "+activeHolds, userID).Scan(&held)
+	if err != nil {
+		return 0, err
+	}
+	return held, nil
+}
+
+// CreateHold reserves points for the order until expiresAt.
+func (s *SQLStorage) CreateHold(userID, holdID, order string, sum float32, expiresAt time.Time) error {
+	amount := int(sum * 100)
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	balance, err := lockBalance(tx, userID)
+	if err != nil {
+		return err
+	}
+	held, err := heldAmount(tx, userID)
+	if err != nil {
+		return err
+	}
+	if balance-held < amount {
+		return ErrNotEnouthBalance
+	}
+	if err = checkOrderFree(tx, order); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO gophermart_holds(hold_id, user_id, order_no, amount, expires_at) VALUES($1, $2, $3, $4, $5)", holdID, userID, order, amount, expiresAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkOrderFree returns ErrConflict when the order is already paid or held.
+func checkOrderFree(tx *sql.Tx, order string) error {
+	var used bool
+	err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM gophermart_withdraws WHERE order_no = $1) OR EXISTS(SELECT 1 FROM gophermart_holds WHERE order_no = $1 AND "+activeHolds+")", order).Scan(&used)
+	if err != nil {
+		return err
+	}
+	if used {
+		return ErrConflict
+	}
+	return nil
+}
+
+// insertWithdrawal records the withdrawal of the order, ErrConflict when the order is paid already.
+func insertWithdrawal(tx *sql.Tx, userID, order string, amount int) error {
+	result, err := tx.Exec("INSERT INTO gophermart_withdraws(order_no, user_id, sum, date) VALUES($1, $2, $3, $4) ON CONFLICT (order_no) DO NOTHING", order, userID, amount, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	if changes, _ := result.RowsAffected(); changes == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// lockHold locks an active hold of the user; expired or settled holds return ErrGone or ErrConflict.
+func lockHold(tx *sql.Tx, userID, holdID string) (Hold, error) {
+	var hold Hold
+	err := tx.QueryRow("SELECT hold_id, order_no, amount, status, expires_at FROM gophermart_holds WHERE hold_id = $1 AND user_id = $2 FOR UPDATE", holdID, userID).
+		Scan(&hold.ID, &hold.Order, &hold.Amount, &hold.Status, &hold.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return hold, ErrNotFound
+	}
+	if err != nil {
+		return hold, err
+	}
+	if hold.Status == HoldExpired || (hold.Status == HoldActive && !hold.ExpiresAt.After(time.Now())) {
+		return hold, ErrGone
+	}
+	if hold.Status != HoldActive {
+		return hold, ErrConflict
+	}
+	return hold, nil
+}
+
+// CaptureHold turns an active hold into a withdrawal. The balance is checked again since it may have
+// dropped below the hold, e.g. by an admin adjustment.
+func (s *SQLStorage) CaptureHold(userID, holdID string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	balance, err := lockBalance(tx, userID)
+	if err != nil {
+		return err
+	}
+	hold, err := lockHold(tx, userID, holdID)
+	if err != nil {
+		return err
+	}
+	if balance < hold.Amount {
+		return ErrNotEnouthBalance
+	}
+	if _, err = tx.Exec("UPDATE gophermart_holds SET status=$1, updated_at=now() WHERE hold_id = $2", HoldCaptured, holdID); err != nil {
+		return err
+	}
+	if err = insertWithdrawal(tx, userID, hold.Order, hold.Amount); err != nil {
+		return err
+	}
+	if err = s.post(tx, PostingWithdrawal, hold.Order, UserAccount(userID), AccountRedemptions, hold.Amount); err != nil {
+		return err
+	}
+	event := withdrawalEvent{Event: EventWithdrawalCreated, Order: hold.Order, Sum: float32(hold.Amount) / 100, At: time.Now().Format(time.RFC3339)}
+	if err = writeOutbox(tx, userID, EventWithdrawalCreated, event); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// VoidHold releases the points reserved by an active hold.
+func (s *SQLStorage) VoidHold(userID, holdID string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = lockHold(tx, userID, holdID); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("UPDATE gophermart_holds SET status=$1, updated_at=now() WHERE hold_id = $2", HoldVoided, holdID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ExpireHolds marks stale holds as expired. Expired holds stop reserving points as soon as
+// expires_at passes, so this only keeps the stored status accurate.
+func (s *SQLStorage) ExpireHolds(now time.Time) (int, error) {
+	result, err := s.DB.Exec("UPDATE gophermart_holds SET status=$1, updated_at=now() WHERE status = $2 AND expires_at <= $3", HoldExpired, HoldActive, now)
+	if err != nil {
+		return 0, err
+	}
+	changes, _ := result.RowsAffected()
+	return int(changes), nil
+}
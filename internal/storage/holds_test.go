@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptureHoldAfterExpiry(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	credit(t, s, "alice", PostingAccrual, "12345678903", 1500)
+	require.NoError(t, s.CreateHold("alice", "hold-1", "2377225624", 10, time.Now().Add(time.Hour)))
+	assert.ErrorIs(t, s.CreateHold("alice", "hold-2", "79927398713", 6, time.Now().Add(time.Hour)), ErrNotEnouthBalance)
+
+	expireLots(t, s, "alice")
+	expired, err := s.ExpirePoints(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, expired, "the held part keeps the lot open")
+	balance, _ := balanceOf(t, s, "alice")
+	assert.Equal(t, 1000, balance, "only the points not held expire")
+	assert.Equal(t, []int{1000}, lotsOf(t, s, "alice"))
+
+	require.NoError(t, s.CaptureHold("alice", "hold-1"))
+	balance, withdrawn := balanceOf(t, s, "alice")
+	assert.Equal(t, 0, balance)
+	assert.Equal(t, 1000, withdrawn)
+	assert.Equal(t, []int{0}, lotsOf(t, s, "alice"))
+
+	expired, err = s.ExpirePoints(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, expired)
+	assert.Equal(t, 0, ledgerBalance(t, s, UserAccount("alice")))
+}
+
+func TestVoidedHoldExpires(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	credit(t, s, "alice", PostingAccrual, "12345678903", 1000)
+	require.NoError(t, s.CreateHold("alice", "hold-1", "2377225624", 10, time.Now().Add(time.Hour)))
+
+	expireLots(t, s, "alice")
+	_, err := s.ExpirePoints(time.Now())
+	require.NoError(t, err)
+	require.NoError(t, s.VoidHold("alice", "hold-1"))
+
+	expired, err := s.ExpirePoints(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	balance, _ := balanceOf(t, s, "alice")
+	assert.Equal(t, 0, balance)
+	assert.ErrorIs(t, s.CaptureHold("alice", "hold-1"), ErrConflict)
+}
+
+func TestCaptureHoldAfterReversal(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	credit(t, s, "alice", PostingAccrual, "12345678903", 1000)
+	require.NoError(t, s.UserWithdraw("alice", "2377225624", 6))
+	require.NoError(t, s.CreateHold("alice", "hold-1", "79927398713", 4, time.Now().Add(time.Hour)))
+	assert.ErrorIs(t, s.UserWithdraw("alice", "4561261212345467", 1), ErrNotEnouthBalance)
+
+	require.NoError(t, s.ReverseWithdrawal("alice", "2377225624", time.Hour, "user:alice"))
+	require.NoError(t, s.CaptureHold("alice", "hold-1"))
+
+	balance, withdrawn := balanceOf(t, s, "alice")
+	assert.Equal(t, 600, balance)
+	assert.Equal(t, 400, withdrawn)
+	assert.Equal(t, 600, ledgerBalance(t, s, UserAccount("alice")))
+	assert.ErrorIs(t, s.ReverseWithdrawal("alice", "2377225624", time.Hour, "user:alice"), ErrReversed)
+}
+
+func TestCaptureHoldChecksBalance(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	credit(t, s, "alice", PostingAccrual, "12345678903", 1000)
+	require.NoError(t, s.CreateHold("alice", "hold-1", "2377225624", 10, time.Now().Add(time.Hour)))
+
+	// A drifted cached balance below the hold must not be driven negative.
+	_, err := s.DB.Exec("UPDATE gophermart_users SET balance = 100 WHERE user_id = 'alice'")
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.CaptureHold("alice", "hold-1"), ErrNotEnouthBalance)
+	balance, _ := balanceOf(t, s, "alice")
+	assert.Equal(t, 100, balance)
+
+	var status string
+	require.NoError(t, s.DB.QueryRow("SELECT status FROM gophermart_holds WHERE hold_id = 'hold-1'").Scan(&status))
+	assert.Equal(t, HoldActive, status)
+}
+
+func TestWithdrawHeldOrder(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	credit(t, s, "alice", PostingAccrual, "12345678903", 1500)
+	require.NoError(t, s.CreateHold("alice", "hold-1", "2377225624", 5, time.Now().Add(time.Hour)))
+
+	assert.ErrorIs(t, s.UserWithdraw("alice", "2377225624", 5), ErrConflict, "the held order is paid by capturing the hold")
+	require.NoError(t, s.CaptureHold("alice", "hold-1"))
+	assert.ErrorIs(t, s.UserWithdraw("alice", "2377225624", 1), ErrConflict)
+
+	balance, withdrawn := balanceOf(t, s, "alice")
+	assert.Equal(t, 1000, balance)
+	assert.Equal(t, 500, withdrawn)
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyKeyExpiry(t *testing.T) {
+	s := newTestStorage(t)
+	longAgo := time.Now().Add(-time.Hour)
+
+	stored, err := s.ReserveIdempotencyKey("alice", "key", "first", longAgo)
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+	require.NoError(t, s.SaveIdempotentResponse("alice", "key", IdempotentResponse{Status: 200, Body: []byte("ok")}))
+
+	stored, err = s.ReserveIdempotencyKey("alice", "key", "first", longAgo)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, []byte("ok"), stored.Body)
+
+	// An expired key is taken over even before it is pruned.
+	stored, err = s.ReserveIdempotencyKey("alice", "key", "second", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+	_, err = s.ReserveIdempotencyKey("alice", "key", "second", longAgo)
+	assert.ErrorIs(t, err, ErrIdempotencyInFlight)
+
+	require.NoError(t, s.PruneIdempotencyKeys(time.Now().Add(time.Minute)))
+	var left int
+	require.NoError(t, s.DB.QueryRow("SELECT count(*) FROM gophermart_idempotency").Scan(&left))
+	assert.Equal(t, 0, left)
+}
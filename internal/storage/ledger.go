@@ -0,0 +1,444 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Every balance change is a ledger transaction of two postings with opposite amounts:
+// one on the user account and one on a system (or another user) account.
+const (
+	AccountAccruals    = "system:accruals"
+	AccountRedemptions = "system:redemptions"
+	AccountAdjustments = "system:adjustments"
+	AccountExpired     = "system:expired"
+	AccountTierBonuses = "system:tier_bonuses"
+	AccountPromotions  = "system:promotions"
+	AccountReferrals   = "system:referrals"
+
+	userAccountPrefix = "user:"
+)
+
+const (
+	PostingAccrual    = "accrual"
+	PostingWithdrawal = "withdrawal"
+	PostingAdjustment = "adjustment"
+	PostingReversal   = "reversal"
+	PostingExpiry     = "expiry"
+	PostingTierBonus  = "tier_bonus"
+	PostingTransfer   = "transfer"
+	PostingPromo      = "promo"
+	PostingReferral   = "referral"
+)
+
+// ledgerWithdrawnExpr derives the withdrawn total of a user account from its postings.
+const ledgerWithdrawnExpr = "COALESCE(SUM(CASE WHEN kind IN ('withdrawal', 'reversal') THEN -amount ELSE 0 END), 0)"
+
+func UserAccount(userID string) string {
+	return userAccountPrefix + userID
+}
+
+func createLedger(db *sql.DB) error {
+	_, err := db.Exec("CREATE SEQUENCE IF NOT EXISTS gophermart_ledger_tx_seq;")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_ledger(id bigserial PRIMARY KEY, tx_id bigint NOT NULL, account text NOT NULL, kind text NOT NULL, amount bigint NOT NULL, reference text DEFAULT '', created_at timestamptz DEFAULT now());")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_ledger_account_idx ON gophermart_ledger(account, created_at);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE OR REPLACE FUNCTION gophermart_ledger_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'gophermart_ledger is append-only';
+		END;
+		$$ LANGUAGE plpgsql;`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DROP TRIGGER IF EXISTS gophermart_ledger_append_only ON gophermart_ledger;")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TRIGGER gophermart_ledger_append_only BEFORE UPDATE OR DELETE ON gophermart_ledger FOR EACH ROW EXECUTE FUNCTION gophermart_ledger_append_only();")
+	if err != nil {
+		return err
+	}
+	return backfillLedger(db)
+}
+
+// backfillLedger converts accruals and withdrawals recorded before the ledger existed into postings.
+func backfillLedger(db *sql.DB) error {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM gophermart_ledger)").Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`WITH src AS (
+			SELECT nextval('gophermart_ledger_tx_seq') AS tx_id, user_id, order_no, accrual, COALESCE(processed_at, date)::timestamptz AS at
+			FROM gophermart_orders WHERE status = 'PROCESSED' AND accrual > 0
+		)
+		INSERT INTO gophermart_ledger(tx_id, account, kind, amount, reference, created_at)
+		SELECT tx_id, 'user:' || user_id, 'accrual', accrual, order_no, at FROM src
+		UNION ALL
+		SELECT tx_id, $1, 'accrual', -accrual, order_no, at FROM src`, AccountAccruals)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`WITH src AS (
+			SELECT nextval('gophermart_ledger_tx_seq') AS tx_id, user_id, order_no, sum, date::timestamptz AS at
+			FROM gophermart_withdraws
+		)
+		INSERT INTO gophermart_ledger(tx_id, account, kind, amount, reference, created_at)
+		SELECT tx_id, 'user:' || user_id, 'withdrawal', -sum, order_no, at FROM src
+		UNION ALL
+		SELECT tx_id, $1, 'withdrawal', sum, order_no, at FROM src`, AccountRedemptions)
+	if err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Info().Msg("ledger backfilled from orders and withdrawals")
+	return nil
+}
+
+// post moves amount from one account to another inside tx and keeps the cached user balances
+// and the accrual lots in step.
+func (s *SQLStorage) post(tx *sql.Tx, kind, reference, from, to string, amount int) error {
+	var txID int64
+	if err := tx.QueryRow("SELECT nextval('gophermart_ledger_tx_seq')").Scan(&txID); err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err := tx.Exec("INSERT INTO gophermart_ledger(tx_id, account, kind, amount, reference, created_at) VALUES($1, $2, $3, $4, $5, $6), ($1, $7, $3, $8, $5, $6)",
+		txID, from, kind, -amount, reference, now, to, amount)
+	if err != nil {
+		return err
+	}
+	if err = countPosting(tx, kind, from, -amount); err != nil {
+		return err
+	}
+	if err = countPosting(tx, kind, to, amount); err != nil {
+		return err
+	}
+	// Lots taken from a user account, when the points go to another user they keep their expiry.
+	// A reversed withdrawal gives back the lots it took.
+	var moved []lotShare
+	if kind == PostingReversal {
+		if moved, err = withdrawnLots(tx, reference); err != nil {
+			return err
+		}
+	}
+	for _, change := range []struct {
+		account string
+		amount  int
+	}{{from, -amount}, {to, amount}} {
+		if !strings.HasPrefix(change.account, userAccountPrefix) {
+			continue
+		}
+		userID := strings.TrimPrefix(change.account, userAccountPrefix)
+		withdrawn := 0
+		if kind == PostingWithdrawal || kind == PostingReversal {
+			withdrawn = -change.amount
+		}
+		var balance int
+		err = tx.QueryRow("UPDATE gophermart_users SET balance = balance + $1, withdrawn = withdrawn + $2 WHERE user_id = $3 RETURNING balance",
+			change.amount, withdrawn, userID).Scan(&balance)
+		if err != nil {
+			return err
+		}
+		if err = auditBalance(tx, kind, reference, userID, balance, change.amount); err != nil {
+			return err
+		}
+		event := balanceEvent{Event: EventBalanceChanged, Kind: kind, Reference: reference, Amount: float32(change.amount) / 100, Balance: float32(balance) / 100, At: now.Format(time.RFC3339)}
+		if err = writeOutbox(tx, userID, EventBalanceChanged, event); err != nil {
+			return err
+		}
+		switch {
+		case kind == PostingExpiry:
+		case change.amount > 0 && moved != nil:
+			err = moveLots(tx, userID, kind, reference, change.amount, moved, now)
+		case change.amount > 0:
+			err = s.addLot(tx, userID, kind, reference, change.amount, now)
+		default:
+			moved, err = consumeLots(tx, userID, -change.amount)
+			if err == nil && kind == PostingWithdrawal {
+				err = recordWithdrawnLots(tx, reference, moved)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockBalance locks the user row until the end of tx and returns the cached balance.
+func lockBalance(tx *sql.Tx, userID string) (int, error) {
+	var balance int
+	err := tx.QueryRow("SELECT balance FROM gophermart_users WHERE user_id = $1 FOR UPDATE", userID).Scan(&balance)
+	if err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+func (s *SQLStorage) UserWithdraw(userID, order string, sum float32) error {
+	amount := int(sum * 100)
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	balance, err := lockBalance(tx, userID)
+	if err != nil {
+		return err
+	}
+	held, err := heldAmount(tx, userID)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("UserBalance: %d, held: %d, want to witdraw: %d", balance, held, amount)
+	if balance-held < amount {
+		return ErrNotEnouthBalance
+	}
+	// An order held for payment is paid by capturing the hold.
+	if err = checkOrderFree(tx, order); err != nil {
+		return err
+	}
+	if err = insertWithdrawal(tx, userID, order, amount); err != nil {
+		return err
+	}
+	if err = s.post(tx, PostingWithdrawal, order, UserAccount(userID), AccountRedemptions, amount); err != nil {
+		return err
+	}
+	event := withdrawalEvent{Event: EventWithdrawalCreated, Order: order, Sum: float32(amount) / 100, At: time.Now().Format(time.RFC3339)}
+	if err = writeOutbox(tx, userID, EventWithdrawalCreated, event); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// updateOrder moves the order to a status other than PROCESSED unless it is processed already.
+func updateOrder(tx *sql.Tx, order, status string) error {
+	var userID string
+	err := tx.QueryRow("UPDATE gophermart_orders SET status=$1 WHERE order_no=$2 AND status <> $1 AND status <> 'PROCESSED' RETURNING user_id", status, order).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	kind := EventOrderUpdated
+	if status == "INVALID" {
+		kind = EventOrderInvalid
+	}
+	event := orderEvent{Event: kind, Order: order, Status: status, At: time.Now().Format(time.RFC3339)}
+	return writeOutbox(tx, userID, kind, event)
+}
+
+// ReverseWithdrawal credits a withdrawal back to the user. An empty userID skips the owner check and
+// a zero window skips the age check, as done for admin reversals.
+func (s *SQLStorage) ReverseWithdrawal(userID, order string, window time.Duration, actor string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var owner, date string
+	var sum int
+	var reversedAt sql.NullString
+	err = tx.QueryRow("SELECT user_id, sum, date, reversed_at FROM gophermart_withdraws WHERE order_no = $1 FOR UPDATE", order).Scan(&owner, &sum, &date, &reversedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if userID != "" && owner != userID {
+		return ErrNotFound
+	}
+	if reversedAt.Valid {
+		return ErrReversed
+	}
+	if window > 0 {
+		processedAt, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			return err
+		}
+		if time.Since(processedAt) > window {
+			return ErrGone
+		}
+	}
+
+	if _, err = lockBalance(tx, owner); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE gophermart_withdraws SET reversed_at=$1, reversed_by=$2 WHERE order_no = $3", time.Now().Format(time.RFC3339), actor, order)
+	if err != nil {
+		return err
+	}
+	if err = s.post(tx, PostingReversal, order, AccountRedemptions, UserAccount(owner), sum); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStorage) UpdateOrderStatus(accResult AccuralResult) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = s.setOrderStatus(tx, accResult); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setOrderStatus applies the accrual result to the order, crediting the accrual when it moves to PROCESSED.
+// Orders processed earlier are left as they are.
+func (s *SQLStorage) setOrderStatus(tx *sql.Tx, accResult AccuralResult) error {
+	if accResult.Status != "PROCESSED" {
+		return updateOrder(tx, accResult.Order, accResult.Status)
+	}
+
+	amount := int(accResult.Accrual * 100)
+	result, err := tx.Exec("UPDATE gophermart_orders SET status='PROCESSED', accrual=$1, processed_at=$2 WHERE order_no=$3 AND status <> 'PROCESSED'",
+		amount, time.Now().Format(time.RFC3339), accResult.Order)
+	if err != nil {
+		return err
+	}
+	changes, _ := result.RowsAffected()
+	if changes == 0 {
+		return nil
+	}
+	event := orderEvent{Event: EventOrderProcessed, Order: accResult.Order, Status: "PROCESSED", Accrual: float32(amount) / 100, At: time.Now().Format(time.RFC3339)}
+	if err = writeOutbox(tx, accResult.UserID, EventOrderProcessed, event); err != nil {
+		return err
+	}
+	if amount > 0 {
+		if err = s.post(tx, PostingAccrual, accResult.Order, AccountAccruals, UserAccount(accResult.UserID), amount); err != nil {
+			return err
+		}
+		var multiplier float64
+		err = tx.QueryRow("SELECT tier_multiplier FROM gophermart_users WHERE user_id = $1", accResult.UserID).Scan(&multiplier)
+		if err != nil {
+			return err
+		}
+		if bonus := int(math.Round(float64(amount) * (multiplier - 1))); bonus > 0 {
+			if err = s.post(tx, PostingTierBonus, accResult.Order, AccountTierBonuses, UserAccount(accResult.UserID), bonus); err != nil {
+				return err
+			}
+		}
+		if err = s.applyPromoBoosts(tx, accResult.UserID, accResult.Order, amount); err != nil {
+			return err
+		}
+		if err = s.rewardReferrer(tx, accResult.UserID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UserStatement streams the user ledger postings between from and to in chronological order with a running balance.
+func (s *SQLStorage) UserStatement(userID string, from, to time.Time, fn func(StatementEntry) error) error {
+	account := UserAccount(userID)
+	var balance int
+	err := s.DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM gophermart_ledger WHERE account = $1 AND created_at < $2", account, from).Scan(&balance)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.DB.Query("SELECT kind, reference, amount, created_at FROM gophermart_ledger WHERE account = $1 AND created_at >= $2 AND created_at < $3 ORDER BY created_at, id", account, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry StatementEntry
+		if err = rows.Scan(&entry.Kind, &entry.Reference, &entry.Amount, &entry.At); err != nil {
+			return err
+		}
+		balance += entry.Amount
+		entry.Balance = balance
+		if err = fn(entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ReconcileBalances recomputes every user balance from the ledger and returns the users whose cached
+// balance drifted. With fix set the cached values are overwritten by the ledger ones.
+func (s *SQLStorage) ReconcileBalances(fix bool) ([]BalanceDrift, error) {
+	rows, err := s.DB.Query(`SELECT u.user_id, u.balance, u.withdrawn, COALESCE(l.balance, 0), COALESCE(l.withdrawn, 0)
+		FROM gophermart_users u LEFT JOIN (
+			SELECT account, SUM(amount) AS balance, ` + ledgerWithdrawnExpr + ` AS withdrawn
+			FROM gophermart_ledger WHERE account LIKE 'user:%' GROUP BY account
+		) l ON l.account = 'user:' || u.user_id
+		WHERE u.balance <> COALESCE(l.balance, 0) OR u.withdrawn <> COALESCE(l.withdrawn, 0)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	drifts := make([]BalanceDrift, 0)
+	for rows.Next() {
+		var drift BalanceDrift
+		if err = rows.Scan(&drift.UserID, &drift.CachedBalance, &drift.CachedWithdrawn, &drift.LedgerBalance, &drift.LedgerWithdrawn); err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, drift)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if !fix {
+		return drifts, nil
+	}
+	for _, drift := range drifts {
+		if err = s.rebuildBalance(drift.UserID); err != nil {
+			return drifts, err
+		}
+	}
+	return drifts, nil
+}
+
+// rebuildBalance overwrites the cached balance with the ledger totals while the user row is locked.
+func (s *SQLStorage) rebuildBalance(userID string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = lockBalance(tx, userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE gophermart_users SET
+		balance = (SELECT COALESCE(SUM(amount), 0) FROM gophermart_ledger WHERE account = $1),
+		withdrawn = (SELECT `+ledgerWithdrawnExpr+` FROM gophermart_ledger WHERE account = $1)
+		WHERE user_id = $2`, UserAccount(userID), userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
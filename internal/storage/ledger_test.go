@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostKeepsBalancesInStep(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	credit(t, s, "alice", PostingAccrual, "12345678903", 1000)
+
+	require.NoError(t, s.UserWithdraw("alice", "2377225624", 3.5))
+	assert.ErrorIs(t, s.UserWithdraw("alice", "79927398713", 10), ErrNotEnouthBalance)
+
+	balance, withdrawn := balanceOf(t, s, "alice")
+	assert.Equal(t, 650, balance)
+	assert.Equal(t, 350, withdrawn)
+	assert.Equal(t, 650, ledgerBalance(t, s, UserAccount("alice")))
+	assert.Equal(t, 350, ledgerBalance(t, s, AccountRedemptions))
+	assert.Equal(t, -1000, ledgerBalance(t, s, AccountAccruals))
+	assert.Equal(t, []int{650}, lotsOf(t, s, "alice"))
+
+	var total, unbalanced int
+	require.NoError(t, s.DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM gophermart_ledger").Scan(&total))
+	require.NoError(t, s.DB.QueryRow("SELECT COUNT(*) FROM (SELECT tx_id FROM gophermart_ledger GROUP BY tx_id HAVING SUM(amount) <> 0) t").Scan(&unbalanced))
+	assert.Equal(t, 0, total)
+	assert.Equal(t, 0, unbalanced, "every ledger transaction sums to zero")
+
+	_, err := s.DB.Exec("DELETE FROM gophermart_ledger")
+	assert.Error(t, err, "the ledger is append-only")
+}
+
+func TestReconcileBalances(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	addTestUser(t, s, "bob")
+	credit(t, s, "alice", PostingAccrual, "12345678903", 1000)
+	credit(t, s, "bob", PostingAccrual, "2377225624", 500)
+
+	drifts, err := s.ReconcileBalances(false)
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	_, err = s.DB.Exec("UPDATE gophermart_users SET balance = 1, withdrawn = 7 WHERE user_id = 'alice'")
+	require.NoError(t, err)
+	drifts, err = s.ReconcileBalances(false)
+	require.NoError(t, err)
+	require.Len(t, drifts, 1)
+	assert.Equal(t, BalanceDrift{UserID: "alice", CachedBalance: 1, CachedWithdrawn: 7, LedgerBalance: 1000, LedgerWithdrawn: 0}, drifts[0])
+
+	drifts, err = s.ReconcileBalances(true)
+	require.NoError(t, err)
+	assert.Len(t, drifts, 1)
+	balance, withdrawn := balanceOf(t, s, "alice")
+	assert.Equal(t, 1000, balance)
+	assert.Equal(t, 0, withdrawn)
+
+	drifts, err = s.ReconcileBalances(false)
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestReverseWithdrawal(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	addTestUser(t, s, "bob")
+	credit(t, s, "alice", PostingAccrual, "12345678903", 1000)
+	require.NoError(t, s.UserWithdraw("alice", "2377225624", 4))
+	require.NoError(t, s.UserWithdraw("alice", "79927398713", 1))
+
+	assert.ErrorIs(t, s.ReverseWithdrawal("bob", "2377225624", time.Hour, "user:bob"), ErrNotFound)
+	assert.ErrorIs(t, s.ReverseWithdrawal("alice", "4561261212345467", time.Hour, "user:alice"), ErrNotFound)
+	require.NoError(t, s.ReverseWithdrawal("alice", "2377225624", time.Hour, "user:alice"))
+	assert.ErrorIs(t, s.ReverseWithdrawal("alice", "2377225624", time.Hour, "user:alice"), ErrReversed)
+
+	balance, withdrawn := balanceOf(t, s, "alice")
+	assert.Equal(t, 900, balance)
+	assert.Equal(t, 100, withdrawn)
+	assert.Equal(t, 100, ledgerBalance(t, s, AccountRedemptions))
+
+	// Users may only cancel inside the window, admins reverse without the window and owner checks.
+	_, err := s.DB.Exec("UPDATE gophermart_withdraws SET date = $1 WHERE order_no = '79927398713'", time.Now().Add(-2*time.Hour).Format(time.RFC3339))
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.ReverseWithdrawal("alice", "79927398713", time.Hour, "user:alice"), ErrGone)
+	require.NoError(t, s.ReverseWithdrawal("", "79927398713", 0, "admin:root"))
+
+	balance, withdrawn = balanceOf(t, s, "alice")
+	assert.Equal(t, 1000, balance)
+	assert.Equal(t, 0, withdrawn)
+	var reversedBy string
+	require.NoError(t, s.DB.QueryRow("SELECT reversed_by FROM gophermart_withdraws WHERE order_no = '79927398713'").Scan(&reversedBy))
+	assert.Equal(t, "admin:root", reversedBy)
+}
+
+func TestReverseWithdrawalKeepsLotExpiry(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	credit(t, s, "alice", PostingAccrual, "12345678903", 1000)
+	var expiresAt time.Time
+	require.NoError(t, s.DB.QueryRow("SELECT expires_at FROM gophermart_lots WHERE user_id = 'alice'").Scan(&expiresAt))
+
+	require.NoError(t, s.UserWithdraw("alice", "2377225624", 4))
+	require.NoError(t, s.ReverseWithdrawal("alice", "2377225624", time.Hour, "user:alice"))
+
+	assert.Equal(t, []int{600, 400}, lotsOf(t, s, "alice"))
+	var restored time.Time
+	require.NoError(t, s.DB.QueryRow("SELECT expires_at FROM gophermart_lots WHERE user_id = 'alice' AND kind = $1", PostingReversal).Scan(&restored))
+	assert.True(t, expiresAt.Equal(restored), "the reversed points expire with the lot they came from")
+}
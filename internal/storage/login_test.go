@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserveLoginAttemptKeepsCountAfterLock(t *testing.T) {
+	s := newTestStorage(t)
+	limits := LoginLimits{MaxFailures: 2, Lockout: 300 * time.Millisecond}
+
+	for i := 0; i < 2; i++ {
+		_, err := s.ReserveLoginAttempt("login:gopher", limits)
+		require.NoError(t, err)
+	}
+	failures, err := s.ReserveLoginAttempt("login:gopher", limits)
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.True(t, failures.JustLocked)
+	failures, err = s.ReserveLoginAttempt("login:gopher", limits)
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.False(t, failures.JustLocked)
+
+	// Once the lock runs out there is a single attempt before the next lock.
+	time.Sleep(limits.Lockout + 50*time.Millisecond)
+	_, err = s.ReserveLoginAttempt("login:gopher", limits)
+	require.NoError(t, err)
+	failures, err = s.ReserveLoginAttempt("login:gopher", limits)
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.True(t, failures.JustLocked)
+
+	require.NoError(t, s.ResetLoginFailures("login:gopher"))
+	failures, err = s.ReserveLoginAttempt("login:gopher", limits)
+	require.NoError(t, err)
+	assert.Equal(t, 1, failures.Failures)
+	require.NoError(t, s.ReleaseLoginAttempt("login:gopher"))
+	failures, err = s.ReserveLoginAttempt("login:gopher", limits)
+	require.NoError(t, err)
+	assert.Equal(t, 1, failures.Failures, "a released attempt does not count")
+}
+
+func TestReserveLoginAttemptDelay(t *testing.T) {
+	s := newTestStorage(t)
+	limits := LoginLimits{MaxFailures: 10, Lockout: time.Minute, Delay: func(failures int) time.Duration {
+		if failures < 1 {
+			return 0
+		}
+		return time.Minute
+	}}
+
+	_, err := s.ReserveLoginAttempt("login:gopher", limits)
+	require.NoError(t, err)
+	failures, err := s.ReserveLoginAttempt("login:gopher", limits)
+	assert.ErrorIs(t, err, ErrLoginDelayed)
+	assert.Equal(t, 1, failures.Failures, "a delayed attempt is not counted")
+}
@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// expiringKinds lists the postings whose credits expire after the configured number of months.
+var expiringKinds = map[string]bool{
+	PostingAccrual:   true,
+	PostingTierBonus: true,
+	PostingPromo:     true,
+	PostingReferral:  true,
+}
+
+func createLots(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS gophermart_lots(id bigserial PRIMARY KEY, user_id text NOT NULL, kind text NOT NULL, reference text DEFAULT '', amount bigint NOT NULL, remaining bigint NOT NULL, accrued_at timestamptz NOT NULL, expires_at timestamptz);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_lots_user_idx ON gophermart_lots(user_id, accrued_at) WHERE remaining > 0;")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_lots_expires_idx ON gophermart_lots(expires_at) WHERE remaining > 0;")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_withdrawn_lots(order_no text NOT NULL, amount bigint NOT NULL, expires_at timestamptz);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_withdrawn_lots_order_idx ON gophermart_withdrawn_lots(order_no);")
+	if err != nil {
+		return err
+	}
+	// Balances collected before lots were tracked become one opening lot that never expires.
+	var exists bool
+	if err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM gophermart_lots)").Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	_, err = db.Exec("INSERT INTO gophermart_lots(user_id, kind, reference, amount, remaining, accrued_at) SELECT user_id, 'opening', '', balance, balance, now() FROM gophermart_users WHERE balance > 0")
+	return err
+}
+
+func (s *SQLStorage) addLot(tx *sql.Tx, userID, kind, reference string, amount int, now time.Time) error {
+	var expiresAt sql.NullTime
+	if s.pointsExpiry > 0 && expiringKinds[kind] {
+		expiresAt = sql.NullTime{Time: now.AddDate(0, s.pointsExpiry, 0), Valid: true}
+	}
+	return insertLot(tx, userID, kind, reference, amount, now, expiresAt)
+}
+
+func insertLot(tx *sql.Tx, userID, kind, reference string, amount int, now time.Time, expiresAt sql.NullTime) error {
+	_, err := tx.Exec("INSERT INTO gophermart_lots(user_id, kind, reference, amount, remaining, accrued_at, expires_at) VALUES($1, $2, $3, $4, $4, $5, $6)",
+		userID, kind, reference, amount, now, expiresAt)
+	return err
+}
+
+// lotShare is the part of a lot taken by consumeLots.
+type lotShare struct {
+	amount    int
+	expiresAt sql.NullTime
+}
+
+// moveLots credits points taken from another user as lots that keep the expiry of the shares,
+// so sending points to a second account does not make expiring points permanent.
+func moveLots(tx *sql.Tx, userID, kind, reference string, amount int, shares []lotShare, now time.Time) error {
+	merged := make([]lotShare, 0, len(shares)+1)
+	for _, share := range shares {
+		last := len(merged) - 1
+		if last >= 0 && merged[last].expiresAt.Valid == share.expiresAt.Valid && merged[last].expiresAt.Time.Equal(share.expiresAt.Time) {
+			merged[last].amount += share.amount
+			continue
+		}
+		merged = append(merged, share)
+	}
+	for _, share := range merged {
+		amount -= share.amount
+	}
+	// Points the sender had no lots for are credited like before lots were tracked.
+	if amount > 0 {
+		merged = append(merged, lotShare{amount: amount})
+	}
+	for _, share := range merged {
+		if err := insertLot(tx, userID, kind, reference, share.amount, now, share.expiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordWithdrawnLots keeps the shares a withdrawal took, so a reversal can give them back
+// with their expiry.
+func recordWithdrawnLots(tx *sql.Tx, order string, shares []lotShare) error {
+	for _, share := range shares {
+		_, err := tx.Exec("INSERT INTO gophermart_withdrawn_lots(order_no, amount, expires_at) VALUES($1, $2, $3)", order, share.amount, share.expiresAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withdrawnLots returns the shares the withdrawal of the order took. Withdrawals made before they
+// were recorded have none, their points come back as a lot that does not expire.
+func withdrawnLots(tx *sql.Tx, order string) ([]lotShare, error) {
+	rows, err := tx.Query("SELECT amount, expires_at FROM gophermart_withdrawn_lots WHERE order_no = $1", order)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	shares := make([]lotShare, 0)
+	for rows.Next() {
+		var share lotShare
+		if err = rows.Scan(&share.amount, &share.expiresAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// consumeLots takes amount from the oldest lots first and returns the shares taken.
+func consumeLots(tx *sql.Tx, userID string, amount int) ([]lotShare, error) {
+	rows, err := tx.Query("SELECT id, remaining, expires_at FROM gophermart_lots WHERE user_id = $1 AND remaining > 0 ORDER BY accrued_at, id FOR UPDATE", userID)
+	if err != nil {
+		return nil, err
+	}
+	type lot struct {
+		id        int64
+		remaining int
+		expiresAt sql.NullTime
+	}
+	lots := make([]lot, 0)
+	for rows.Next() {
+		var l lot
+		if err = rows.Scan(&l.id, &l.remaining, &l.expiresAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		lots = append(lots, l)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	shares := make([]lotShare, 0, len(lots))
+	for _, l := range lots {
+		if amount == 0 {
+			break
+		}
+		take := l.remaining
+		if take > amount {
+			take = amount
+		}
+		if _, err = tx.Exec("UPDATE gophermart_lots SET remaining = remaining - $1 WHERE id = $2", take, l.id); err != nil {
+			return nil, err
+		}
+		shares = append(shares, lotShare{amount: take, expiresAt: l.expiresAt})
+		amount -= take
+	}
+	if amount > 0 {
+		log.Warn().Msgf("consumeLots user %s: lots are short by %d", userID, amount)
+	}
+	return shares, nil
+}
+
+func (s *SQLStorage) expiringLots(userID string) ([]expiringLots, error) {
+	if s.pointsExpiry == 0 {
+		return nil, nil
+	}
+	rows, err := s.DB.Query("SELECT remaining, expires_at FROM gophermart_lots WHERE user_id = $1 AND remaining > 0 AND expires_at <= $2 ORDER BY expires_at", userID, time.Now().Add(s.expiringSoon))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lots := make([]expiringLots, 0)
+	for rows.Next() {
+		var remaining int
+		var expiresAt time.Time
+		if err = rows.Scan(&remaining, &expiresAt); err != nil {
+			return nil, err
+		}
+		lots = append(lots, expiringLots{Amount: float32(remaining) / 100, ExpiresAt: expiresAt.Format(time.RFC3339)})
+	}
+	return lots, rows.Err()
+}
+
+// ExpirePoints writes expiry postings for the lots expired by now and returns how many lots were expired.
+// Points of an expired lot reserved by active holds stay in the lot until the holds are settled.
+func (s *SQLStorage) ExpirePoints(now time.Time) (int, error) {
+	type lot struct {
+		id     int64
+		userID string
+	}
+	rows, err := s.DB.Query("SELECT id, user_id FROM gophermart_lots WHERE remaining > 0 AND expires_at <= $1 ORDER BY expires_at LIMIT 500", now)
+	if err != nil {
+		return 0, err
+	}
+	lots := make([]lot, 0)
+	for rows.Next() {
+		var l lot
+		if err = rows.Scan(&l.id, &l.userID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		lots = append(lots, l)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, l := range lots {
+		closed, err := s.expireLot(l.id, l.userID)
+		if err != nil {
+			return expired, err
+		}
+		if closed {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// expireLot writes off the lot and reports whether it is closed. No more than the available balance is
+// written off, so a capture of an active hold can not drive the balance negative afterwards.
+func (s *SQLStorage) expireLot(id int64, userID string) (bool, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	balance, err := lockBalance(tx, userID)
+	if err != nil {
+		return false, err
+	}
+	held, err := heldAmount(tx, userID)
+	if err != nil {
+		return false, err
+	}
+	var remaining int
+	if err = tx.QueryRow("SELECT remaining FROM gophermart_lots WHERE id = $1 FOR UPDATE", id).Scan(&remaining); err != nil {
+		return false, err
+	}
+	// Lots exceeding the balance are dropped, the part covering holds is kept for the next run.
+	expire := remaining
+	if expire > balance {
+		expire = balance
+	}
+	keep := 0
+	if available := balance - held; expire > available {
+		if available < 0 {
+			available = 0
+		}
+		keep = expire - available
+		expire = available
+	}
+	if _, err = tx.Exec("UPDATE gophermart_lots SET remaining = $1 WHERE id = $2", keep, id); err != nil {
+		return false, err
+	}
+	if expire > 0 {
+		if err = s.post(tx, PostingExpiry, "lot:"+strconv.FormatInt(id, 10), UserAccount(userID), AccountExpired, expire); err != nil {
+			return false, err
+		}
+	}
+	return keep == 0, tx.Commit()
+}
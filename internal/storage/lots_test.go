@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expireLots moves the expiry of the user's open lots into the past.
+func expireLots(t *testing.T, s *SQLStorage, userID string) {
+	t.Helper()
+	_, err := s.DB.Exec("UPDATE gophermart_lots SET expires_at = now() - interval '1 day' WHERE user_id = $1 AND remaining > 0 AND expires_at IS NOT NULL", userID)
+	require.NoError(t, err)
+}
+
+func TestConsumeLotsOldestFirst(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	credit(t, s, "alice", PostingAccrual, "12345678903", 300)
+	credit(t, s, "alice", PostingAccrual, "2377225624", 500)
+
+	require.NoError(t, s.UserWithdraw("alice", "79927398713", 4))
+	assert.Equal(t, []int{0, 400}, lotsOf(t, s, "alice"))
+
+	require.NoError(t, s.UserWithdraw("alice", "4561261212345467", 1.5))
+	assert.Equal(t, []int{0, 250}, lotsOf(t, s, "alice"))
+}
+
+func TestExpirePoints(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	credit(t, s, "alice", PostingAccrual, "12345678903", 300)
+	credit(t, s, "alice", PostingAdjustment, "manual", 200)
+	require.NoError(t, s.UserWithdraw("alice", "79927398713", 1))
+
+	var neverExpires bool
+	require.NoError(t, s.DB.QueryRow("SELECT expires_at IS NULL FROM gophermart_lots WHERE kind = $1", PostingAdjustment).Scan(&neverExpires))
+	assert.True(t, neverExpires, "adjustments do not expire")
+
+	expired, err := s.ExpirePoints(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, expired)
+
+	expireLots(t, s, "alice")
+	expired, err = s.ExpirePoints(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+
+	balance, withdrawn := balanceOf(t, s, "alice")
+	assert.Equal(t, 200, balance, "only the accrual left after the withdrawal expires")
+	assert.Equal(t, 100, withdrawn)
+	assert.Equal(t, 200, ledgerBalance(t, s, UserAccount("alice")))
+	assert.Equal(t, 200, ledgerBalance(t, s, AccountExpired))
+	assert.Equal(t, []int{0, 200}, lotsOf(t, s, "alice"))
+
+	expired, err = s.ExpirePoints(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, expired, "expired lots are written off once")
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"database/sql"
+
+	"github.com/rs/zerolog/log"
+)
+
+// createMigrations keeps the names of the one-time data migrations already applied, the schema
+// itself is created idempotently on every start.
+func createMigrations(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS gophermart_migrations(name text PRIMARY KEY, applied_at timestamptz NOT NULL DEFAULT now());")
+	return err
+}
+
+// migrateOnce runs migrate unless the migration of the name was applied before. The name is
+// claimed in the same transaction, so instances starting together wait for the first one and
+// skip it.
+func migrateOnce(db *sql.DB, name string, migrate func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("INSERT INTO gophermart_migrations(name) VALUES($1) ON CONFLICT DO NOTHING", name)
+	if err != nil {
+		return err
+	}
+	changes, _ := result.RowsAffected()
+	if changes == 0 {
+		return nil
+	}
+	if err = migrate(tx); err != nil {
+		return err
+	}
+	log.Info().Msgf("storage migration %s applied", name)
+	return tx.Commit()
+}
@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddNewOrders(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	addTestUser(t, s, "bob")
+	require.NoError(t, s.AddNewOrder("bob", "2377225624"))
+
+	results, err := s.AddNewOrders("alice", []string{"12345678903", "2377225624", "12345678903", "79927398713"})
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	assert.NoError(t, results[0])
+	assert.ErrorIs(t, results[1], ErrAnotherUserUploaded)
+	assert.ErrorIs(t, results[2], ErrUploaded, "a duplicate inside the batch is reported as uploaded")
+	assert.NoError(t, results[3])
+
+	results, err = s.AddNewOrders("alice", []string{"79927398713"})
+	require.NoError(t, err)
+	assert.ErrorIs(t, results[0], ErrUploaded)
+
+	var count int
+	require.NoError(t, s.DB.QueryRow("SELECT COUNT(*) FROM gophermart_orders WHERE user_id = 'alice'").Scan(&count))
+	assert.Equal(t, 2, count)
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	PromoFixed = "fixed"
+	PromoBoost = "boost"
+)
+
+func createPromo(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS gophermart_promo_codes(code text UNIQUE, kind text NOT NULL, bonus bigint DEFAULT 0, percent double precision DEFAULT 0, orders integer DEFAULT 0, max_uses integer DEFAULT 0, uses integer DEFAULT 0, valid_from timestamptz, valid_to timestamptz, created_by text DEFAULT '', created_at timestamptz DEFAULT now());")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_promo_redemptions(code text NOT NULL, user_id text NOT NULL, percent double precision DEFAULT 0, remaining_orders integer DEFAULT 0, redeemed_at timestamptz DEFAULT now(), UNIQUE(code, user_id));")
+	return err
+}
+
+func (s *SQLStorage) AddPromoCode(promo PromoCode) error {
+	var validFrom, validTo sql.NullTime
+	if !promo.ValidFrom.IsZero() {
+		validFrom = sql.NullTime{Time: promo.ValidFrom, Valid: true}
+	}
+	if !promo.ValidTo.IsZero() {
+		validTo = sql.NullTime{Time: promo.ValidTo, Valid: true}
+	}
+	result, err := s.DB.Exec("INSERT INTO gophermart_promo_codes(code, kind, bonus, percent, orders, max_uses, valid_from, valid_to, created_by) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT DO NOTHING",
+		promo.Code, promo.Kind, promo.Bonus, promo.Percent, promo.Orders, promo.MaxUses, validFrom, validTo, promo.CreatedBy)
+	if err != nil {
+		return err
+	}
+	changes, _ := result.RowsAffected()
+	if changes == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// RedeemPromoCode applies the code for the user once: fixed codes are credited at once,
+// boost codes raise the accrual of the next orders.
+func (s *SQLStorage) RedeemPromoCode(userID, code string) (PromoCode, error) {
+	var promo PromoCode
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return promo, err
+	}
+	defer tx.Rollback()
+
+	var validFrom, validTo sql.NullTime
+	var uses int
+	err = tx.QueryRow("SELECT code, kind, bonus, percent, orders, max_uses, uses, valid_from, valid_to FROM gophermart_promo_codes WHERE code = $1 FOR UPDATE", code).
+		Scan(&promo.Code, &promo.Kind, &promo.Bonus, &promo.Percent, &promo.Orders, &promo.MaxUses, &uses, &validFrom, &validTo)
+	if errors.Is(err, sql.ErrNoRows) {
+		return promo, ErrNotFound
+	}
+	if err != nil {
+		return promo, err
+	}
+	now := time.Now()
+	if (validFrom.Valid && now.Before(validFrom.Time)) || (validTo.Valid && now.After(validTo.Time)) {
+		return promo, ErrGone
+	}
+	if promo.MaxUses > 0 && uses >= promo.MaxUses {
+		return promo, ErrGone
+	}
+
+	remaining := 0
+	if promo.Kind == PromoBoost {
+		remaining = promo.Orders
+	}
+	result, err := tx.Exec("INSERT INTO gophermart_promo_redemptions(code, user_id, percent, remaining_orders) VALUES($1, $2, $3, $4) ON CONFLICT DO NOTHING", code, userID, promo.Percent, remaining)
+	if err != nil {
+		return promo, err
+	}
+	changes, _ := result.RowsAffected()
+	if changes == 0 {
+		return promo, ErrConflict
+	}
+	if _, err = tx.Exec("UPDATE gophermart_promo_codes SET uses = uses + 1 WHERE code = $1", code); err != nil {
+		return promo, err
+	}
+	if promo.Kind == PromoFixed && promo.Bonus > 0 {
+		if _, err = lockBalance(tx, userID); err != nil {
+			return promo, err
+		}
+		if err = s.post(tx, PostingPromo, code, AccountPromotions, UserAccount(userID), promo.Bonus); err != nil {
+			return promo, err
+		}
+	}
+	return promo, tx.Commit()
+}
+
+// applyPromoBoosts credits boost codes redeemed by the user for a processed order.
+func (s *SQLStorage) applyPromoBoosts(tx *sql.Tx, userID, order string, amount int) error {
+	rows, err := tx.Query("SELECT code, percent FROM gophermart_promo_redemptions WHERE user_id = $1 AND remaining_orders > 0 ORDER BY redeemed_at FOR UPDATE", userID)
+	if err != nil {
+		return err
+	}
+	type boost struct {
+		code    string
+		percent float64
+	}
+	boosts := make([]boost, 0)
+	for rows.Next() {
+		var b boost
+		if err = rows.Scan(&b.code, &b.percent); err != nil {
+			rows.Close()
+			return err
+		}
+		boosts = append(boosts, b)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, b := range boosts {
+		if _, err = tx.Exec("UPDATE gophermart_promo_redemptions SET remaining_orders = remaining_orders - 1 WHERE code = $1 AND user_id = $2", b.code, userID); err != nil {
+			return err
+		}
+		bonus := int(math.Round(float64(amount) * b.percent / 100))
+		if bonus <= 0 {
+			continue
+		}
+		if err = s.post(tx, PostingPromo, b.code+":"+order, AccountPromotions, UserAccount(userID), bonus); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedeemFixedPromo(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	addTestUser(t, s, "bob")
+	addTestUser(t, s, "carol")
+	require.NoError(t, s.AddPromoCode(PromoCode{Code: "WELCOME", Kind: PromoFixed, Bonus: 500, MaxUses: 2, CreatedBy: "admin:root"}))
+	assert.ErrorIs(t, s.AddPromoCode(PromoCode{Code: "WELCOME", Kind: PromoFixed, Bonus: 100}), ErrConflict)
+
+	promo, err := s.RedeemPromoCode("alice", "WELCOME")
+	require.NoError(t, err)
+	assert.Equal(t, 500, promo.Bonus)
+	balance, _ := balanceOf(t, s, "alice")
+	assert.Equal(t, 500, balance)
+	assert.Equal(t, -500, ledgerBalance(t, s, AccountPromotions))
+
+	_, err = s.RedeemPromoCode("alice", "WELCOME")
+	assert.ErrorIs(t, err, ErrConflict, "a user redeems a code once")
+	_, err = s.RedeemPromoCode("bob", "WELCOME")
+	require.NoError(t, err)
+	_, err = s.RedeemPromoCode("carol", "WELCOME")
+	assert.ErrorIs(t, err, ErrGone, "the code is used up")
+	_, err = s.RedeemPromoCode("carol", "MISSING")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	balance, _ = balanceOf(t, s, "carol")
+	assert.Equal(t, 0, balance)
+	assert.Equal(t, -1000, ledgerBalance(t, s, AccountPromotions))
+}
+
+func TestRedeemBoostPromo(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	require.NoError(t, s.AddPromoCode(PromoCode{Code: "BOOST", Kind: PromoBoost, Percent: 10, Orders: 2}))
+
+	_, err := s.RedeemPromoCode("alice", "BOOST")
+	require.NoError(t, err)
+	balance, _ := balanceOf(t, s, "alice")
+	assert.Equal(t, 0, balance, "a boost credits nothing until an order is processed")
+
+	processOrder(t, s, "alice", "12345678903", 100)
+	processOrder(t, s, "alice", "79927398713", 50)
+	processOrder(t, s, "alice", "2377225624", 10)
+
+	balance, _ = balanceOf(t, s, "alice")
+	assert.Equal(t, 16000+1000+500, balance, "only the next two orders are boosted")
+	assert.Equal(t, -1500, ledgerBalance(t, s, AccountPromotions))
+}
+
+func TestRedeemPromoValidity(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	now := time.Now()
+	require.NoError(t, s.AddPromoCode(PromoCode{Code: "LATER", Kind: PromoFixed, Bonus: 100, ValidFrom: now.Add(time.Hour)}))
+	require.NoError(t, s.AddPromoCode(PromoCode{Code: "OVER", Kind: PromoFixed, Bonus: 100, ValidTo: now.Add(-time.Hour)}))
+	require.NoError(t, s.AddPromoCode(PromoCode{Code: "NOW", Kind: PromoFixed, Bonus: 100, ValidFrom: now.Add(-time.Hour), ValidTo: now.Add(time.Hour)}))
+
+	_, err := s.RedeemPromoCode("alice", "LATER")
+	assert.ErrorIs(t, err, ErrGone)
+	_, err = s.RedeemPromoCode("alice", "OVER")
+	assert.ErrorIs(t, err, ErrGone)
+	_, err = s.RedeemPromoCode("alice", "NOW")
+	require.NoError(t, err)
+
+	balance, _ := balanceOf(t, s, "alice")
+	assert.Equal(t, 100, balance)
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	ReferralPending  = "PENDING"
+	ReferralRewarded = "REWARDED"
+	ReferralRejected = "REJECTED"
+)
+
+func createReferrals(db *sql.DB) error {
+	_, err := db.Exec("ALTER TABLE gophermart_users ADD COLUMN IF NOT EXISTS referral_code text UNIQUE, ADD COLUMN IF NOT EXISTS registration_ip text DEFAULT '', ADD COLUMN IF NOT EXISTS registered_at timestamptz DEFAULT now();")
+	if err != nil {
+		return err
+	}
+	// Users registered before referrals existed get a code derived from their ID.
+	_, err = db.Exec("UPDATE gophermart_users SET referral_code = substr(md5(user_id), 1, 12) WHERE referral_code IS NULL;")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_referrals(referrer_id text NOT NULL, referee_id text UNIQUE, status text DEFAULT 'PENDING', reason text DEFAULT '', bonus bigint DEFAULT 0, created_at timestamptz DEFAULT now(), rewarded_at timestamptz);")
+	return err
+}
+
+func (s *SQLStorage) AddNewUser(user NewUser) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var referrerID, referrerIP string
+	status, reason := ReferralPending, ""
+	if user.ReferredBy != "" {
+		err = tx.QueryRow("SELECT user_id, registration_ip FROM gophermart_users WHERE referral_code = $1", user.ReferredBy).Scan(&referrerID, &referrerIP)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrReferralCode
+		}
+		if err != nil {
+			return err
+		}
+		// The new login is unique, so a referrer signing up again is told by the session
+		// they register from or by their address.
+		switch {
+		case user.ClientUserID == referrerID:
+			status, reason = ReferralRejected, "self_referral"
+		case user.IP != "" && user.IP == referrerIP:
+			status, reason = ReferralRejected, "same_ip"
+		}
+	}
+
+	result, err := tx.Exec("INSERT INTO gophermart_users(user_id, login, password, referral_code, registration_ip) VALUES($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING",
+		user.UserID, user.Login, user.Password, user.ReferralCode, user.IP)
+	if err != nil {
+		return err
+	}
+	changes, _ := result.RowsAffected()
+	if changes == 0 {
+		// Either the login is taken or the generated user ID or referral code collided.
+		var taken bool
+		if err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM gophermart_users WHERE login = $1)", user.Login).Scan(&taken); err != nil {
+			return err
+		}
+		if taken {
+			return ErrConflict
+		}
+		return ErrDuplicateID
+	}
+
+	if referrerID != "" {
+		_, err = tx.Exec("INSERT INTO gophermart_referrals(referrer_id, referee_id, status, reason) VALUES($1, $2, $3, $4)", referrerID, user.UserID, status, reason)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// rewardReferrer credits the referrer once the referee gets the first order processed with an accrual.
+func (s *SQLStorage) rewardReferrer(tx *sql.Tx, refereeID string) error {
+	if s.referralBonus <= 0 {
+		return nil
+	}
+	var referrerID string
+	err := tx.QueryRow("SELECT referrer_id FROM gophermart_referrals WHERE referee_id = $1 AND status = $2 FOR UPDATE", refereeID, ReferralPending).Scan(&referrerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE gophermart_referrals SET status=$1, bonus=$2, rewarded_at=now() WHERE referee_id = $3", ReferralRewarded, s.referralBonus, refereeID)
+	if err != nil {
+		return err
+	}
+	return s.post(tx, PostingReferral, refereeID, AccountReferrals, UserAccount(referrerID), s.referralBonus)
+}
+
+// UserReferrals lists the users invited by the user along with the user's referral code.
+func (s *SQLStorage) UserReferrals(userID string) ([]byte, error) {
+	summary := referralSummary{Referrals: make([]referrals, 0)}
+	if err := s.DB.QueryRow("SELECT referral_code FROM gophermart_users WHERE user_id = $1", userID).Scan(&summary.Code); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.Query(`SELECT u.login, r.status, r.reason, r.bonus, r.created_at FROM gophermart_referrals r
+		JOIN gophermart_users u ON u.user_id = r.referee_id WHERE r.referrer_id = $1 ORDER BY r.created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	earned := 0
+	for rows.Next() {
+		var login, status, reason string
+		var bonus int
+		var createdAt time.Time
+		if err = rows.Scan(&login, &status, &reason, &bonus, &createdAt); err != nil {
+			return nil, err
+		}
+		earned += bonus
+		summary.Referrals = append(summary.Referrals, referrals{Login: login, Status: status, Reason: reason, Bonus: float32(bonus) / 100, RegisteredAt: createdAt.Format(time.RFC3339)})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	summary.Earned = float32(earned) / 100
+	return json.Marshal(summary)
+}
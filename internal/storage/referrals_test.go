@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func referralsOf(t *testing.T, s *SQLStorage, userID string) referralSummary {
+	t.Helper()
+	body, err := s.UserReferrals(userID)
+	require.NoError(t, err)
+	var summary referralSummary
+	require.NoError(t, json.Unmarshal(body, &summary))
+	return summary
+}
+
+func TestAddNewUserReferral(t *testing.T) {
+	s := newTestStorage(t)
+	require.NoError(t, s.AddNewUser(NewUser{UserID: "referrer", Login: "Gopher", Password: "hash", ReferralCode: "code", IP: "192.0.2.1"}))
+
+	require.NoError(t, s.AddNewUser(NewUser{UserID: "friend", Login: "friend", Password: "hash", ReferralCode: "code-friend", ReferredBy: "code", IP: "192.0.2.2"}))
+	require.NoError(t, s.AddNewUser(NewUser{UserID: "same-ip", Login: "neighbour", Password: "hash", ReferralCode: "code-same-ip", ReferredBy: "code", IP: "192.0.2.1"}))
+	require.NoError(t, s.AddNewUser(NewUser{UserID: "self", Login: "gopher-2", Password: "hash", ReferralCode: "code-self", ReferredBy: "code", IP: "192.0.2.3", ClientUserID: "referrer"}))
+	assert.ErrorIs(t, s.AddNewUser(NewUser{UserID: "unknown", Login: "unknown", Password: "hash", ReferralCode: "code-unknown", ReferredBy: "missing"}), ErrReferralCode)
+
+	summary := referralsOf(t, s, "referrer")
+	assert.Equal(t, "code", summary.Code)
+	require.Len(t, summary.Referrals, 3)
+	reasons := make(map[string]string)
+	for _, referral := range summary.Referrals {
+		reasons[referral.Login] = referral.Status + "/" + referral.Reason
+	}
+	assert.Equal(t, map[string]string{
+		"friend":    ReferralPending + "/",
+		"neighbour": ReferralRejected + "/same_ip",
+		"gopher-2":  ReferralRejected + "/self_referral",
+	}, reasons)
+}
+
+func TestReferralBonusNeedsAccrual(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "referrer")
+	require.NoError(t, s.AddNewUser(NewUser{UserID: "friend", Login: "friend", Password: "hash", ReferralCode: "code-friend", ReferredBy: "code-referrer"}))
+
+	processOrder(t, s, "friend", "12345678903", 0)
+	balance, _ := balanceOf(t, s, "referrer")
+	assert.Equal(t, 0, balance, "an order without accrual earns no referral bonus")
+	assert.Equal(t, ReferralPending, referralsOf(t, s, "referrer").Referrals[0].Status)
+
+	processOrder(t, s, "friend", "79927398713", 5)
+	processOrder(t, s, "friend", "2377225624", 5)
+	balance, _ = balanceOf(t, s, "referrer")
+	assert.Equal(t, 1000, balance)
+	assert.Equal(t, ReferralRewarded, referralsOf(t, s, "referrer").Referrals[0].Status)
+}
+
+func TestAddNewUserConflicts(t *testing.T) {
+	s := newTestStorage(t)
+	require.NoError(t, s.AddNewUser(NewUser{UserID: "user", Login: "gopher", Password: "hash", ReferralCode: "code"}))
+
+	assert.ErrorIs(t, s.AddNewUser(NewUser{UserID: "other", Login: "gopher", Password: "hash", ReferralCode: "other-code"}), ErrConflict)
+	assert.ErrorIs(t, s.AddNewUser(NewUser{UserID: "other", Login: "beaver", Password: "hash", ReferralCode: "code"}), ErrDuplicateID)
+	assert.ErrorIs(t, s.AddNewUser(NewUser{UserID: "user", Login: "beaver", Password: "hash", ReferralCode: "other-code"}), ErrDuplicateID)
+	require.NoError(t, s.AddNewUser(NewUser{UserID: "other", Login: "beaver", Password: "hash", ReferralCode: "other-code"}))
+}
+
+func TestUserReferralsLegacyCode(t *testing.T) {
+	s := newTestStorage(t)
+	_, err := s.DB.Exec("INSERT INTO gophermart_users(user_id, login, password) VALUES('legacy', 'legacy', 'hash')")
+	require.NoError(t, err)
+	require.NoError(t, createReferrals(s.DB))
+
+	summary := referralsOf(t, s, "legacy")
+	assert.Len(t, summary.Code, 12)
+	assert.Empty(t, summary.Referrals)
+	assert.Equal(t, summary.Code, referralsOf(t, s, "legacy").Code)
+}
@@ -0,0 +1,25 @@
+package storage
+
+import "database/sql"
+
+// CurrentSchemaVersion is raised whenever createDB changes the schema, so readiness can tell
+// whether the database has been brought up to date.
+const CurrentSchemaVersion = 2
+
+// createSchemaVersion runs after every other table is created and never lowers the stored version,
+// so an older instance starting next to a newer one keeps it.
+func createSchemaVersion(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS gophermart_schema_version(id boolean PRIMARY KEY DEFAULT true CHECK (id), version integer NOT NULL, applied_at timestamptz NOT NULL DEFAULT now());")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO gophermart_schema_version(version) VALUES($1) ON CONFLICT (id) DO UPDATE SET version = EXCLUDED.version, applied_at = now() WHERE gophermart_schema_version.version < EXCLUDED.version", CurrentSchemaVersion)
+	return err
+}
+
+// SchemaVersion returns the version of the schema stored in the database.
+func (s *SQLStorage) SchemaVersion() (int, error) {
+	var version int
+	err := s.DB.QueryRow("SELECT version FROM gophermart_schema_version").Scan(&version)
+	return version, err
+}
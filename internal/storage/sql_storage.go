@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -13,7 +14,10 @@ import (
 )
 
 type SQLStorage struct {
-	DB *sql.DB
+	DB            *sql.DB
+	pointsExpiry  int
+	expiringSoon  time.Duration
+	referralBonus int
 }
 
 func NewSQLStorager(cfg *config.Config) *SQLStorage {
@@ -26,12 +30,23 @@ func NewSQLStorager(cfg *config.Config) *SQLStorage {
 		log.Fatal().Err(err).Msg("CreateDB create table error")
 	}
 	return &SQLStorage{
-		DB: db,
+		DB:            db,
+		pointsExpiry:  cfg.PointsExpiryMonths,
+		expiringSoon:  cfg.ExpiringSoonWindow,
+		referralBonus: int(cfg.ReferralBonus * 100),
 	}
 }
 
 func createDB(db *sql.DB) error {
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS gophermart_users(user_id text UNIQUE, login text UNIQUE, password text, balance integer DEFAULT 0, withdrawn integer DEFAULT 0);")
+	err := createMigrations(db)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_users(user_id text UNIQUE, login text UNIQUE, password text, balance integer DEFAULT 0, withdrawn integer DEFAULT 0);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE gophermart_users ADD COLUMN IF NOT EXISTS tier text DEFAULT '', ADD COLUMN IF NOT EXISTS tier_multiplier double precision DEFAULT 1;")
 	if err != nil {
 		return err
 	}
@@ -40,15 +55,110 @@ func createDB(db *sql.DB) error {
 	if err != nil {
 		return err
 	}
+	_, err = db.Exec("ALTER TABLE gophermart_orders ADD COLUMN IF NOT EXISTS processed_at text;")
+	if err != nil {
+		return err
+	}
 	log.Debug().Msg("storage gophermart_orders init")
 	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_withdraws(order_no text UNIQUE, user_id text, sum integer, date text);")
 	if err != nil {
 		return err
 	}
+	_, err = db.Exec("ALTER TABLE gophermart_withdraws ADD COLUMN IF NOT EXISTS reversed_at text, ADD COLUMN IF NOT EXISTS reversed_by text;")
+	if err != nil {
+		return err
+	}
 	log.Debug().Msg("storage gophermart_withdraws init")
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_idempotency(user_id text, idem_key text, fingerprint text, status integer DEFAULT 0, content_type text DEFAULT '', body bytea, created_at timestamptz DEFAULT now(), UNIQUE(user_id, idem_key));")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_idempotency_created_idx ON gophermart_idempotency(created_at);")
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_idempotency init")
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_ratelimit(bucket text UNIQUE, window_start timestamptz, expires_at timestamptz, hits integer DEFAULT 0);")
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_ratelimit init")
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_login_failures(subject text UNIQUE, failures integer DEFAULT 0, last_failure timestamptz, locked_until timestamptz);")
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_login_failures init")
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_audit(id bigserial PRIMARY KEY, created_at timestamptz DEFAULT now(), actor text, action text, target text, ip text, details text);")
+	if err != nil {
+		return err
+	}
+	err = createAudit(db)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_audit init")
+	err = createLedger(db)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_ledger init")
+	err = createLots(db)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_lots init")
+	err = createHolds(db)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_holds init")
+	err = createTransfers(db)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_transfers init")
+	err = createPromo(db)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_promo_codes init")
+	err = createReferrals(db)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_referrals init")
+	err = createRoles(db)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_users roles init")
+	err = createAdjustments(db)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_adjustments init")
+	err = createWebhooks(db)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_webhooks init")
+	err = createStats(db)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_stats init")
+	err = createSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("storage gophermart_schema_version init")
 	return nil
 }
 
+func (s *SQLStorage) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
 func (s *SQLStorage) CloseDB() {
 	err := s.DB.Close()
 	if err != nil {
@@ -57,18 +167,6 @@ func (s *SQLStorage) CloseDB() {
 	log.Info().Msg("db closed")
 }
 
-func (s *SQLStorage) AddNewUser(login, password, userID string) error {
-	result, err := s.DB.Exec("INSERT INTO gophermart_users(user_id, login, password) VALUES($1, $2, $3) ON CONFLICT DO NOTHING", userID, login, password)
-	if err != nil {
-		return err
-	}
-	changes, _ := result.RowsAffected()
-	if changes == 0 {
-		return ErrConflict
-	}
-	return nil
-}
-
 func (s *SQLStorage) LogInUser(login, password string) (string, error) {
 	var userID string
 	err := s.DB.QueryRow("SELECT user_id FROM gophermart_users WHERE login = $1 AND password = $2", login, password).Scan(&userID)
@@ -111,51 +209,51 @@ func (s *SQLStorage) AddNewOrder(userID, order string) error {
 	return nil
 }
 
-func (s *SQLStorage) UserWithdraw(userID, order string, sum float32) error {
-	var balance, withdrawn int
-	err := s.DB.QueryRow("SELECT balance, withdrawn FROM gophermart_users WHERE user_id = $1", userID).Scan(&balance, &withdrawn)
-	if err != nil {
-		return err
-	}
-	log.Debug().Msgf("UserBalance: %d, %d, want to witdraw: %f", balance, withdrawn, sum)
-	if balance < int(sum*100) {
-		return ErrNotEnouthBalance
-	}
-
+// AddNewOrders inserts the orders in one transaction. The returned slice holds ErrUploaded or
+// ErrAnotherUserUploaded for the orders that were already present and nil for the accepted ones.
+func (s *SQLStorage) AddNewOrders(userID string, orders []string) ([]error, error) {
+	results := make([]error, len(orders))
 	tx, err := s.DB.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	stmtUser, err := tx.Prepare("UPDATE gophermart_users SET balance=$1, withdrawn=$2 WHERE user_id = $3")
+	defer tx.Rollback()
+	stmtSelect, err := tx.Prepare("SELECT user_id FROM gophermart_orders WHERE order_no = $1")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer stmtUser.Close()
-	stmtWdraw, err := tx.Prepare("INSERT INTO gophermart_withdraws(order_no, user_id, sum, date) VALUES($1, $2, $3, $4)")
+	defer stmtSelect.Close()
+	stmtInsert, err := tx.Prepare("INSERT INTO gophermart_orders(order_no, user_id, date) VALUES($1, $2, $3) ON CONFLICT DO NOTHING")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer stmtWdraw.Close()
-	today := time.Now()
-	log.Debug().Msgf("newUserBalance: %d", balance-int(sum*100))
-	if _, err = stmtUser.Exec(balance-int(sum*100), withdrawn+int(sum*100), userID); err != nil {
-		if err = tx.Rollback(); err != nil {
-			log.Fatal().Msgf("update drivers: unable to rollback: %v", err)
+	defer stmtInsert.Close()
+
+	today := time.Now().Format(time.RFC3339)
+	for i, order := range orders {
+		result, err := stmtInsert.Exec(order, userID, today)
+		if err != nil {
+			return nil, err
 		}
-		return err
-	}
-	if _, err = stmtWdraw.Exec(order, userID, int(sum*100), today.Format(time.RFC3339)); err != nil {
-		if err = tx.Rollback(); err != nil {
-			log.Fatal().Msgf("update drivers: unable to rollback: %v", err)
+		changes, _ := result.RowsAffected()
+		if changes != 0 {
+			continue
+		}
+		var currentUser string
+		if err = stmtSelect.QueryRow(order).Scan(&currentUser); err != nil {
+			return nil, err
+		}
+		if currentUser == userID {
+			results[i] = ErrUploaded
+		} else {
+			results[i] = ErrAnotherUserUploaded
 		}
-		return err
 	}
 
 	if err = tx.Commit(); err != nil {
-		log.Fatal().Msgf("update drivers: unable to commit: %v", err)
-		return err
+		return nil, err
 	}
-	return nil
+	return results, nil
 }
 
 func (s *SQLStorage) UserBalance(userID string) ([]byte, error) {
@@ -164,8 +262,21 @@ func (s *SQLStorage) UserBalance(userID string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	currentUserBalance := currentBalance{Current: float32(balance) / 100, Withdrawn: float32(withdrawn) / 100}
-	log.Debug().Msgf("currentUserBalance: %f", currentUserBalance)
+	held, err := heldAmount(s.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	currentUserBalance := currentBalance{
+		Current:   float32(balance) / 100,
+		Available: float32(balance-held) / 100,
+		Held:      float32(held) / 100,
+		Withdrawn: float32(withdrawn) / 100,
+	}
+	currentUserBalance.ExpiringSoon, err = s.expiringLots(userID)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Msgf("currentUserBalance: %+v", currentUserBalance)
 	currentUserBalanceBZ, err := json.Marshal(currentUserBalance)
 	if err != nil {
 		return nil, err
@@ -206,9 +317,10 @@ func (s *SQLStorage) UserOrders(userID string) ([]byte, error) {
 
 func (s *SQLStorage) UserWithdrawals(userID string) ([]byte, error) {
 	var orderNo, date string
+	var reversedAt sql.NullString
 	var sum int
 	currentUserWithdraws := make([]withdraws, 0)
-	rows, err := s.DB.Query("SELECT order_no, sum, date FROM gophermart_withdraws WHERE user_id = $1", userID)
+	rows, err := s.DB.Query("SELECT order_no, sum, date, reversed_at FROM gophermart_withdraws WHERE user_id = $1", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -217,11 +329,16 @@ func (s *SQLStorage) UserWithdrawals(userID string) ([]byte, error) {
 	}
 	defer rows.Close()
 	for rows.Next() {
-		err = rows.Scan(&orderNo, &sum, &date)
+		err = rows.Scan(&orderNo, &sum, &date, &reversedAt)
 		if err != nil {
 			return nil, err
 		}
-		currentUserWithdraws = append(currentUserWithdraws, withdraws{Order: orderNo, Sum: float32(sum) / 100, ProcessedAt: date})
+		withdraw := withdraws{Order: orderNo, Sum: float32(sum) / 100, ProcessedAt: date}
+		if reversedAt.Valid {
+			withdraw.Status = "REVERSED"
+			withdraw.ReversedAt = reversedAt.String
+		}
+		currentUserWithdraws = append(currentUserWithdraws, withdraw)
 	}
 	currentUserWithdrawsBZ, err := json.Marshal(currentUserWithdraws)
 	if err != nil {
@@ -251,54 +368,151 @@ func (s *SQLStorage) GetProcessedOrders() ([]ProcessedOrders, error) {
 	return orders, nil
 }
 
-func (s *SQLStorage) UpdateOrderStatus(accResult AccuralResult) error {
-	if accResult.Status == "PROCESSED" {
-		var balance int
-		err := s.DB.QueryRow("SELECT balance FROM gophermart_users WHERE user_id = $1", accResult.UserID).Scan(&balance)
-		if err != nil {
-			return err
-		}
+// ReserveIdempotencyKey takes the key over when it expired but was not pruned yet, so expired
+// responses are never replayed.
+func (s *SQLStorage) ReserveIdempotencyKey(userID, key, fingerprint string, expiredBefore time.Time) (*IdempotentResponse, error) {
+	result, err := s.DB.Exec(`INSERT INTO gophermart_idempotency(user_id, idem_key, fingerprint) VALUES($1, $2, $3)
+		ON CONFLICT (user_id, idem_key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint, status = 0, content_type = '', body = NULL, created_at = now()
+		WHERE gophermart_idempotency.created_at < $4`, userID, key, fingerprint, expiredBefore)
+	if err != nil {
+		return nil, err
+	}
+	changes, _ := result.RowsAffected()
+	if changes != 0 {
+		return nil, nil
+	}
 
-		tx, err := s.DB.Begin()
-		if err != nil {
-			return err
-		}
-		stmtUser, err := tx.Prepare("UPDATE gophermart_users SET balance=$1 WHERE user_id = $2")
-		if err != nil {
-			return err
-		}
-		defer stmtUser.Close()
-		stmtOrder, err := tx.Prepare("UPDATE gophermart_orders SET status='PROCESSED', accrual=$1 WHERE order_no=$2")
-		if err != nil {
-			return err
-		}
-		defer stmtOrder.Close()
+	var storedFingerprint string
+	var response IdempotentResponse
+	err = s.DB.QueryRow("SELECT fingerprint, status, content_type, body FROM gophermart_idempotency WHERE user_id = $1 AND idem_key = $2", userID, key).Scan(&storedFingerprint, &response.Status, &response.ContentType, &response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if storedFingerprint != fingerprint {
+		return nil, ErrIdempotencyMismatch
+	}
+	if response.Status == 0 {
+		return nil, ErrIdempotencyInFlight
+	}
+	return &response, nil
+}
 
-		if _, err = stmtUser.Exec(balance+int(accResult.Accrual*100), accResult.UserID); err != nil {
-			if err = tx.Rollback(); err != nil {
-				log.Fatal().Msgf("update drivers: unable to rollback: %v", err)
-			}
-			return err
-		}
-		if _, err = stmtOrder.Exec(int(accResult.Accrual*100), accResult.Order); err != nil {
-			if err = tx.Rollback(); err != nil {
-				log.Fatal().Msgf("update drivers: unable to rollback: %v", err)
-			}
-			return err
-		}
+func (s *SQLStorage) SaveIdempotentResponse(userID, key string, response IdempotentResponse) error {
+	_, err := s.DB.Exec("UPDATE gophermart_idempotency SET status=$1, content_type=$2, body=$3 WHERE user_id = $4 AND idem_key = $5", response.Status, response.ContentType, response.Body, userID, key)
+	if err != nil {
+		return err
+	}
+	return nil
+}
 
+func (s *SQLStorage) ReleaseIdempotencyKey(userID, key string) error {
+	_, err := s.DB.Exec("DELETE FROM gophermart_idempotency WHERE user_id = $1 AND idem_key = $2", userID, key)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SQLStorage) PruneIdempotencyKeys(expiredBefore time.Time) error {
+	_, err := s.DB.Exec("DELETE FROM gophermart_idempotency WHERE created_at < $1", expiredBefore)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SQLStorage) IncrementRateCounter(bucket string, windowStart time.Time, window time.Duration) (int, error) {
+	var hits int
+	err := s.DB.QueryRow(`INSERT INTO gophermart_ratelimit(bucket, window_start, expires_at, hits) VALUES($1, $2, $3, 1)
+		ON CONFLICT (bucket) DO UPDATE SET
+		hits = CASE WHEN gophermart_ratelimit.window_start = EXCLUDED.window_start THEN gophermart_ratelimit.hits + 1 ELSE 1 END,
+		window_start = EXCLUDED.window_start, expires_at = EXCLUDED.expires_at
+		RETURNING hits`, bucket, windowStart, windowStart.Add(window)).Scan(&hits)
+	if err != nil {
+		return 0, err
+	}
+	return hits, nil
+}
+
+func (s *SQLStorage) PruneRateCounters(expiredBefore time.Time) error {
+	_, err := s.DB.Exec("DELETE FROM gophermart_ratelimit WHERE expires_at < $1", expiredBefore)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReserveLoginAttempt counts the attempt as a failure before the password is checked, so
+// concurrent guesses cannot all pass the limits; ReleaseLoginAttempt gives it back when the
+// password was right. Once MaxFailures is reached the count is kept: after the lock runs out the
+// subject gets one attempt per lockout until a successful login resets it, or until it stays
+// quiet for another lockout.
+func (s *SQLStorage) ReserveLoginAttempt(subject string, limits LoginLimits) (LoginFailures, error) {
+	var failures LoginFailures
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return failures, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("INSERT INTO gophermart_login_failures(subject) VALUES($1) ON CONFLICT DO NOTHING", subject)
+	if err != nil {
+		return failures, err
+	}
+	var lastFailure, lockedUntil sql.NullTime
+	err = tx.QueryRow("SELECT failures, last_failure, locked_until FROM gophermart_login_failures WHERE subject = $1 FOR UPDATE", subject).Scan(&failures.Failures, &lastFailure, &lockedUntil)
+	if err != nil {
+		return failures, err
+	}
+	failures.LastFailure = lastFailure.Time
+	failures.LockedUntil = lockedUntil.Time
+
+	now := time.Now()
+	if failures.LockedUntil.After(now) {
+		return failures, ErrLocked
+	}
+	quiet := now.Add(-limits.Lockout)
+	if failures.LastFailure.Before(quiet) && (failures.Failures < limits.MaxFailures || failures.LockedUntil.Before(quiet)) {
+		failures.Failures = 0
+	}
+	if limits.Delay != nil && failures.LastFailure.Add(limits.Delay(failures.Failures)).After(now) {
+		return failures, ErrLoginDelayed
+	}
+	if failures.Failures >= limits.MaxFailures && failures.LastFailure.After(failures.LockedUntil) {
+		failures.LockedUntil = now.Add(limits.Lockout)
+		failures.JustLocked = true
+		_, err = tx.Exec("UPDATE gophermart_login_failures SET failures=$1, locked_until=$2 WHERE subject = $3", failures.Failures, failures.LockedUntil, subject)
+		if err != nil {
+			return failures, err
+		}
 		if err = tx.Commit(); err != nil {
-			log.Fatal().Msgf("update drivers: unable to commit: %v", err)
-			return err
+			return failures, err
 		}
-
-		return nil
+		return failures, ErrLocked
+	}
+	failures.Failures++
+	failures.LastFailure = now
+	_, err = tx.Exec("UPDATE gophermart_login_failures SET failures=$1, last_failure=$2 WHERE subject = $3", failures.Failures, failures.LastFailure, subject)
+	if err != nil {
+		return failures, err
 	}
+	if err = tx.Commit(); err != nil {
+		return failures, err
+	}
+	return failures, nil
+}
 
-	_, err := s.DB.Exec("UPDATE gophermart_orders SET status=$1 WHERE order_no=$2", accResult.Status, accResult.Order)
+func (s *SQLStorage) ReleaseLoginAttempt(subject string) error {
+	_, err := s.DB.Exec("UPDATE gophermart_login_failures SET failures = GREATEST(failures - 1, 0) WHERE subject = $1", subject)
 	if err != nil {
 		return err
 	}
 	return nil
+}
 
+func (s *SQLStorage) ResetLoginFailures(subject string) error {
+	_, err := s.DB.Exec("DELETE FROM gophermart_login_failures WHERE subject = $1", subject)
+	if err != nil {
+		return err
+	}
+	return nil
 }
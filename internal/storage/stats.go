@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Stats summarizes the state exposed to monitoring. Points are in hundredths.
+type Stats struct {
+	Backlog   int
+	Credited  map[string]int64
+	Withdrawn int64
+}
+
+// The ledger totals are kept in gophermart_stat_totals so a scrape never sums the ledger. post only
+// appends its share to gophermart_stat_changes, which has no unique rows to wait on, and the scrape
+// folds the changes into the totals.
+const (
+	statsLockKey = 7041919
+
+	statWithdrawn       = "withdrawn"
+	statCreditedPrefix  = "credited:"
+	backlogStatusesExpr = "('NEW', 'REGISTERED', 'PROCESSING')"
+)
+
+// creditingAccounts only ever credit users, so what they give away is counted as credited.
+var creditingAccounts = map[string]bool{
+	AccountAccruals:    true,
+	AccountTierBonuses: true,
+	AccountPromotions:  true,
+	AccountReferrals:   true,
+}
+
+// createStats runs after the ledger and the orders exist. The totals are filled from the ledger
+// once, when the tables are created.
+func createStats(db *sql.DB) error {
+	_, err := db.Exec("CREATE INDEX IF NOT EXISTS gophermart_orders_backlog_idx ON gophermart_orders(status) WHERE status IN " + backlogStatusesExpr + ";")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_stat_totals(name text PRIMARY KEY, value bigint NOT NULL DEFAULT 0);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_stat_changes(name text NOT NULL, value bigint NOT NULL);")
+	if err != nil {
+		return err
+	}
+	// The backlog used to be counted by a trigger on the orders into sharded counters.
+	err = migrateOnce(db, "stats_drop_counters", func(tx *sql.Tx) error {
+		for _, stmt := range []string{
+			"DROP TRIGGER IF EXISTS gophermart_orders_backlog ON gophermart_orders;",
+			"DROP FUNCTION IF EXISTS gophermart_count_backlog();",
+			"DROP TABLE IF EXISTS gophermart_stats;",
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return migrateOnce(db, "stats_fill_totals", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO gophermart_stat_totals(name, value)
+			SELECT $1::text, COALESCE(SUM(amount), 0)::bigint FROM gophermart_ledger WHERE account = $2 AND kind = $3
+			UNION ALL
+			SELECT $4::text || kind, -SUM(amount)::bigint FROM gophermart_ledger WHERE account IN ($5, $6, $7, $8) GROUP BY kind`,
+			statWithdrawn, AccountRedemptions, PostingWithdrawal,
+			statCreditedPrefix, AccountAccruals, AccountTierBonuses, AccountPromotions, AccountReferrals)
+		return err
+	})
+}
+
+// countPosting records one side of a posting for the totals it belongs to.
+func countPosting(tx *sql.Tx, kind, account string, amount int) error {
+	var name string
+	switch {
+	case creditingAccounts[account]:
+		name, amount = statCreditedPrefix+kind, -amount
+	case account == AccountRedemptions && kind == PostingWithdrawal:
+		name = statWithdrawn
+	default:
+		return nil
+	}
+	_, err := tx.Exec("INSERT INTO gophermart_stat_changes(name, value) VALUES($1, $2)", name, amount)
+	return err
+}
+
+// foldStats moves the committed changes into the totals. A scrape running while another one folds
+// skips it, the changes are summed in by the read anyway.
+func (s *SQLStorage) foldStats() error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var locked bool
+	if err = tx.QueryRow("SELECT pg_try_advisory_xact_lock($1)", statsLockKey).Scan(&locked); err != nil || !locked {
+		return err
+	}
+	_, err = tx.Exec(`WITH folded AS (DELETE FROM gophermart_stat_changes RETURNING name, value)
+		INSERT INTO gophermart_stat_totals(name, value) SELECT name, SUM(value) FROM folded GROUP BY name
+		ON CONFLICT (name) DO UPDATE SET value = gophermart_stat_totals.value + EXCLUDED.value`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Stats counts the orders still waiting for the accrual system and returns the points credited by
+// kind and withdrawn.
+func (s *SQLStorage) Stats() (Stats, error) {
+	if err := s.foldStats(); err != nil {
+		return Stats{}, err
+	}
+	stats := Stats{Credited: make(map[string]int64)}
+	err := s.DB.QueryRow("SELECT count(*) FROM gophermart_orders WHERE status IN " + backlogStatusesExpr).Scan(&stats.Backlog)
+	if err != nil {
+		return Stats{}, err
+	}
+	rows, err := s.DB.Query(`SELECT name, SUM(value)::bigint FROM (
+			SELECT name, value FROM gophermart_stat_totals
+			UNION ALL
+			SELECT name, value FROM gophermart_stat_changes
+		) stats GROUP BY name`)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var value int64
+		if err = rows.Scan(&name, &value); err != nil {
+			return Stats{}, err
+		}
+		switch {
+		case name == statWithdrawn:
+			stats.Withdrawn = value
+		case strings.HasPrefix(name, statCreditedPrefix):
+			stats.Credited[strings.TrimPrefix(name, statCreditedPrefix)] = value
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return Stats{}, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsTotals(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	require.NoError(t, s.AddNewOrder("alice", "12345678903"))
+	require.NoError(t, s.AddNewOrder("alice", "79927398713"))
+	require.NoError(t, s.UpdateOrderStatus(AccuralResult{UserID: "alice", Order: "12345678903", Status: "PROCESSED", Accrual: 10}))
+	require.NoError(t, s.UserWithdraw("alice", "2377225624", 3.5))
+
+	stats, err := s.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Backlog)
+	assert.Equal(t, int64(1000), stats.Credited[PostingAccrual])
+	assert.Equal(t, int64(350), stats.Withdrawn)
+
+	// Postings after a scrape are added to the folded totals.
+	credit(t, s, "alice", PostingAccrual, "79927398713", 250)
+	stats, err = s.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1250), stats.Credited[PostingAccrual])
+
+	// Filling the totals again from the ledger gives the same totals.
+	for _, stmt := range []string{"DELETE FROM gophermart_stat_totals", "DELETE FROM gophermart_stat_changes", "DELETE FROM gophermart_migrations WHERE name = 'stats_fill_totals'"} {
+		_, err = s.DB.Exec(stmt)
+		require.NoError(t, err)
+	}
+	require.NoError(t, createStats(s.DB))
+	backfilled, err := s.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, stats, backfilled)
+}
@@ -1,20 +1,76 @@
 package storage
 
 import (
+	"context"
+	"time"
+
 	"gophermart/internal/config"
 )
 
 type Storager interface {
-	AddNewUser(login, password, userID string) error
+	AddNewUser(user NewUser) error
 	LogInUser(login, password string) (string, error)
 	CheckUser(userID string) error
 	AddNewOrder(userID, orders string) error
+	AddNewOrders(userID string, orders []string) ([]error, error)
 	UserWithdraw(userID, order string, sum float32) error
+	ReverseWithdrawal(userID, order string, window time.Duration, actor string) error
+	CreateHold(userID, holdID, order string, sum float32, expiresAt time.Time) error
+	CaptureHold(userID, holdID string) error
+	VoidHold(userID, holdID string) error
+	ExpireHolds(now time.Time) (int, error)
+	TransferPoints(userID, transferID, toLogin string, sum float32, comment string, dailyAmount, dailyCount int) error
+	UserTransfers(userID string) ([]byte, error)
+	AddPromoCode(promo PromoCode) error
+	RedeemPromoCode(userID, code string) (PromoCode, error)
+	UserReferrals(userID string) ([]byte, error)
+	UserRole(userID string) (string, error)
+	SetUserRole(login, role string) error
+	UserIDByLogin(login string) (string, error)
+	AdminUser(login string) ([]byte, error)
+	AdminOrder(order string) ([]byte, error)
+	OverrideOrderStatus(order, status string, accrual float32) error
+	CreateAdjustment(adjustment Adjustment) error
+	ApproveAdjustment(id, approver string) (Adjustment, error)
+	RejectAdjustment(id, approver string) (Adjustment, error)
+	Adjustments(status string) ([]byte, error)
 	UserBalance(userID string) ([]byte, error)
 	UserOrders(userID string) ([]byte, error)
 	UserWithdrawals(userID string) ([]byte, error)
+	UserStatement(userID string, from, to time.Time, fn func(StatementEntry) error) error
 	GetProcessedOrders() ([]ProcessedOrders, error)
 	UpdateOrderStatus(AccuralResult) error
+	ReconcileBalances(fix bool) ([]BalanceDrift, error)
+	ExpirePoints(now time.Time) (int, error)
+	TierPoints(basis string, since time.Time, fn func(userID, currentTier string, points int) error) error
+	UserTier(userID, basis string, since time.Time) (UserTier, error)
+	SetUserTier(userID, tier string, multiplier float64) error
+	ReserveIdempotencyKey(userID, key, fingerprint string, expiredBefore time.Time) (*IdempotentResponse, error)
+	SaveIdempotentResponse(userID, key string, response IdempotentResponse) error
+	ReleaseIdempotencyKey(userID, key string) error
+	PruneIdempotencyKeys(expiredBefore time.Time) error
+	IncrementRateCounter(bucket string, windowStart time.Time, window time.Duration) (int, error)
+	PruneRateCounters(expiredBefore time.Time) error
+	ReserveLoginAttempt(subject string, limits LoginLimits) (LoginFailures, error)
+	ReleaseLoginAttempt(subject string) error
+	ResetLoginFailures(subject string) error
+	AddAuditEntry(entry AuditEntry) error
+	AuditEntries(filter AuditFilter) ([]byte, error)
+	SealAudit() (int, error)
+	AddWebhook(webhook Webhook) error
+	DeleteWebhook(userID, webhookID string) error
+	UserWebhooks(userID string) ([]byte, error)
+	WebhookDeliveries(userID, webhookID string) ([]byte, error)
+	DispatchOutbox() (int, error)
+	ClaimDeliveries(limit int, lease time.Duration) ([]WebhookDelivery, error)
+	RecordDeliveryAttempt(attempt DeliveryAttempt, nextAttempt time.Time) error
+	PruneOutbox(dispatchedBefore time.Time) (int, error)
+	ListenEvents(ctx context.Context, fn func(userID string, id int64)) error
+	UserEvents(userID string, afterID int64, limit int) ([]Event, error)
+	LastEventID(userID string) (int64, error)
+	Stats() (Stats, error)
+	Ping(ctx context.Context) error
+	SchemaVersion() (int, error)
 	CloseDB()
 }
 
@@ -22,9 +78,41 @@ func NewStorage(p *config.Config) Storager {
 	return NewSQLStorager(p)
 }
 
+// contextBinder is implemented by wrappers that attribute storage calls to a request or a job.
+type contextBinder interface {
+	WithContext(ctx context.Context) Storager
+}
+
+// WithContext binds strg to ctx when strg is such a wrapper, otherwise it returns strg as is.
+func WithContext(ctx context.Context, strg Storager) Storager {
+	if binder, ok := strg.(contextBinder); ok {
+		return binder.WithContext(ctx)
+	}
+	return strg
+}
+
+type NewUser struct {
+	UserID       string
+	Login        string
+	Password     string
+	ReferralCode string
+	ReferredBy   string
+	IP           string
+	// ClientUserID is the user the registering client is logged in as, if any.
+	ClientUserID string
+}
+
 type currentBalance struct {
-	Current   float32 `json:"current"`
-	Withdrawn float32 `json:"withdrawn"`
+	Current      float32        `json:"current"`
+	Available    float32        `json:"available"`
+	Held         float32        `json:"held"`
+	Withdrawn    float32        `json:"withdrawn"`
+	ExpiringSoon []expiringLots `json:"expiring_soon,omitempty"`
+}
+
+type expiringLots struct {
+	Amount    float32 `json:"amount"`
+	ExpiresAt string  `json:"expires_at"`
 }
 
 type orders struct {
@@ -38,6 +126,105 @@ type withdraws struct {
 	Order       string  `json:"order"`
 	Sum         float32 `json:"sum"`
 	ProcessedAt string  `json:"processed_at"`
+	Status      string  `json:"status,omitempty"`
+	ReversedAt  string  `json:"reversed_at,omitempty"`
+}
+
+type transfers struct {
+	ID           string  `json:"id"`
+	Direction    string  `json:"direction"`
+	Counterparty string  `json:"counterparty"`
+	Sum          float32 `json:"sum"`
+	Comment      string  `json:"comment,omitempty"`
+	ProcessedAt  string  `json:"processed_at"`
+}
+
+type referralSummary struct {
+	Code      string      `json:"code"`
+	Earned    float32     `json:"earned"`
+	Referrals []referrals `json:"referrals"`
+}
+
+type referrals struct {
+	Login        string  `json:"login"`
+	Status       string  `json:"status"`
+	Reason       string  `json:"reason,omitempty"`
+	Bonus        float32 `json:"bonus"`
+	RegisteredAt string  `json:"registered_at"`
+}
+
+type adminUser struct {
+	UserID       string  `json:"user_id"`
+	Login        string  `json:"login"`
+	Role         string  `json:"role"`
+	Tier         string  `json:"tier,omitempty"`
+	Balance      float32 `json:"balance"`
+	Withdrawn    float32 `json:"withdrawn"`
+	Orders       int     `json:"orders"`
+	RegisteredAt string  `json:"registered_at,omitempty"`
+}
+
+type adminOrder struct {
+	Number      string  `json:"number"`
+	UserID      string  `json:"user_id"`
+	Login       string  `json:"login"`
+	Status      string  `json:"status"`
+	Accrual     float32 `json:"accrual,omitempty"`
+	UploadedAt  string  `json:"uploaded_at"`
+	ProcessedAt string  `json:"processed_at,omitempty"`
+}
+
+type adjustments struct {
+	ID         string  `json:"id"`
+	Login      string  `json:"login"`
+	Amount     float32 `json:"amount"`
+	ReasonCode string  `json:"reason_code"`
+	Reference  string  `json:"reference,omitempty"`
+	Comment    string  `json:"comment,omitempty"`
+	Status     string  `json:"status"`
+	CreatedBy  string  `json:"created_by"`
+	CreatedAt  string  `json:"created_at"`
+	DecidedBy  string  `json:"decided_by,omitempty"`
+	DecidedAt  string  `json:"decided_at,omitempty"`
+}
+
+type auditEntries struct {
+	ID        int64    `json:"id"`
+	At        string   `json:"at"`
+	Actor     string   `json:"actor"`
+	Action    string   `json:"action"`
+	Target    string   `json:"target,omitempty"`
+	IP        string   `json:"ip,omitempty"`
+	RequestID string   `json:"request_id,omitempty"`
+	Before    *float32 `json:"before,omitempty"`
+	After     *float32 `json:"after,omitempty"`
+	Details   string   `json:"details,omitempty"`
+	Hash      string   `json:"hash"`
+}
+
+type webhooks struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	CreatedAt string   `json:"created_at"`
+}
+
+type webhookDeliveries struct {
+	ID          int64              `json:"id"`
+	Event       string             `json:"event"`
+	Status      string             `json:"status"`
+	Attempts    int                `json:"attempts"`
+	CreatedAt   string             `json:"created_at"`
+	DeliveredAt string             `json:"delivered_at,omitempty"`
+	Log         []deliveryAttempts `json:"log"`
+}
+
+type deliveryAttempts struct {
+	Attempt    int    `json:"attempt"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	At         string `json:"at"`
 }
 
 type ProcessedOrders struct {
@@ -52,3 +239,138 @@ type AccuralResult struct {
 	Status  string  `json:"status"`
 	Accrual float32 `json:"accrual"`
 }
+
+type IdempotentResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+type LoginFailures struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+	// JustLocked is set when the attempt itself locked the subject.
+	JustLocked bool
+}
+
+// LoginLimits bound the login attempts of a subject: it is locked for Lockout once it has
+// MaxFailures failures, and with Delay set an attempt has to wait Delay(failures) after the last one.
+type LoginLimits struct {
+	MaxFailures int
+	Lockout     time.Duration
+	Delay       func(failures int) time.Duration
+}
+
+// AuditEntry amounts are balances in hundredths of a point, nil when the event does not change one.
+type AuditEntry struct {
+	Actor     string
+	Action    string
+	Target    string
+	IP        string
+	RequestID string
+	Before    *int64
+	After     *int64
+	Details   string
+}
+
+// AuditFilter matches Action as a prefix, empty fields match everything.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+// StatementEntry amounts are in hundredths of a point, debits are negative.
+type StatementEntry struct {
+	Kind      string
+	Reference string
+	Amount    int
+	Balance   int
+	At        time.Time
+}
+
+type BalanceDrift struct {
+	UserID          string
+	CachedBalance   int
+	LedgerBalance   int
+	CachedWithdrawn int
+	LedgerWithdrawn int
+}
+
+type UserTier struct {
+	Tier       string
+	Multiplier float64
+	Points     int
+}
+
+type Hold struct {
+	ID        string
+	Order     string
+	Amount    int
+	Status    string
+	ExpiresAt time.Time
+}
+
+// Adjustment credits (positive Amount) or debits the user's balance in hundredths of a point.
+type Adjustment struct {
+	ID         string
+	UserID     string
+	Amount     int
+	ReasonCode string
+	Reference  string
+	Comment    string
+	Status     string
+	CreatedBy  string
+}
+
+type Webhook struct {
+	ID     string
+	UserID string
+	URL    string
+	Secret string
+	Events []string
+}
+
+// WebhookDelivery is one event to send to one webhook; Attempt counts this attempt too.
+type WebhookDelivery struct {
+	ID      int64
+	Attempt int
+	URL     string
+	Secret  string
+	Event   string
+	Payload []byte
+}
+
+// Event is an outbox event; Payload is its JSON body.
+type Event struct {
+	// ID is the event's place in the dispatch order, the cursor of the event streams.
+	ID      int64
+	Type    string
+	Payload []byte
+}
+
+type DeliveryAttempt struct {
+	DeliveryID int64
+	Attempt    int
+	StatusCode int
+	Error      string
+	Duration   time.Duration
+	Delivered  bool
+}
+
+// PromoCode grants Bonus hundredths of a point (fixed) or Percent of the accrual for the next Orders orders (boost).
+type PromoCode struct {
+	Code      string
+	Kind      string
+	Bonus     int
+	Percent   float64
+	Orders    int
+	MaxUses   int
+	ValidFrom time.Time
+	ValidTo   time.Time
+	CreatedBy string
+}
@@ -9,5 +9,18 @@ var (
 	ErrGone                error = errors.New("StatusGone")
 	ErrUploaded            error = errors.New("OrdersUpladedEarlier")
 	ErrAnotherUserUploaded error = errors.New("OrdersUpladedByAnotherUser")
-	ErrNotEnouthBalance           error = errors.New("OrdersPaymentRequired")
+	ErrNotEnouthBalance    error = errors.New("OrdersPaymentRequired")
+	ErrIdempotencyMismatch error = errors.New("IdempotencyKeyReusedWithAnotherRequest")
+	ErrIdempotencyInFlight error = errors.New("IdempotencyKeyRequestInProgress")
+	ErrLocked              error = errors.New("StatusLocked")
+	ErrLoginDelayed        error = errors.New("LoginDelayed")
+	ErrNotFound            error = errors.New("StatusNotFound")
+	ErrReversed            error = errors.New("WithdrawalReversedEarlier")
+	ErrLimitExceeded       error = errors.New("DailyLimitExceeded")
+	ErrReferralCode        error = errors.New("UnknownReferralCode")
+	ErrProcessed           error = errors.New("OrderAlreadyProcessed")
+	ErrSameApprover        error = errors.New("SameApprover")
+	ErrAuditTampered       error = errors.New("AuditChainBroken")
+	ErrAuditUnsealed       error = errors.New("AuditEntriesUnsealed")
+	ErrDuplicateID         error = errors.New("GeneratedIDTaken")
 )
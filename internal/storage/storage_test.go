@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/config"
+)
+
+// newTestStorage connects to TEST_DATABASE_URI (a postgres:// URL) and creates the tables in a schema
+// of its own that is dropped after the test. Without the variable the test is skipped.
+func newTestStorage(t *testing.T) *SQLStorage {
+	t.Helper()
+	uri := os.Getenv("TEST_DATABASE_URI")
+	if uri == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	admin, err := sql.Open("pgx", uri)
+	require.NoError(t, err)
+	schema := fmt.Sprintf("gophermart_test_%d", time.Now().UnixNano())
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	strg := NewSQLStorager(&config.Config{
+		DatabaseURI:        u.String(),
+		PointsExpiryMonths: 12,
+		ExpiringSoonWindow: 30 * 24 * time.Hour,
+		ReferralBonus:      10,
+	})
+	t.Cleanup(strg.CloseDB)
+	return strg
+}
+
+func addTestUser(t *testing.T, s *SQLStorage, userID string) {
+	t.Helper()
+	err := s.AddNewUser(NewUser{UserID: userID, Login: "login-" + userID, Password: "hash", ReferralCode: "code-" + userID})
+	require.NoError(t, err)
+}
+
+// credit posts amount (hundredths of a point) of the given kind from the accruals account to the user.
+func credit(t *testing.T, s *SQLStorage, userID, kind, reference string, amount int) {
+	t.Helper()
+	tx, err := s.DB.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+	require.NoError(t, s.post(tx, kind, reference, AccountAccruals, UserAccount(userID), amount))
+	require.NoError(t, tx.Commit())
+}
+
+func balanceOf(t *testing.T, s *SQLStorage, userID string) (balance, withdrawn int) {
+	t.Helper()
+	err := s.DB.QueryRow("SELECT balance, withdrawn FROM gophermart_users WHERE user_id = $1", userID).Scan(&balance, &withdrawn)
+	require.NoError(t, err)
+	return balance, withdrawn
+}
+
+// lotsOf returns the remaining amount of every lot of the user, oldest first.
+func lotsOf(t *testing.T, s *SQLStorage, userID string) []int {
+	t.Helper()
+	rows, err := s.DB.Query("SELECT remaining FROM gophermart_lots WHERE user_id = $1 ORDER BY accrued_at, id", userID)
+	require.NoError(t, err)
+	defer rows.Close()
+	lots := make([]int, 0)
+	for rows.Next() {
+		var remaining int
+		require.NoError(t, rows.Scan(&remaining))
+		lots = append(lots, remaining)
+	}
+	require.NoError(t, rows.Err())
+	return lots
+}
+
+// ledgerBalance sums the postings of the account.
+func ledgerBalance(t *testing.T, s *SQLStorage, account string) int {
+	t.Helper()
+	var balance int
+	err := s.DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM gophermart_ledger WHERE account = $1", account).Scan(&balance)
+	require.NoError(t, err)
+	return balance
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+func tierBasisFilter(basis string) (string, error) {
+	switch basis {
+	case "accrued":
+		return "SUM(CASE WHEN kind = 'accrual' THEN amount ELSE 0 END)", nil
+	case "withdrawn":
+		return "SUM(CASE WHEN kind IN ('withdrawal', 'reversal') THEN -amount ELSE 0 END)", nil
+	}
+	return "", fmt.Errorf("unknown tier basis %q", basis)
+}
+
+// TierPoints sums the basis postings of every user since the given time and calls fn for each user
+// with the tier currently assigned.
+func (s *SQLStorage) TierPoints(basis string, since time.Time, fn func(userID, currentTier string, points int) error) error {
+	sum, err := tierBasisFilter(basis)
+	if err != nil {
+		return err
+	}
+	rows, err := s.DB.Query(`SELECT u.user_id, u.tier, COALESCE(l.points, 0) FROM gophermart_users u LEFT JOIN (
+			SELECT account, `+sum+` AS points FROM gophermart_ledger WHERE account LIKE 'user:%' AND created_at >= $1 GROUP BY account
+		) l ON l.account = 'user:' || u.user_id`, since)
+	if err != nil {
+		return err
+	}
+	type userPoints struct {
+		userID string
+		tier   string
+		points int
+	}
+	users := make([]userPoints, 0)
+	for rows.Next() {
+		var u userPoints
+		if err = rows.Scan(&u.userID, &u.tier, &u.points); err != nil {
+			rows.Close()
+			return err
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, u := range users {
+		if err = fn(u.userID, u.tier, u.points); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStorage) UserTier(userID, basis string, since time.Time) (UserTier, error) {
+	var tier UserTier
+	sum, err := tierBasisFilter(basis)
+	if err != nil {
+		return tier, err
+	}
+	err = s.DB.QueryRow("SELECT tier, tier_multiplier FROM gophermart_users WHERE user_id = $1", userID).Scan(&tier.Tier, &tier.Multiplier)
+	if err != nil {
+		return tier, err
+	}
+	err = s.DB.QueryRow("SELECT COALESCE("+sum+", 0) FROM gophermart_ledger WHERE account = $1 AND created_at >= $2", UserAccount(userID), since).Scan(&tier.Points)
+	if err != nil {
+		return tier, err
+	}
+	return tier, nil
+}
+
+func (s *SQLStorage) SetUserTier(userID, tier string, multiplier float64) error {
+	_, err := s.DB.Exec("UPDATE gophermart_users SET tier=$1, tier_multiplier=$2 WHERE user_id = $3", tier, multiplier, userID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// processOrder uploads the order for the user and lets the accrual system process it.
+func processOrder(t *testing.T, s *SQLStorage, userID, order string, accrual float32) {
+	t.Helper()
+	require.NoError(t, s.AddNewOrder(userID, order))
+	require.NoError(t, s.UpdateOrderStatus(AccuralResult{UserID: userID, Order: order, Status: "PROCESSED", Accrual: accrual}))
+}
+
+func TestTierBonus(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	require.NoError(t, s.SetUserTier("alice", "Silver", 1.1))
+
+	processOrder(t, s, "alice", "12345678903", 100)
+	// A repeated PROCESSED result credits nothing.
+	require.NoError(t, s.UpdateOrderStatus(AccuralResult{UserID: "alice", Order: "12345678903", Status: "PROCESSED", Accrual: 100}))
+
+	balance, _ := balanceOf(t, s, "alice")
+	assert.Equal(t, 11000, balance)
+	assert.Equal(t, -1000, ledgerBalance(t, s, AccountTierBonuses))
+	assert.Equal(t, []int{10000, 1000}, lotsOf(t, s, "alice"))
+
+	since := time.Now().Add(-time.Hour)
+	tier, err := s.UserTier("alice", "accrued", since)
+	require.NoError(t, err)
+	assert.Equal(t, UserTier{Tier: "Silver", Multiplier: 1.1, Points: 10000}, tier, "tier bonuses do not count towards the tier")
+
+	require.NoError(t, s.UserWithdraw("alice", "2377225624", 20))
+	points := make(map[string]int)
+	err = s.TierPoints("withdrawn", since, func(userID, currentTier string, p int) error {
+		points[userID] = p
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"alice": 2000}, points)
+
+	_, err = s.UserTier("alice", "spent", since)
+	assert.Error(t, err)
+}
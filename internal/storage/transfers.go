@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+func createTransfers(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS gophermart_transfers(transfer_id text UNIQUE, from_user text NOT NULL, to_user text NOT NULL, amount bigint NOT NULL, comment text DEFAULT '', created_at timestamptz DEFAULT now());")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_transfers_from_idx ON gophermart_transfers(from_user, created_at);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_transfers_to_idx ON gophermart_transfers(to_user, created_at);")
+	return err
+}
+
+// TransferPoints moves points to the user with the given login. Outgoing transfers of the last
+// 24 hours are limited by dailyAmount (hundredths of a point) and dailyCount, zero means no limit.
+func (s *SQLStorage) TransferPoints(userID, transferID, toLogin string, sum float32, comment string, dailyAmount, dailyCount int) error {
+	amount := int(sum * 100)
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var toUser string
+	err = tx.QueryRow("SELECT user_id FROM gophermart_users WHERE login = $1", toLogin).Scan(&toUser)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if toUser == userID {
+		return ErrConflict
+	}
+
+	// Both rows are locked in user_id order so opposite transfers can not deadlock.
+	first, second := userID, toUser
+	if second < first {
+		first, second = second, first
+	}
+	balances := make(map[string]int, 2)
+	for _, id := range []string{first, second} {
+		if balances[id], err = lockBalance(tx, id); err != nil {
+			return err
+		}
+	}
+	held, err := heldAmount(tx, userID)
+	if err != nil {
+		return err
+	}
+	if balances[userID]-held < amount {
+		return ErrNotEnouthBalance
+	}
+
+	var sent, count int
+	err = tx.QueryRow("SELECT COALESCE(SUM(amount), 0), COUNT(*) FROM gophermart_transfers WHERE from_user = $1 AND created_at > $2", userID, time.Now().Add(-24*time.Hour)).Scan(&sent, &count)
+	if err != nil {
+		return err
+	}
+	if (dailyAmount > 0 && sent+amount > dailyAmount) || (dailyCount > 0 && count+1 > dailyCount) {
+		return ErrLimitExceeded
+	}
+
+	_, err = tx.Exec("INSERT INTO gophermart_transfers(transfer_id, from_user, to_user, amount, comment) VALUES($1, $2, $3, $4, $5)", transferID, userID, toUser, amount, comment)
+	if err != nil {
+		return err
+	}
+	if err = s.post(tx, PostingTransfer, transferID, UserAccount(userID), UserAccount(toUser), amount); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStorage) UserTransfers(userID string) ([]byte, error) {
+	rows, err := s.DB.Query(`SELECT t.transfer_id, t.from_user, fu.login, tu.login, t.amount, t.comment, t.created_at
+		FROM gophermart_transfers t
+		JOIN gophermart_users fu ON fu.user_id = t.from_user
+		JOIN gophermart_users tu ON tu.user_id = t.to_user
+		WHERE t.from_user = $1 OR t.to_user = $1 ORDER BY t.created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	currentUserTransfers := make([]transfers, 0)
+	for rows.Next() {
+		var id, fromUser, fromLogin, toLogin, comment string
+		var amount int
+		var createdAt time.Time
+		if err = rows.Scan(&id, &fromUser, &fromLogin, &toLogin, &amount, &comment, &createdAt); err != nil {
+			return nil, err
+		}
+		transfer := transfers{ID: id, Sum: float32(amount) / 100, Comment: comment, ProcessedAt: createdAt.Format(time.RFC3339)}
+		if fromUser == userID {
+			transfer.Direction = "out"
+			transfer.Counterparty = toLogin
+		} else {
+			transfer.Direction = "in"
+			transfer.Counterparty = fromLogin
+		}
+		currentUserTransfers = append(currentUserTransfers, transfer)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(currentUserTransfers) == 0 {
+		return nil, ErrNoContent
+	}
+	return json.Marshal(currentUserTransfers)
+}
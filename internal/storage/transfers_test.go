@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferKeepsExpiry(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	addTestUser(t, s, "bob")
+	credit(t, s, "alice", PostingAccrual, "12345678903", 1000)
+	credit(t, s, "alice", PostingAdjustment, "manual", 500)
+
+	require.NoError(t, s.TransferPoints("alice", "transfer-1", "login-bob", 12, "", 0, 0))
+	assert.Equal(t, []int{0, 300}, lotsOf(t, s, "alice"))
+	assert.Equal(t, []int{1000, 200}, lotsOf(t, s, "bob"))
+
+	var expiring int
+	require.NoError(t, s.DB.QueryRow(`SELECT COUNT(*) FROM gophermart_lots b JOIN gophermart_lots a
+		ON a.user_id = 'alice' AND a.kind = $1 AND a.expires_at = b.expires_at
+		WHERE b.user_id = 'bob' AND b.amount = 1000`, PostingAccrual).Scan(&expiring))
+	assert.Equal(t, 1, expiring, "the accrual share keeps the sender's expiry")
+
+	// Once the sender's accrual expires the transferred part of it expires with the receiver too.
+	expireLots(t, s, "bob")
+	expired, err := s.ExpirePoints(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	balance, _ := balanceOf(t, s, "bob")
+	assert.Equal(t, 200, balance)
+	balance, _ = balanceOf(t, s, "alice")
+	assert.Equal(t, 300, balance)
+}
+
+func TestTransferPointsLimits(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	addTestUser(t, s, "bob")
+	credit(t, s, "alice", PostingAccrual, "12345678903", 1000)
+
+	assert.ErrorIs(t, s.TransferPoints("alice", "transfer-1", "login-nobody", 1, "", 0, 0), ErrNotFound)
+	assert.ErrorIs(t, s.TransferPoints("alice", "transfer-1", "login-alice", 1, "", 0, 0), ErrConflict)
+	assert.ErrorIs(t, s.TransferPoints("alice", "transfer-1", "login-bob", 11, "", 0, 0), ErrNotEnouthBalance)
+	require.NoError(t, s.TransferPoints("alice", "transfer-1", "login-bob", 1, "", 0, 1))
+	assert.ErrorIs(t, s.TransferPoints("alice", "transfer-2", "login-bob", 1, "", 0, 1), ErrLimitExceeded)
+	assert.ErrorIs(t, s.TransferPoints("alice", "transfer-2", "login-bob", 2, "", 250, 0), ErrLimitExceeded)
+}
@@ -0,0 +1,361 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EventOrderProcessed    = "order.processed"
+	EventOrderInvalid      = "order.invalid"
+	EventWithdrawalCreated = "withdrawal.created"
+	EventOrderUpdated      = "order.updated"
+	EventBalanceChanged    = "balance.changed"
+)
+
+// WebhookEvents lists the event types a webhook can subscribe to.
+var WebhookEvents = map[string]bool{
+	EventOrderProcessed:    true,
+	EventOrderInvalid:      true,
+	EventWithdrawalCreated: true,
+}
+
+const (
+	DeliveryPending   = "PENDING"
+	DeliveryDelivered = "DELIVERED"
+	DeliveryFailed    = "FAILED"
+)
+
+const outboxPruneBatch = 1000
+
+// Events are written to gophermart_outbox in the same transaction as the change they describe,
+// then fanned out to one delivery per matching webhook.
+func createWebhooks(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS gophermart_webhooks(webhook_id text UNIQUE, user_id text NOT NULL, url text NOT NULL, secret text NOT NULL, events text NOT NULL, created_at timestamptz DEFAULT now(), deleted_at timestamptz);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_outbox(id bigserial PRIMARY KEY, user_id text NOT NULL, event text NOT NULL, payload jsonb NOT NULL, created_at timestamptz DEFAULT now(), dispatched_at timestamptz);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_outbox_pending_idx ON gophermart_outbox(id) WHERE dispatched_at IS NULL;")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_outbox_dispatched_idx ON gophermart_outbox(dispatched_at) WHERE dispatched_at IS NOT NULL;")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_outbox_user_idx ON gophermart_outbox(user_id, id);")
+	if err != nil {
+		return err
+	}
+	// seq orders events by the commit of the dispatch, unlike id which follows the insert order of
+	// transactions that may commit in another order. Events dispatched before seq existed keep their id.
+	_, err = db.Exec("ALTER TABLE gophermart_outbox ADD COLUMN IF NOT EXISTS seq bigint;")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE SEQUENCE IF NOT EXISTS gophermart_outbox_seq;")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_outbox_user_seq_idx ON gophermart_outbox(user_id, seq);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE gophermart_outbox SET seq = id WHERE seq IS NULL AND dispatched_at IS NOT NULL;")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("SELECT setval('gophermart_outbox_seq', GREATEST((SELECT MAX(seq) FROM gophermart_outbox), (SELECT last_value FROM gophermart_outbox_seq), 1));")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_webhook_deliveries(id bigserial PRIMARY KEY, webhook_id text NOT NULL, event_id bigint NOT NULL, status text DEFAULT 'PENDING', attempts integer DEFAULT 0, next_attempt_at timestamptz DEFAULT now(), created_at timestamptz DEFAULT now(), delivered_at timestamptz, UNIQUE(webhook_id, event_id));")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_webhook_deliveries_due_idx ON gophermart_webhook_deliveries(next_attempt_at) WHERE status = 'PENDING';")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_webhook_deliveries_event_idx ON gophermart_webhook_deliveries(event_id);")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS gophermart_webhook_attempts(delivery_id bigint NOT NULL, attempt integer NOT NULL, status_code integer DEFAULT 0, error text DEFAULT '', duration_ms bigint DEFAULT 0, created_at timestamptz DEFAULT now());")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS gophermart_webhook_attempts_delivery_idx ON gophermart_webhook_attempts(delivery_id);")
+	if err != nil {
+		return err
+	}
+	// Response bodies used to be kept as the error of failed attempts, only the status code is kept now.
+	return migrateOnce(db, "webhook_attempts_drop_bodies", func(tx *sql.Tx) error {
+		_, err := tx.Exec("UPDATE gophermart_webhook_attempts SET error = '' WHERE status_code <> 0 AND error <> '';")
+		return err
+	})
+}
+
+type orderEvent struct {
+	Event   string  `json:"event"`
+	Order   string  `json:"order"`
+	Status  string  `json:"status"`
+	Accrual float32 `json:"accrual,omitempty"`
+	At      string  `json:"at"`
+}
+
+type withdrawalEvent struct {
+	Event string  `json:"event"`
+	Order string  `json:"order"`
+	Sum   float32 `json:"sum"`
+	At    string  `json:"at"`
+}
+
+// writeOutbox queues the event inside tx, so it is published, to webhooks and event streams,
+// only if tx commits. The notification makes the listeners dispatch it right away.
+func writeOutbox(tx *sql.Tx, userID, event string, payload interface{}) error {
+	payloadBZ, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var id int64
+	err = tx.QueryRow("INSERT INTO gophermart_outbox(user_id, event, payload) VALUES($1, $2, $3) RETURNING id", userID, event, payloadBZ).Scan(&id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("SELECT pg_notify($1, $2)", eventsChannel, userID+":"+strconv.FormatInt(id, 10))
+	return err
+}
+
+func (s *SQLStorage) AddWebhook(webhook Webhook) error {
+	_, err := s.DB.Exec("INSERT INTO gophermart_webhooks(webhook_id, user_id, url, secret, events) VALUES($1, $2, $3, $4, $5)",
+		webhook.ID, webhook.UserID, webhook.URL, webhook.Secret, strings.Join(webhook.Events, ","))
+	return err
+}
+
+func (s *SQLStorage) DeleteWebhook(userID, webhookID string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("UPDATE gophermart_webhooks SET deleted_at=now() WHERE webhook_id = $1 AND user_id = $2 AND deleted_at IS NULL", webhookID, userID)
+	if err != nil {
+		return err
+	}
+	changes, _ := result.RowsAffected()
+	if changes == 0 {
+		return ErrNotFound
+	}
+	_, err = tx.Exec("UPDATE gophermart_webhook_deliveries SET status=$1 WHERE webhook_id = $2 AND status = $3", DeliveryFailed, webhookID, DeliveryPending)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStorage) UserWebhooks(userID string) ([]byte, error) {
+	rows, err := s.DB.Query("SELECT webhook_id, url, events, created_at FROM gophermart_webhooks WHERE user_id = $1 AND deleted_at IS NULL ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]webhooks, 0)
+	for rows.Next() {
+		var entry webhooks
+		var events string
+		var createdAt time.Time
+		if err = rows.Scan(&entry.ID, &entry.URL, &events, &createdAt); err != nil {
+			return nil, err
+		}
+		entry.Events = strings.Split(events, ",")
+		entry.CreatedAt = createdAt.Format(time.RFC3339)
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(entries)
+}
+
+// WebhookDeliveries returns the latest deliveries of the user's webhook with every attempt made.
+func (s *SQLStorage) WebhookDeliveries(userID, webhookID string) ([]byte, error) {
+	var exists bool
+	err := s.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM gophermart_webhooks WHERE webhook_id = $1 AND user_id = $2)", webhookID, userID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	rows, err := s.DB.Query(`SELECT d.id, o.event, d.status, d.attempts, d.created_at, d.delivered_at, COALESCE(a.attempt, 0), COALESCE(a.status_code, 0), COALESCE(a.error, ''), COALESCE(a.duration_ms, 0), a.created_at
+		FROM (SELECT * FROM gophermart_webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT 100) d
+		JOIN gophermart_outbox o ON o.id = d.event_id
+		LEFT JOIN gophermart_webhook_attempts a ON a.delivery_id = d.id
+		ORDER BY d.id DESC, a.attempt`, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]webhookDeliveries, 0)
+	for rows.Next() {
+		var entry webhookDeliveries
+		var attempt deliveryAttempts
+		var createdAt time.Time
+		var deliveredAt, attemptedAt sql.NullTime
+		err = rows.Scan(&entry.ID, &entry.Event, &entry.Status, &entry.Attempts, &createdAt, &deliveredAt,
+			&attempt.Attempt, &attempt.StatusCode, &attempt.Error, &attempt.DurationMS, &attemptedAt)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 || entries[len(entries)-1].ID != entry.ID {
+			entry.CreatedAt = createdAt.Format(time.RFC3339)
+			if deliveredAt.Valid {
+				entry.DeliveredAt = deliveredAt.Time.Format(time.RFC3339)
+			}
+			entry.Log = make([]deliveryAttempts, 0)
+			entries = append(entries, entry)
+		}
+		if attemptedAt.Valid {
+			attempt.At = attemptedAt.Time.Format(time.RFC3339)
+			last := &entries[len(entries)-1]
+			last.Log = append(last.Log, attempt)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(entries)
+}
+
+// DispatchOutbox creates deliveries for the queued events, numbers them for the event streams and
+// returns how many events were dispatched. Dispatches are serialized up to their commit, so a
+// reader that sees an event's seq also sees every smaller one.
+func (s *SQLStorage) DispatchOutbox() (int, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", outboxLockKey); err != nil {
+		return 0, err
+	}
+	rows, err := tx.Query("SELECT id, user_id, event FROM gophermart_outbox WHERE dispatched_at IS NULL ORDER BY id LIMIT 500 FOR UPDATE")
+	if err != nil {
+		return 0, err
+	}
+	type event struct {
+		id     int64
+		userID string
+		event  string
+	}
+	events := make([]event, 0)
+	for rows.Next() {
+		var e event
+		if err = rows.Scan(&e.id, &e.userID, &e.event); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, e := range events {
+		_, err = tx.Exec(`INSERT INTO gophermart_webhook_deliveries(webhook_id, event_id)
+			SELECT webhook_id, $1 FROM gophermart_webhooks WHERE user_id = $2 AND deleted_at IS NULL AND $3 = ANY(string_to_array(events, ','))
+			ON CONFLICT DO NOTHING`, e.id, e.userID, e.event)
+		if err != nil {
+			return 0, err
+		}
+		if _, err = tx.Exec("UPDATE gophermart_outbox SET dispatched_at=now(), seq=nextval('gophermart_outbox_seq') WHERE id = $1", e.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(events), tx.Commit()
+}
+
+// ClaimDeliveries returns up to limit due deliveries and postpones them by lease, so other
+// instances skip them while they are being sent.
+func (s *SQLStorage) ClaimDeliveries(limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	rows, err := s.DB.Query(`UPDATE gophermart_webhook_deliveries d SET next_attempt_at = now() + $2 * interval '1 millisecond', attempts = d.attempts + 1
+		FROM gophermart_webhooks w, gophermart_outbox o
+		WHERE d.id IN (SELECT id FROM gophermart_webhook_deliveries WHERE status = 'PENDING' AND next_attempt_at <= now() ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED)
+			AND w.webhook_id = d.webhook_id AND o.id = d.event_id
+		RETURNING d.id, d.attempts, w.url, w.secret, o.event, o.payload`, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery WebhookDelivery
+		if err = rows.Scan(&delivery.ID, &delivery.Attempt, &delivery.URL, &delivery.Secret, &delivery.Event, &delivery.Payload); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// RecordDeliveryAttempt logs the attempt and settles the delivery: delivered, failed for good when
+// nextAttempt is zero, or retried at nextAttempt.
+func (s *SQLStorage) RecordDeliveryAttempt(attempt DeliveryAttempt, nextAttempt time.Time) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("INSERT INTO gophermart_webhook_attempts(delivery_id, attempt, status_code, error, duration_ms) VALUES($1, $2, $3, $4, $5)",
+		attempt.DeliveryID, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.Duration.Milliseconds())
+	if err != nil {
+		return err
+	}
+	switch {
+	case attempt.Delivered:
+		_, err = tx.Exec("UPDATE gophermart_webhook_deliveries SET status=$1, delivered_at=now() WHERE id = $2", DeliveryDelivered, attempt.DeliveryID)
+	case nextAttempt.IsZero():
+		_, err = tx.Exec("UPDATE gophermart_webhook_deliveries SET status=$1 WHERE id = $2", DeliveryFailed, attempt.DeliveryID)
+	default:
+		_, err = tx.Exec("UPDATE gophermart_webhook_deliveries SET next_attempt_at=$1 WHERE id = $2 AND status = $3", nextAttempt, attempt.DeliveryID, DeliveryPending)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PruneOutbox deletes the events dispatched before dispatchedBefore whose deliveries are settled,
+// along with the deliveries and their attempts, and returns how many events it deleted.
+func (s *SQLStorage) PruneOutbox(dispatchedBefore time.Time) (int, error) {
+	pruned := 0
+	for {
+		result, err := s.DB.Exec(`WITH old AS (
+				SELECT id FROM gophermart_outbox o WHERE dispatched_at < $1
+					AND NOT EXISTS(SELECT 1 FROM gophermart_webhook_deliveries d WHERE d.event_id = o.id AND d.status = $2)
+				ORDER BY dispatched_at LIMIT $3
+			), attempts AS (
+				DELETE FROM gophermart_webhook_attempts a USING gophermart_webhook_deliveries d, old WHERE a.delivery_id = d.id AND d.event_id = old.id
+			), deliveries AS (
+				DELETE FROM gophermart_webhook_deliveries d USING old WHERE d.event_id = old.id
+			)
+			DELETE FROM gophermart_outbox o USING old WHERE o.id = old.id`, dispatchedBefore, DeliveryPending, outboxPruneBatch)
+		if err != nil {
+			return pruned, err
+		}
+		batch, _ := result.RowsAffected()
+		pruned += int(batch)
+		if batch < outboxPruneBatch {
+			return pruned, nil
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func outboxCount(t *testing.T, s *SQLStorage) int {
+	t.Helper()
+	var count int
+	require.NoError(t, s.DB.QueryRow("SELECT count(*) FROM gophermart_outbox").Scan(&count))
+	return count
+}
+
+func TestPruneOutbox(t *testing.T) {
+	s := newTestStorage(t)
+	addTestUser(t, s, "alice")
+	require.NoError(t, s.AddWebhook(Webhook{ID: "hook", UserID: "alice", URL: "https://example.com", Secret: "secret", Events: []string{EventOrderProcessed}}))
+	for _, order := range []string{"12345678903", "79927398713"} {
+		processOrder(t, s, "alice", order, 10)
+	}
+	_, err := s.DispatchOutbox()
+	require.NoError(t, err)
+
+	deliveries, err := s.ClaimDeliveries(1, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.NoError(t, s.RecordDeliveryAttempt(DeliveryAttempt{DeliveryID: deliveries[0].ID, Attempt: 1, StatusCode: 200, Delivered: true}, time.Time{}))
+
+	pruned, err := s.PruneOutbox(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, pruned, "recent events are kept")
+
+	events := outboxCount(t, s)
+	pruned, err = s.PruneOutbox(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, events-1, pruned)
+	assert.Equal(t, 1, outboxCount(t, s), "the event with a pending delivery is kept")
+	var attempts int
+	require.NoError(t, s.DB.QueryRow("SELECT count(*) FROM gophermart_webhook_attempts").Scan(&attempts))
+	assert.Zero(t, attempts)
+}
@@ -0,0 +1,82 @@
+package tier
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/metrics"
+	"gophermart/internal/storage"
+)
+
+// Period is the rolling window the tier basis is summed over.
+const Period = 12
+
+// Recalculator periodically assigns every user the tier matching the points of the last Period months.
+type Recalculator struct {
+	tiers    Tiers
+	basis    string
+	interval time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+func NewRecalculator(tiers Tiers, basis string, interval time.Duration) *Recalculator {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Recalculator{
+		tiers:    tiers,
+		basis:    basis,
+		interval: interval,
+		ctx:      ctx,
+		cancel:   cancel,
+		finished: make(chan struct{}),
+	}
+}
+
+func (rc *Recalculator) Run(strg storage.Storager) {
+	go func() {
+		log.Debug().Msg("tier Recalculator started")
+		metrics.WorkerStarted("tier_recalculator")
+		ticker := time.NewTicker(rc.interval)
+		defer ticker.Stop()
+	loop:
+		for {
+			rc.recalculate(strg)
+			metrics.WorkerRan("tier_recalculator")
+			select {
+			case <-rc.ctx.Done():
+				break loop
+			case <-ticker.C:
+			}
+		}
+		metrics.WorkerStopped("tier_recalculator")
+		close(rc.finished)
+		log.Debug().Msg("tier Recalculator finished")
+	}()
+}
+
+func (rc *Recalculator) recalculate(strg storage.Storager) {
+	changed := 0
+	since := time.Now().AddDate(0, -Period, 0)
+	err := strg.TierPoints(rc.basis, since, func(userID, current string, points int) error {
+		t, _ := rc.tiers.For(points)
+		if t.Name == current {
+			return nil
+		}
+		changed++
+		return strg.SetUserTier(userID, t.Name, t.Multiplier)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("tier Recalculator TierPoints error")
+	}
+	if changed != 0 {
+		log.Info().Msgf("tier Recalculator changed tier of %d users", changed)
+	}
+}
+
+func (rc *Recalculator) Stop() {
+	rc.cancel()
+	<-rc.finished
+}
@@ -0,0 +1,70 @@
+package tier
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	BasisAccrued   = "accrued"
+	BasisWithdrawn = "withdrawn"
+)
+
+type Tier struct {
+	Name       string
+	Threshold  int
+	Multiplier float64
+}
+
+// Tiers are sorted by threshold, the first one starts at zero.
+type Tiers []Tier
+
+// Parse reads tiers written as "<name>:<threshold points>:<multiplier>" separated by commas,
+// e.g. "Bronze:0:1,Silver:1000:1.05,Gold:5000:1.1". An empty string disables tiers.
+func Parse(value string) (Tiers, error) {
+	tiers := make(Tiers, 0)
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return tiers, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("tier %q: expected <name>:<threshold>:<multiplier>", item)
+		}
+		threshold, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("tier %s: wrong threshold %q", parts[0], parts[1])
+		}
+		multiplier, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || multiplier < 1 {
+			return nil, fmt.Errorf("tier %s: multiplier must be a number not less than 1, got %q", parts[0], parts[2])
+		}
+		tiers = append(tiers, Tier{Name: parts[0], Threshold: int(threshold * 100), Multiplier: multiplier})
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Threshold < tiers[j].Threshold })
+	if tiers[0].Threshold != 0 {
+		return nil, errors.New("the lowest tier must start at 0")
+	}
+	return tiers, nil
+}
+
+// For returns the tier reached with points (in hundredths) and the next one, if any.
+func (ts Tiers) For(points int) (Tier, *Tier) {
+	if len(ts) == 0 {
+		return Tier{Multiplier: 1}, nil
+	}
+	current := 0
+	for i, t := range ts {
+		if points >= t.Threshold {
+			current = i
+		}
+	}
+	if current+1 < len(ts) {
+		return ts[current], &ts[current+1]
+	}
+	return ts[current], nil
+}
@@ -0,0 +1,103 @@
+package tier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/storage"
+)
+
+func TestParse(t *testing.T) {
+	tiers, err := Parse(" Gold:5000:1.1, Bronze:0:1,Silver:1000.5:1.05 ")
+	require.NoError(t, err)
+	assert.Equal(t, Tiers{
+		{Name: "Bronze", Threshold: 0, Multiplier: 1},
+		{Name: "Silver", Threshold: 100050, Multiplier: 1.05},
+		{Name: "Gold", Threshold: 500000, Multiplier: 1.1},
+	}, tiers)
+
+	tiers, err = Parse("")
+	require.NoError(t, err)
+	assert.Empty(t, tiers)
+
+	for _, value := range []string{
+		"Bronze:0",
+		":0:1",
+		"Bronze:zero:1",
+		"Bronze:-1:1",
+		"Bronze:0:0.9",
+		"Bronze:0:x",
+		"Silver:1000:1.05",
+	} {
+		_, err = Parse(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestFor(t *testing.T) {
+	tiers, err := Parse("Bronze:0:1,Silver:1000:1.05,Gold:5000:1.1")
+	require.NoError(t, err)
+
+	tests := []struct {
+		points  int
+		current string
+		next    string
+	}{
+		{points: 0, current: "Bronze", next: "Silver"},
+		{points: 99999, current: "Bronze", next: "Silver"},
+		{points: 100000, current: "Silver", next: "Gold"},
+		{points: 500000, current: "Gold"},
+		{points: 10000000, current: "Gold"},
+	}
+	for _, tt := range tests {
+		current, next := tiers.For(tt.points)
+		assert.Equal(t, tt.current, current.Name, tt.points)
+		if tt.next == "" {
+			assert.Nil(t, next, tt.points)
+		} else if assert.NotNil(t, next, tt.points) {
+			assert.Equal(t, tt.next, next.Name, tt.points)
+		}
+	}
+
+	current, next := Tiers{}.For(100)
+	assert.Equal(t, Tier{Multiplier: 1}, current)
+	assert.Nil(t, next)
+}
+
+type fakeStorage struct {
+	storage.Storager
+	points map[string]int
+	tiers  map[string]string
+	set    map[string]float64
+}
+
+func (s *fakeStorage) TierPoints(basis string, since time.Time, fn func(userID, currentTier string, points int) error) error {
+	for userID, points := range s.points {
+		if err := fn(userID, s.tiers[userID], points); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorage) SetUserTier(userID, tier string, multiplier float64) error {
+	s.set[userID] = multiplier
+	s.tiers[userID] = tier
+	return nil
+}
+
+func TestRecalculate(t *testing.T) {
+	tiers, err := Parse("Bronze:0:1,Silver:1000:1.05")
+	require.NoError(t, err)
+	strg := &fakeStorage{
+		points: map[string]int{"alice": 150000, "bob": 100, "carol": 200000},
+		tiers:  map[string]string{"alice": "Bronze", "bob": "Bronze", "carol": "Silver"},
+		set:    make(map[string]float64),
+	}
+	NewRecalculator(tiers, BasisAccrued, time.Hour).recalculate(strg)
+	assert.Equal(t, map[string]float64{"alice": 1.05}, strg.set, "only changed tiers are written")
+	assert.Equal(t, "Silver", strg.tiers["alice"])
+}
@@ -0,0 +1,420 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"gophermart/internal/storage"
+)
+
+// Storage records a span for every call of the wrapped storage, as a child of the span in the
+// context it is bound to with storage.WithContext.
+type Storage struct {
+	strg storage.Storager
+	ctx  context.Context
+}
+
+func NewStorage(strg storage.Storager) *Storage {
+	return &Storage{strg: strg, ctx: context.Background()}
+}
+
+func (s *Storage) WithContext(ctx context.Context) storage.Storager {
+	return &Storage{strg: s.strg, ctx: ctx}
+}
+
+func (s *Storage) start(method string) trace.Span {
+	_, span := tracer().Start(s.ctx, "storage."+method, trace.WithSpanKind(trace.SpanKindClient))
+	return span
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *Storage) AddNewUser(user storage.NewUser) (err error) {
+	span := s.start("AddNewUser")
+	defer func() { end(span, err) }()
+	return s.strg.AddNewUser(user)
+}
+
+func (s *Storage) LogInUser(login, password string) (_ string, err error) {
+	span := s.start("LogInUser")
+	defer func() { end(span, err) }()
+	return s.strg.LogInUser(login, password)
+}
+
+func (s *Storage) CheckUser(userID string) (err error) {
+	span := s.start("CheckUser")
+	defer func() { end(span, err) }()
+	return s.strg.CheckUser(userID)
+}
+
+func (s *Storage) AddNewOrder(userID, orders string) (err error) {
+	span := s.start("AddNewOrder")
+	defer func() { end(span, err) }()
+	return s.strg.AddNewOrder(userID, orders)
+}
+
+func (s *Storage) AddNewOrders(userID string, orders []string) (_ []error, err error) {
+	span := s.start("AddNewOrders")
+	defer func() { end(span, err) }()
+	return s.strg.AddNewOrders(userID, orders)
+}
+
+func (s *Storage) UserWithdraw(userID, order string, sum float32) (err error) {
+	span := s.start("UserWithdraw")
+	defer func() { end(span, err) }()
+	return s.strg.UserWithdraw(userID, order, sum)
+}
+
+func (s *Storage) ReverseWithdrawal(userID, order string, window time.Duration, actor string) (err error) {
+	span := s.start("ReverseWithdrawal")
+	defer func() { end(span, err) }()
+	return s.strg.ReverseWithdrawal(userID, order, window, actor)
+}
+
+func (s *Storage) CreateHold(userID, holdID, order string, sum float32, expiresAt time.Time) (err error) {
+	span := s.start("CreateHold")
+	defer func() { end(span, err) }()
+	return s.strg.CreateHold(userID, holdID, order, sum, expiresAt)
+}
+
+func (s *Storage) CaptureHold(userID, holdID string) (err error) {
+	span := s.start("CaptureHold")
+	defer func() { end(span, err) }()
+	return s.strg.CaptureHold(userID, holdID)
+}
+
+func (s *Storage) VoidHold(userID, holdID string) (err error) {
+	span := s.start("VoidHold")
+	defer func() { end(span, err) }()
+	return s.strg.VoidHold(userID, holdID)
+}
+
+func (s *Storage) ExpireHolds(now time.Time) (_ int, err error) {
+	span := s.start("ExpireHolds")
+	defer func() { end(span, err) }()
+	return s.strg.ExpireHolds(now)
+}
+
+func (s *Storage) TransferPoints(userID, transferID, toLogin string, sum float32, comment string, dailyAmount, dailyCount int) (err error) {
+	span := s.start("TransferPoints")
+	defer func() { end(span, err) }()
+	return s.strg.TransferPoints(userID, transferID, toLogin, sum, comment, dailyAmount, dailyCount)
+}
+
+func (s *Storage) UserTransfers(userID string) (_ []byte, err error) {
+	span := s.start("UserTransfers")
+	defer func() { end(span, err) }()
+	return s.strg.UserTransfers(userID)
+}
+
+func (s *Storage) AddPromoCode(promo storage.PromoCode) (err error) {
+	span := s.start("AddPromoCode")
+	defer func() { end(span, err) }()
+	return s.strg.AddPromoCode(promo)
+}
+
+func (s *Storage) RedeemPromoCode(userID, code string) (_ storage.PromoCode, err error) {
+	span := s.start("RedeemPromoCode")
+	defer func() { end(span, err) }()
+	return s.strg.RedeemPromoCode(userID, code)
+}
+
+func (s *Storage) UserReferrals(userID string) (_ []byte, err error) {
+	span := s.start("UserReferrals")
+	defer func() { end(span, err) }()
+	return s.strg.UserReferrals(userID)
+}
+
+func (s *Storage) UserRole(userID string) (_ string, err error) {
+	span := s.start("UserRole")
+	defer func() { end(span, err) }()
+	return s.strg.UserRole(userID)
+}
+
+func (s *Storage) SetUserRole(login, role string) (err error) {
+	span := s.start("SetUserRole")
+	defer func() { end(span, err) }()
+	return s.strg.SetUserRole(login, role)
+}
+
+func (s *Storage) UserIDByLogin(login string) (_ string, err error) {
+	span := s.start("UserIDByLogin")
+	defer func() { end(span, err) }()
+	return s.strg.UserIDByLogin(login)
+}
+
+func (s *Storage) AdminUser(login string) (_ []byte, err error) {
+	span := s.start("AdminUser")
+	defer func() { end(span, err) }()
+	return s.strg.AdminUser(login)
+}
+
+func (s *Storage) AdminOrder(order string) (_ []byte, err error) {
+	span := s.start("AdminOrder")
+	defer func() { end(span, err) }()
+	return s.strg.AdminOrder(order)
+}
+
+func (s *Storage) OverrideOrderStatus(order, status string, accrual float32) (err error) {
+	span := s.start("OverrideOrderStatus")
+	defer func() { end(span, err) }()
+	return s.strg.OverrideOrderStatus(order, status, accrual)
+}
+
+func (s *Storage) CreateAdjustment(adjustment storage.Adjustment) (err error) {
+	span := s.start("CreateAdjustment")
+	defer func() { end(span, err) }()
+	return s.strg.CreateAdjustment(adjustment)
+}
+
+func (s *Storage) ApproveAdjustment(id, approver string) (_ storage.Adjustment, err error) {
+	span := s.start("ApproveAdjustment")
+	defer func() { end(span, err) }()
+	return s.strg.ApproveAdjustment(id, approver)
+}
+
+func (s *Storage) RejectAdjustment(id, approver string) (_ storage.Adjustment, err error) {
+	span := s.start("RejectAdjustment")
+	defer func() { end(span, err) }()
+	return s.strg.RejectAdjustment(id, approver)
+}
+
+func (s *Storage) Adjustments(status string) (_ []byte, err error) {
+	span := s.start("Adjustments")
+	defer func() { end(span, err) }()
+	return s.strg.Adjustments(status)
+}
+
+func (s *Storage) UserBalance(userID string) (_ []byte, err error) {
+	span := s.start("UserBalance")
+	defer func() { end(span, err) }()
+	return s.strg.UserBalance(userID)
+}
+
+func (s *Storage) UserOrders(userID string) (_ []byte, err error) {
+	span := s.start("UserOrders")
+	defer func() { end(span, err) }()
+	return s.strg.UserOrders(userID)
+}
+
+func (s *Storage) UserWithdrawals(userID string) (_ []byte, err error) {
+	span := s.start("UserWithdrawals")
+	defer func() { end(span, err) }()
+	return s.strg.UserWithdrawals(userID)
+}
+
+func (s *Storage) UserStatement(userID string, from, to time.Time, fn func(storage.StatementEntry) error) (err error) {
+	span := s.start("UserStatement")
+	defer func() { end(span, err) }()
+	return s.strg.UserStatement(userID, from, to, fn)
+}
+
+func (s *Storage) GetProcessedOrders() (_ []storage.ProcessedOrders, err error) {
+	span := s.start("GetProcessedOrders")
+	defer func() { end(span, err) }()
+	return s.strg.GetProcessedOrders()
+}
+
+func (s *Storage) UpdateOrderStatus(result storage.AccuralResult) (err error) {
+	span := s.start("UpdateOrderStatus")
+	defer func() { end(span, err) }()
+	return s.strg.UpdateOrderStatus(result)
+}
+
+func (s *Storage) ReconcileBalances(fix bool) (_ []storage.BalanceDrift, err error) {
+	span := s.start("ReconcileBalances")
+	defer func() { end(span, err) }()
+	return s.strg.ReconcileBalances(fix)
+}
+
+func (s *Storage) ExpirePoints(now time.Time) (_ int, err error) {
+	span := s.start("ExpirePoints")
+	defer func() { end(span, err) }()
+	return s.strg.ExpirePoints(now)
+}
+
+func (s *Storage) TierPoints(basis string, since time.Time, fn func(userID, currentTier string, points int) error) (err error) {
+	span := s.start("TierPoints")
+	defer func() { end(span, err) }()
+	return s.strg.TierPoints(basis, since, fn)
+}
+
+func (s *Storage) UserTier(userID, basis string, since time.Time) (_ storage.UserTier, err error) {
+	span := s.start("UserTier")
+	defer func() { end(span, err) }()
+	return s.strg.UserTier(userID, basis, since)
+}
+
+func (s *Storage) SetUserTier(userID, tier string, multiplier float64) (err error) {
+	span := s.start("SetUserTier")
+	defer func() { end(span, err) }()
+	return s.strg.SetUserTier(userID, tier, multiplier)
+}
+
+func (s *Storage) ReserveIdempotencyKey(userID, key, fingerprint string, expiredBefore time.Time) (_ *storage.IdempotentResponse, err error) {
+	span := s.start("ReserveIdempotencyKey")
+	defer func() { end(span, err) }()
+	return s.strg.ReserveIdempotencyKey(userID, key, fingerprint, expiredBefore)
+}
+
+func (s *Storage) SaveIdempotentResponse(userID, key string, response storage.IdempotentResponse) (err error) {
+	span := s.start("SaveIdempotentResponse")
+	defer func() { end(span, err) }()
+	return s.strg.SaveIdempotentResponse(userID, key, response)
+}
+
+func (s *Storage) ReleaseIdempotencyKey(userID, key string) (err error) {
+	span := s.start("ReleaseIdempotencyKey")
+	defer func() { end(span, err) }()
+	return s.strg.ReleaseIdempotencyKey(userID, key)
+}
+
+func (s *Storage) IncrementRateCounter(bucket string, windowStart time.Time, window time.Duration) (_ int, err error) {
+	span := s.start("IncrementRateCounter")
+	defer func() { end(span, err) }()
+	return s.strg.IncrementRateCounter(bucket, windowStart, window)
+}
+
+func (s *Storage) PruneIdempotencyKeys(expiredBefore time.Time) (err error) {
+	span := s.start("PruneIdempotencyKeys")
+	defer func() { end(span, err) }()
+	return s.strg.PruneIdempotencyKeys(expiredBefore)
+}
+
+func (s *Storage) PruneRateCounters(expiredBefore time.Time) (err error) {
+	span := s.start("PruneRateCounters")
+	defer func() { end(span, err) }()
+	return s.strg.PruneRateCounters(expiredBefore)
+}
+
+func (s *Storage) ReserveLoginAttempt(subject string, limits storage.LoginLimits) (_ storage.LoginFailures, err error) {
+	span := s.start("ReserveLoginAttempt")
+	defer func() { end(span, err) }()
+	return s.strg.ReserveLoginAttempt(subject, limits)
+}
+
+func (s *Storage) ReleaseLoginAttempt(subject string) (err error) {
+	span := s.start("ReleaseLoginAttempt")
+	defer func() { end(span, err) }()
+	return s.strg.ReleaseLoginAttempt(subject)
+}
+
+func (s *Storage) ResetLoginFailures(subject string) (err error) {
+	span := s.start("ResetLoginFailures")
+	defer func() { end(span, err) }()
+	return s.strg.ResetLoginFailures(subject)
+}
+
+func (s *Storage) AddAuditEntry(entry storage.AuditEntry) (err error) {
+	span := s.start("AddAuditEntry")
+	defer func() { end(span, err) }()
+	return s.strg.AddAuditEntry(entry)
+}
+
+func (s *Storage) AuditEntries(filter storage.AuditFilter) (_ []byte, err error) {
+	span := s.start("AuditEntries")
+	defer func() { end(span, err) }()
+	return s.strg.AuditEntries(filter)
+}
+
+func (s *Storage) SealAudit() (_ int, err error) {
+	span := s.start("SealAudit")
+	defer func() { end(span, err) }()
+	return s.strg.SealAudit()
+}
+
+func (s *Storage) AddWebhook(webhook storage.Webhook) (err error) {
+	span := s.start("AddWebhook")
+	defer func() { end(span, err) }()
+	return s.strg.AddWebhook(webhook)
+}
+
+func (s *Storage) DeleteWebhook(userID, webhookID string) (err error) {
+	span := s.start("DeleteWebhook")
+	defer func() { end(span, err) }()
+	return s.strg.DeleteWebhook(userID, webhookID)
+}
+
+func (s *Storage) UserWebhooks(userID string) (_ []byte, err error) {
+	span := s.start("UserWebhooks")
+	defer func() { end(span, err) }()
+	return s.strg.UserWebhooks(userID)
+}
+
+func (s *Storage) WebhookDeliveries(userID, webhookID string) (_ []byte, err error) {
+	span := s.start("WebhookDeliveries")
+	defer func() { end(span, err) }()
+	return s.strg.WebhookDeliveries(userID, webhookID)
+}
+
+func (s *Storage) DispatchOutbox() (_ int, err error) {
+	span := s.start("DispatchOutbox")
+	defer func() { end(span, err) }()
+	return s.strg.DispatchOutbox()
+}
+
+func (s *Storage) ClaimDeliveries(limit int, lease time.Duration) (_ []storage.WebhookDelivery, err error) {
+	span := s.start("ClaimDeliveries")
+	defer func() { end(span, err) }()
+	return s.strg.ClaimDeliveries(limit, lease)
+}
+
+func (s *Storage) RecordDeliveryAttempt(attempt storage.DeliveryAttempt, nextAttempt time.Time) (err error) {
+	span := s.start("RecordDeliveryAttempt")
+	defer func() { end(span, err) }()
+	return s.strg.RecordDeliveryAttempt(attempt, nextAttempt)
+}
+
+func (s *Storage) PruneOutbox(dispatchedBefore time.Time) (_ int, err error) {
+	span := s.start("PruneOutbox")
+	defer func() { end(span, err) }()
+	return s.strg.PruneOutbox(dispatchedBefore)
+}
+
+// ListenEvents runs until ctx is done, so it gets no span.
+func (s *Storage) ListenEvents(ctx context.Context, fn func(userID string, id int64)) error {
+	return s.strg.ListenEvents(ctx, fn)
+}
+
+func (s *Storage) UserEvents(userID string, afterID int64, limit int) (_ []storage.Event, err error) {
+	span := s.start("UserEvents")
+	defer func() { end(span, err) }()
+	return s.strg.UserEvents(userID, afterID, limit)
+}
+
+func (s *Storage) LastEventID(userID string) (_ int64, err error) {
+	span := s.start("LastEventID")
+	defer func() { end(span, err) }()
+	return s.strg.LastEventID(userID)
+}
+
+func (s *Storage) Stats() (_ storage.Stats, err error) {
+	span := s.start("Stats")
+	defer func() { end(span, err) }()
+	return s.strg.Stats()
+}
+
+func (s *Storage) Ping(ctx context.Context) (err error) {
+	span := s.start("Ping")
+	defer func() { end(span, err) }()
+	return s.strg.Ping(ctx)
+}
+
+func (s *Storage) SchemaVersion() (_ int, err error) {
+	span := s.start("SchemaVersion")
+	defer func() { end(span, err) }()
+	return s.strg.SchemaVersion()
+}
+
+func (s *Storage) CloseDB() {
+	s.strg.CloseDB()
+}
@@ -0,0 +1,115 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"gophermart/internal/config"
+	"gophermart/internal/storage"
+)
+
+// checkUserStorage answers CheckUser only, any other call panics on the nil Storager.
+type checkUserStorage struct {
+	storage.Storager
+}
+
+func (checkUserStorage) CheckUser(userID string) error {
+	return storage.ErrAuthError
+}
+
+func testRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}
+
+func TestRequestTrace(t *testing.T) {
+	recorder := testRecorder(t)
+
+	var outgoing http.Header
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outgoing = r.Header.Clone()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer accrual.Close()
+	client := &http.Client{Transport: NewTransport(http.DefaultTransport)}
+	strg := NewStorage(checkUserStorage{})
+
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/api/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+		err := storage.WithContext(r.Context(), strg).CheckUser("user")
+		assert.ErrorIs(t, err, storage.ErrAuthError)
+		request, err := http.NewRequestWithContext(r.Context(), http.MethodGet, accrual.URL, nil)
+		require.NoError(t, err)
+		response, err := client.Do(request)
+		require.NoError(t, err)
+		response.Body.Close()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/api/orders/12345678903", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), request)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		byName[span.Name()] = span
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String(), span.Name())
+	}
+	server := byName["GET /api/orders/{number}"]
+	require.NotNil(t, server)
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	require.NotNil(t, byName["storage.CheckUser"])
+	assert.Equal(t, server.SpanContext().SpanID(), byName["storage.CheckUser"].Parent().SpanID())
+	require.NotNil(t, byName["HTTP GET"])
+	assert.Equal(t, server.SpanContext().SpanID(), byName["HTTP GET"].Parent().SpanID())
+
+	assert.Contains(t, outgoing.Get("traceparent"), "4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.Contains(t, outgoing.Get("traceparent"), byName["HTTP GET"].SpanContext().SpanID().String())
+}
+
+func TestStorageWithoutContext(t *testing.T) {
+	recorder := testRecorder(t)
+
+	err := NewStorage(checkUserStorage{}).CheckUser("user")
+	assert.ErrorIs(t, err, storage.ErrAuthError)
+	err = storage.WithContext(context.Background(), checkUserStorage{}).CheckUser("user")
+	assert.ErrorIs(t, err, storage.ErrAuthError)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.False(t, spans[0].Parent().IsValid())
+	assert.Equal(t, "storage.CheckUser", spans[0].Name())
+}
+
+func TestSetupFileExporter(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	shutdown, err := Setup(&config.Config{TracingExporter: "file", TracingFile: path, TracingSampleRatio: 1})
+	require.NoError(t, err)
+	_, span := StartSpan(context.Background(), "test.span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	traces, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(traces), "test.span")
+}
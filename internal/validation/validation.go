@@ -0,0 +1,206 @@
+package validation
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/config"
+)
+
+var (
+	// ErrMalformed marks input that does not match the request format (400).
+	ErrMalformed error = errors.New("malformed request")
+	// ErrInvalid marks well-formed input rejected by business rules (422).
+	ErrInvalid error = errors.New("invalid value")
+)
+
+type FieldError struct {
+	Field  string
+	Reason string
+	Kind   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Reason
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Kind
+}
+
+func malformed(field, format string, args ...interface{}) error {
+	return &FieldError{Field: field, Reason: fmt.Sprintf(format, args...), Kind: ErrMalformed}
+}
+
+func invalid(field, format string, args ...interface{}) error {
+	return &FieldError{Field: field, Reason: fmt.Sprintf(format, args...), Kind: ErrInvalid}
+}
+
+type Validator struct {
+	loginMinLen    int
+	loginMaxLen    int
+	loginCharset   *regexp.Regexp
+	passwordMinLen int
+	passwordMaxLen int
+	orderMinLen    int
+	orderMaxLen    int
+	denylist       map[string]struct{}
+}
+
+func NewValidator(cfg *config.Config) (*Validator, error) {
+	charset, err := regexp.Compile(cfg.LoginCharset)
+	if err != nil {
+		return nil, fmt.Errorf("login charset: %w", err)
+	}
+	v := &Validator{
+		loginMinLen:    cfg.LoginMinLength,
+		loginMaxLen:    cfg.LoginMaxLength,
+		loginCharset:   charset,
+		passwordMinLen: cfg.PasswordMinLength,
+		passwordMaxLen: cfg.PasswordMaxLength,
+		orderMinLen:    cfg.OrderMinLength,
+		orderMaxLen:    cfg.OrderMaxLength,
+		denylist:       make(map[string]struct{}),
+	}
+	if cfg.PasswordDenylistFile != "" {
+		if err = v.loadDenylist(cfg.PasswordDenylistFile); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// loadDenylist reads one breached password per line; 40 hex digit lines are taken as SHA-1 hashes.
+func (v *Validator) loadDenylist(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if hash, _, found := strings.Cut(line, ":"); found && len(hash) == 40 {
+			line = hash
+		}
+		if _, err := hex.DecodeString(line); err == nil && len(line) == 40 {
+			v.denylist[strings.ToUpper(line)] = struct{}{}
+			continue
+		}
+		v.denylist[passwordHash(line)] = struct{}{}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	log.Info().Msgf("password denylist loaded: %d entries", len(v.denylist))
+	return nil
+}
+
+func passwordHash(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// Registration checks new credentials against the login and password policies and returns the trimmed login.
+func (v *Validator) Registration(login, password string) (string, error) {
+	login = strings.TrimSpace(login)
+	length := utf8.RuneCountInString(login)
+	if length == 0 {
+		return "", malformed("login", "is empty")
+	}
+	if length < v.loginMinLen || length > v.loginMaxLen {
+		return "", malformed("login", "length must be between %d and %d", v.loginMinLen, v.loginMaxLen)
+	}
+	if !v.loginCharset.MatchString(login) {
+		return "", malformed("login", "contains forbidden characters")
+	}
+
+	length = utf8.RuneCountInString(password)
+	if length == 0 {
+		return "", malformed("password", "is empty")
+	}
+	if !utf8.ValidString(password) {
+		return "", malformed("password", "is not valid UTF-8")
+	}
+	if length < v.passwordMinLen || length > v.passwordMaxLen {
+		return "", malformed("password", "length must be between %d and %d", v.passwordMinLen, v.passwordMaxLen)
+	}
+	if _, found := v.denylist[passwordHash(password)]; found {
+		return "", malformed("password", "is found in a breached passwords list")
+	}
+	return login, nil
+}
+
+// LogIn only checks credentials are present, so users registered under older policies can still sign in.
+func (v *Validator) LogIn(login, password string) (string, error) {
+	login = strings.TrimSpace(login)
+	if login == "" {
+		return "", malformed("login", "is empty")
+	}
+	if utf8.RuneCountInString(login) > v.loginMaxLen {
+		return "", malformed("login", "is too long")
+	}
+	if password == "" {
+		return "", malformed("password", "is empty")
+	}
+	if utf8.RuneCountInString(password) > v.passwordMaxLen {
+		return "", malformed("password", "is too long")
+	}
+	return login, nil
+}
+
+// Order trims the order number and checks its length, digits and Luhn checksum.
+func (v *Validator) Order(raw string) (string, error) {
+	order := strings.TrimSpace(raw)
+	if order == "" {
+		return "", malformed("order", "is empty")
+	}
+	for _, r := range order {
+		if r < '0' || r > '9' {
+			return "", invalid("order", "must contain digits only")
+		}
+	}
+	if len(order) < v.orderMinLen || len(order) > v.orderMaxLen {
+		return "", invalid("order", "length must be between %d and %d", v.orderMinLen, v.orderMaxLen)
+	}
+	if !Luhn(order) {
+		return "", invalid("order", "fails Luhn check")
+	}
+	return order, nil
+}
+
+// Luhn validates the checksum of a non-empty digit string.
+func Luhn(number string) bool {
+	if number == "" {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,74 @@
+package validation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/config"
+)
+
+func testConfig(t *testing.T) *config.Config {
+	denylist := filepath.Join(t.TempDir(), "denylist.txt")
+	require.NoError(t, os.WriteFile(denylist, []byte("# top passwords\nqwerty123\n7C4A8D09CA3762AF61E59520943DC26494F8941B:24230577\n"), 0o600))
+	return &config.Config{
+		LoginMinLength:       3,
+		LoginMaxLength:       16,
+		LoginCharset:         "^[!-~]+$",
+		PasswordMinLength:    6,
+		PasswordMaxLength:    32,
+		PasswordDenylistFile: denylist,
+		OrderMinLength:       2,
+		OrderMaxLength:       20,
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	v, err := NewValidator(testConfig(t))
+	require.NoError(t, err)
+
+	login, err := v.Registration("  gopher ", "s3cret-pass")
+	require.NoError(t, err)
+	assert.Equal(t, "gopher", login)
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+	}{
+		{name: "empty login", login: " ", password: "s3cret-pass"},
+		{name: "short login", login: "go", password: "s3cret-pass"},
+		{name: "unicode login", login: "гофер", password: "s3cret-pass"},
+		{name: "empty password", login: "gopher", password: ""},
+		{name: "short password", login: "gopher", password: "12345"},
+		{name: "plain denylist", login: "gopher", password: "qwerty123"},
+		{name: "hashed denylist", login: "gopher", password: "123456"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Registration(tt.login, tt.password)
+			assert.ErrorIs(t, err, ErrMalformed)
+		})
+	}
+}
+
+func TestOrder(t *testing.T) {
+	v, err := NewValidator(testConfig(t))
+	require.NoError(t, err)
+
+	order, err := v.Order("12345678903\n")
+	require.NoError(t, err)
+	assert.Equal(t, "12345678903", order)
+
+	_, err = v.Order("")
+	assert.ErrorIs(t, err, ErrMalformed)
+	_, err = v.Order("12345678902")
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = v.Order("1234a678903")
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = v.Order("123456789012345678903")
+	assert.ErrorIs(t, err, ErrInvalid)
+}
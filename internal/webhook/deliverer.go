@@ -0,0 +1,145 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"gophermart/internal/config"
+	"gophermart/internal/metrics"
+	"gophermart/internal/storage"
+)
+
+const (
+	batchSize = 50
+	// pruneInterval is how often the events older than the retention are deleted.
+	pruneInterval = time.Hour
+	// maxDrainBody limits how much of a response is read to reuse the connection; the body
+	// itself is never stored.
+	maxDrainBody = 4096
+)
+
+// Deliverer fans out outbox events to webhook deliveries and sends the due ones.
+type Deliverer struct {
+	interval    time.Duration
+	maxAttempts int
+	backoffBase time.Duration
+	backoffMax  time.Duration
+	retention   time.Duration
+	lastPrune   time.Time
+	client      *http.Client
+	ctx         context.Context
+	cancel      context.CancelFunc
+	finished    chan struct{}
+}
+
+func NewDeliverer(cfg *config.Config) *Deliverer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Deliverer{
+		interval:    cfg.WebhookInterval,
+		maxAttempts: cfg.WebhookMaxAttempts,
+		backoffBase: cfg.WebhookBackoffBase,
+		backoffMax:  cfg.WebhookBackoffMax,
+		retention:   cfg.OutboxRetention,
+		client:      newClient(cfg.WebhookTimeout),
+		ctx:         ctx,
+		cancel:      cancel,
+		finished:    make(chan struct{}),
+	}
+}
+
+func (d *Deliverer) Run(strg storage.Storager) {
+	go func() {
+		log.Debug().Msg("Deliverer started")
+		metrics.WorkerStarted("webhook_deliverer")
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+	loop:
+		for {
+			if _, err := strg.DispatchOutbox(); err != nil {
+				log.Error().Err(err).Msg("Deliverer DispatchOutbox error")
+			}
+			// The batch is sent one delivery after another, so each stays claimed for longer than
+			// the client may take to send the whole batch.
+			deliveries, err := strg.ClaimDeliveries(batchSize, batchSize*d.client.Timeout+time.Minute)
+			if err != nil {
+				log.Error().Err(err).Msg("Deliverer ClaimDeliveries error")
+			}
+			for _, delivery := range deliveries {
+				d.deliver(strg, delivery)
+			}
+			d.prune(strg)
+			metrics.WorkerRan("webhook_deliverer")
+			select {
+			case <-d.ctx.Done():
+				break loop
+			case <-ticker.C:
+			}
+		}
+		metrics.WorkerStopped("webhook_deliverer")
+		close(d.finished)
+		log.Debug().Msg("Deliverer finished")
+	}()
+}
+
+func (d *Deliverer) deliver(strg storage.Storager, delivery storage.WebhookDelivery) {
+	attempt := storage.DeliveryAttempt{DeliveryID: delivery.ID, Attempt: delivery.Attempt}
+	started := time.Now()
+	request, err := http.NewRequestWithContext(d.ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err == nil {
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(HeaderEvent, delivery.Event)
+		request.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+		request.Header.Set(HeaderTimestamp, strconv.FormatInt(started.Unix(), 10))
+		request.Header.Set(HeaderSignature, Sign(delivery.Secret, started, delivery.Payload))
+		var response *http.Response
+		response, err = d.client.Do(request)
+		if err == nil {
+			attempt.StatusCode = response.StatusCode
+			io.Copy(io.Discard, io.LimitReader(response.Body, maxDrainBody))
+			response.Body.Close()
+			attempt.Delivered = response.StatusCode >= 200 && response.StatusCode < 300
+		}
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	attempt.Duration = time.Since(started)
+
+	var nextAttempt time.Time
+	if !attempt.Delivered && delivery.Attempt < d.maxAttempts {
+		nextAttempt = time.Now().Add(Backoff(delivery.Attempt, d.backoffBase, d.backoffMax))
+	}
+	if !attempt.Delivered {
+		log.Warn().Msgf("webhook delivery %d attempt %d failed: %d %s", delivery.ID, delivery.Attempt, attempt.StatusCode, attempt.Error)
+	}
+	if err = strg.RecordDeliveryAttempt(attempt, nextAttempt); err != nil {
+		log.Error().Err(err).Msg("Deliverer RecordDeliveryAttempt error")
+	}
+}
+
+// prune deletes the settled events older than the retention at most once per pruneInterval.
+func (d *Deliverer) prune(strg storage.Storager) {
+	if d.retention <= 0 || time.Since(d.lastPrune) < pruneInterval {
+		return
+	}
+	d.lastPrune = time.Now()
+	pruned, err := strg.PruneOutbox(time.Now().Add(-d.retention))
+	if err != nil {
+		log.Error().Err(err).Msg("Deliverer PruneOutbox error")
+		return
+	}
+	if pruned > 0 {
+		log.Debug().Msgf("Deliverer pruned %d outbox events", pruned)
+	}
+}
+
+func (d *Deliverer) Stop() {
+	d.cancel()
+	<-d.finished
+}
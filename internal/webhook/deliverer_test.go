@@ -0,0 +1,29 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/config"
+)
+
+func TestPruneOncePerInterval(t *testing.T) {
+	strg := &fakeStorage{}
+	d := NewDeliverer(&config.Config{WebhookTimeout: time.Second, OutboxRetention: 24 * time.Hour})
+
+	d.prune(strg)
+	d.prune(strg)
+	require.Len(t, strg.pruned, 1)
+	assert.WithinDuration(t, time.Now().Add(-24*time.Hour), strg.pruned[0], time.Minute)
+
+	d.lastPrune = time.Now().Add(-pruneInterval)
+	d.prune(strg)
+	assert.Len(t, strg.pruned, 2)
+
+	d = NewDeliverer(&config.Config{WebhookTimeout: time.Second})
+	d.prune(strg)
+	assert.Len(t, strg.pruned, 2, "no retention keeps every event")
+}
@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gophermart/internal/config"
+	"gophermart/internal/storage"
+)
+
+func TestPublicIP(t *testing.T) {
+	for address, public := range map[string]bool{
+		"203.0.113.10":     true,
+		"8.8.8.8":          true,
+		"2001:4860::8888":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"224.0.0.1":        false,
+		"::1":              false,
+		"::":               false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+		"::ffff:10.0.0.1":  false,
+	} {
+		assert.Equal(t, public, PublicIP(net.ParseIP(address)), address)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	ctx := context.Background()
+	assert.NoError(t, CheckURL(ctx, "https://203.0.113.10/hooks"))
+	assert.NoError(t, CheckURL(ctx, "http://[2001:db8::1]:8080/hooks"))
+	for _, rawURL := range []string{
+		"http://127.0.0.1:8080/hooks",
+		"http://10.0.0.5/hooks",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]/hooks",
+		"http://localhost/hooks",
+	} {
+		assert.ErrorIs(t, CheckURL(ctx, rawURL), ErrForbiddenAddress, rawURL)
+	}
+	for _, rawURL := range []string{"ftp://203.0.113.10/", "/hooks", "http:///hooks", "%"} {
+		err := CheckURL(ctx, rawURL)
+		assert.Error(t, err, rawURL)
+		assert.NotErrorIs(t, err, ErrForbiddenAddress, rawURL)
+	}
+}
+
+type fakeStorage struct {
+	storage.Storager
+	mu       sync.Mutex
+	attempts []storage.DeliveryAttempt
+	pruned   []time.Time
+}
+
+func (s *fakeStorage) PruneOutbox(dispatchedBefore time.Time) (int, error) {
+	s.pruned = append(s.pruned, dispatchedBefore)
+	return 0, nil
+}
+
+func (s *fakeStorage) RecordDeliveryAttempt(attempt storage.DeliveryAttempt, nextAttempt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts = append(s.attempts, attempt)
+	return nil
+}
+
+func TestDeliverRefusesInternalAddress(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		http.Error(w, "internal secrets", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	strg := &fakeStorage{}
+	d := NewDeliverer(&config.Config{WebhookTimeout: time.Second, WebhookMaxAttempts: 3, WebhookBackoffBase: time.Second, WebhookBackoffMax: time.Minute})
+	d.deliver(strg, storage.WebhookDelivery{ID: 1, Attempt: 1, URL: server.URL, Secret: "secret", Event: "order.processed", Payload: []byte(`{}`)})
+
+	assert.False(t, called, "the internal server must not be reached")
+	require.Len(t, strg.attempts, 1)
+	assert.False(t, strg.attempts[0].Delivered)
+	assert.Zero(t, strg.attempts[0].StatusCode)
+	assert.Contains(t, strg.attempts[0].Error, ErrForbiddenAddress.Error())
+}
+
+func TestDeliverKeepsOnlyStatusCode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal secrets", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	strg := &fakeStorage{}
+	d := NewDeliverer(&config.Config{WebhookTimeout: time.Second, WebhookMaxAttempts: 3, WebhookBackoffBase: time.Second, WebhookBackoffMax: time.Minute})
+	// The test server listens on loopback, so the address check is lifted for it.
+	d.client = &http.Client{Timeout: time.Second}
+	d.deliver(strg, storage.WebhookDelivery{ID: 1, Attempt: 1, URL: server.URL, Secret: "secret", Event: "order.processed", Payload: []byte(`{}`)})
+
+	require.Len(t, strg.attempts, 1)
+	assert.Equal(t, http.StatusInternalServerError, strg.attempts[0].StatusCode)
+	assert.Empty(t, strg.attempts[0].Error)
+}
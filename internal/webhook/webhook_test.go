@@ -0,0 +1,28 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	timestamp := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"order.processed"}`)
+	signature := Sign("secret", timestamp, body)
+	assert.Equal(t, signature, Sign("secret", timestamp, body))
+	assert.Len(t, signature, len("sha256=")+64)
+	assert.NotEqual(t, signature, Sign("other", timestamp, body))
+	assert.NotEqual(t, signature, Sign("secret", timestamp.Add(time.Second), body))
+	assert.NotEqual(t, signature, Sign("secret", timestamp, []byte(`{}`)))
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := 30*time.Second, 10*time.Minute
+	assert.Equal(t, 30*time.Second, Backoff(1, base, max))
+	assert.Equal(t, time.Minute, Backoff(2, base, max))
+	assert.Equal(t, 8*time.Minute, Backoff(5, base, max))
+	assert.Equal(t, max, Backoff(6, base, max))
+	assert.Equal(t, max, Backoff(60, base, max))
+}